@@ -0,0 +1,70 @@
+package billyfs
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+//billy.Change methods
+
+// Chmod changes permission bits of a file, type of a file stays intact
+func (fs FoundationDbFs) Chmod(name string, mode os.FileMode) error {
+	return fs.change("chmod", name, true, func(tx KvTransaction, res *resolved) {
+		fs.setMode(tx, res.id, res.mode&os.ModeType|mode&^os.ModeType)
+	})
+}
+
+// Lchown changes owner of a file without following the last symlink
+func (fs FoundationDbFs) Lchown(name string, uid, gid int) error {
+	return fs.change("lchown", name, false, fs.chown(uid, gid))
+}
+
+// Chown changes owner of a file
+func (fs FoundationDbFs) Chown(name string, uid, gid int) error {
+	return fs.change("chown", name, true, fs.chown(uid, gid))
+}
+
+// Chtimes changes modification time of a file. Access time is not tracked.
+func (fs FoundationDbFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return fs.change("chtimes", name, true, func(tx KvTransaction, res *resolved) {
+		fs.touch(tx, res.id, mtime)
+	})
+}
+
+func (fs FoundationDbFs) chown(uid, gid int) func(KvTransaction, *resolved) {
+	return func(tx KvTransaction, res *resolved) {
+		bytes := make([]byte, 8)
+		binary.LittleEndian.PutUint32(bytes, uint32(uid))
+		binary.LittleEndian.PutUint32(bytes[4:], uint32(gid))
+		tx.Set(fs.node(res.id).Pack(ownerKey), bytes)
+	}
+}
+
+// change applies f to meta of an existing node
+func (fs FoundationDbFs) change(op string, name string, followLast bool, f func(KvTransaction, *resolved)) error {
+	fsPath := fs.split(name)
+
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		res, err := fs.resolve(tx, fsPath, followLast)
+		if err != nil {
+			return nil, err
+		}
+		if res.id == noNode {
+			return nil, os.ErrNotExist
+		}
+		if res.id == rootNode {
+			return nil, os.ErrPermission
+		}
+
+		f(tx, res)
+		fs.changed(tx, ChangeChmod, res.id, res.path, nil)
+		return nil, nil
+	})
+
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return nil
+}
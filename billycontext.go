@@ -0,0 +1,40 @@
+package billyfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// WithContext returns a view of filesystem whose operations fail with context.Canceled or
+// context.DeadlineExceeded once ctx is done. On fdb ctx deadline becomes transaction timeout and cancelling ctx
+// cancels in-flight transactions. Files opened through the view stay bound to ctx.
+func (fs FoundationDbFs) WithContext(ctx context.Context) FoundationDbFs {
+	fs.store = storeWithContext(fs.store, ctx)
+	return fs
+}
+
+// OpenFileContext opens a file bound to ctx, see WithContext
+func (fs FoundationDbFs) OpenFileContext(ctx context.Context, path string, flag int, perm os.FileMode) (billy.File, error) {
+	return fs.WithContext(ctx).OpenFile(path, flag, perm)
+}
+
+// withContext returns file sharing position and state with f, whose operations are bound to ctx
+func (f *FoundationDbFile) withContext(ctx context.Context) *FoundationDbFile {
+	fs := f.fs.WithContext(ctx)
+	bound := *f
+	bound.fs = &fs
+	return &bound
+}
+
+// ReadAtContext is ReadAt bound to ctx
+func (f *FoundationDbFile) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	return f.withContext(ctx).ReadAt(p, off)
+}
+
+// WriteAtContext is WriteAt bound to ctx. Writes larger than a single transaction may be partially done when
+// ctx ends, the number of bytes written is returned along with the error.
+func (f *FoundationDbFile) WriteAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	return f.withContext(ctx).WriteAt(p, off)
+}
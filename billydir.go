@@ -5,16 +5,19 @@ import (
 	"time"
 )
 
+// dirFileInfo describes the root, which exists implicitly and has no meta but modification time
 type dirFileInfo struct {
-	name string
-	mode os.FileMode
+	name    string
+	mode    os.FileMode
+	modTime time.Time
 }
 
 func (dirFileInfo) IsDir() bool {
 	return true
 }
-func (dirFileInfo) ModTime() time.Time {
-	return time.Now()
+// ModTime is the persisted modification time, zero for a root that was never modified
+func (d dirFileInfo) ModTime() time.Time {
+	return d.modTime
 }
 func (d dirFileInfo) Mode() os.FileMode {
 	return d.mode
@@ -28,3 +31,42 @@ func (dirFileInfo) Size() int64 {
 func (dirFileInfo) Sys() interface{} {
 	return nil
 }
+
+// NodeStat is what FileInfo.Sys returns for nodes of FoundationDbFs
+type NodeStat struct {
+	// Node is a stable id of a file, it survives renames
+	Node int64
+	Uid  int
+	Gid  int
+	// Version changes with every write or truncation of file content
+	Version uint64
+	// Generation tells node apart from removed nodes that had the same id, see Handle
+	Generation uint64
+}
+
+type fileInfo struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	sys     *NodeStat
+}
+
+func (f *fileInfo) IsDir() bool {
+	return f.mode.IsDir()
+}
+func (f *fileInfo) ModTime() time.Time {
+	return f.modTime
+}
+func (f *fileInfo) Mode() os.FileMode {
+	return f.mode
+}
+func (f *fileInfo) Name() string {
+	return f.name
+}
+func (f *fileInfo) Size() int64 {
+	return f.size
+}
+func (f *fileInfo) Sys() interface{} {
+	return f.sys
+}
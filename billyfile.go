@@ -3,51 +3,118 @@ package billyfs
 import (
 	"fmt"
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
-	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 	"github.com/go-git/go-billy/v5"
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 // FoundationDbFile represents a file in foundation db
 type FoundationDbFile struct {
 	fs              *FoundationDbFs
-	sp              *directory.DirectorySubspace
+	sp              subspace.Subspace
+	id              int64
+	handle          Handle
+	name            string
+	flag            int
 	protocolVersion int8
 	data            *filedata
 }
 
 type filedata struct {
-	pos int64
-	len int64
+	pos    int64
+	closed bool
+	// listed is the name of the last directory entry returned by Readdir
+	listed string
+	// lock is the lock held by the file, see Lock
+	lock *fileLock
+	// owner holds range locks of the file, see LockRange
+	owner *LockOwner
+	// versioned is set once content the file overwrites is kept as a version, see WithFileVersions
+	versioned bool
 }
 
 var _ billy.File = &FoundationDbFile{}
 
-// NewFile creates a struct
+// NewFile opens a file according to os.OpenFile flags. O_CREATE creates missing parent directories as well.
+// Directories can only be opened read-only.
 func NewFile(fs *FoundationDbFs, path string, flag int, perm os.FileMode) (*FoundationDbFile, error) {
-	// allocates new logical file
-	file, err := fs.db.ReadTransact(func(t fdb.ReadTransaction) (interface{}, error) {
-		node, err := nodeOrRoot(t, fs.split(path))
+	fsPath := fs.split(path)
+
+	// a transaction without writes commits as cheap as a read-only one
+	h, err := fs.transact(func(t KvTransaction) (interface{}, error) {
+		id, err := fs.open(t, fsPath, flag, perm)
 		if err != nil {
 			return nil, err
 		}
+		generation, err := fs.generation(t, id)
+		return handleOf(id, generation), err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
 
-		if sp, ok := node.(directory.DirectorySubspace); !ok {
-			return nil, fmt.Errorf("can_not_open_root")
-		} else {
-			return &FoundationDbFile{fs: fs, sp: &sp, data: &filedata{}}, nil
-		}
+	return fs.file(h.(Handle), fsPath, flag), nil
+}
 
-	})
+// open resolves node of a file to open according to flag, creating or truncating it
+func (fs *FoundationDbFs) open(t KvTransaction, fsPath []string, flag int, perm os.FileMode) (int64, error) {
+	create := flag&os.O_CREATE != 0
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
 
+	if create && len(fsPath) > 0 {
+		if _, err := fs.mkdirAll(t, fsPath[0:len(fsPath)-1], &fileModeApplicator{perm: defaultDirectoryMode}); err != nil {
+			return noNode, err
+		}
+	}
+
+	res, err := fs.resolve(t, fsPath, true)
 	if err != nil {
-		return nil, err
+		return noNode, err
+	}
+
+	switch {
+	case res.id == noNode && !create:
+		return noNode, os.ErrNotExist
+	case res.id == noNode:
+		if res.id, err = fs.allocate(t); err != nil {
+			return noNode, err
+		}
+		fs.initNode(t, res.id, perm&^os.ModeType)
+		fs.link(t, res.parent, res.name, res.id)
+		fs.changed(t, ChangeCreate, res.id, res.path, nil)
+	case create && flag&os.O_EXCL != 0:
+		return noNode, os.ErrExist
+	case res.mode.IsDir() && writable:
+		return noNode, syscall.EISDIR
+	case flag&os.O_TRUNC != 0 && writable:
+		if err = fs.truncate(t, res.id, 0); err != nil {
+			return noNode, err
+		}
+		if err = fs.modified(t, res.id); err != nil {
+			return noNode, err
+		}
 	}
 
-	return file.(*FoundationDbFile), nil
+	return res.id, nil
+}
+
+// file makes file of node of handle h, which is checked already
+func (fs *FoundationDbFs) file(h Handle, fsPath []string, flag int) *FoundationDbFile {
+	id, _, _ := h.node()
+	return &FoundationDbFile{
+		fs:     fs,
+		sp:     fs.node(id),
+		id:     id,
+		handle: h,
+		name:   filepath.Join(fsPath...),
+		flag: flag,
+		// truncation by open kept content it dropped already
+		data: &filedata{versioned: flag&os.O_TRUNC != 0},
+	}
 }
 
 // Open does nothing
@@ -68,10 +135,21 @@ func (f *FoundationDbFile) Read(p []byte) (n int, err error) {
 
 const rEADSIZE int64 = 1024
 
+// wRITEBATCH is the default number of buckets written in one transaction, it keeps transactions well below fdb
+// 10MB limit
+const wRITEBATCH = 256
+
 // Write writes bytes in write position. Stateful!
 func (f *FoundationDbFile) Write(p []byte) (int, error) {
+	if err := f.checkWritable("write"); err != nil {
+		return 0, err
+	}
 
-	written, err := f.WriteAt(p, f.data.pos)
+	if f.flag&os.O_APPEND != 0 {
+		return f.append(p)
+	}
+
+	written, err := f.writeAt(p, f.data.pos)
 	if written > 0 {
 		f.data.pos += int64(written)
 	}
@@ -80,6 +158,38 @@ func (f *FoundationDbFile) Write(p []byte) (int, error) {
 
 }
 
+// append writes p at the end of file a write batch at a time, every batch finds the end of file in the transaction
+// writing it, so concurrent appenders do not overwrite each other
+func (f *FoundationDbFile) append(p []byte) (int, error) {
+	written := 0
+	for {
+		var end int64
+		n, err := f.fs.transact(func(tx KvTransaction) (interface{}, error) {
+			size, err := f.fs.size(tx, f.id)
+			if err != nil {
+				return 0, err
+			}
+			chunk := p[written:]
+			if max := int64(f.fs.batch())*rEADSIZE - size%rEADSIZE; int64(len(chunk)) > max {
+				chunk = chunk[:max]
+			}
+			if err = f.fs.modified(tx, f.id); err != nil {
+				return 0, err
+			}
+			end = size + int64(len(chunk))
+			return asWrite(f.sp, AsWriteOps(chunk, size, int(rEADSIZE)))(tx)
+		})
+		if err != nil {
+			return written, err
+		}
+		written += n.(int)
+		f.data.pos = end
+		if written == len(p) {
+			return written, nil
+		}
+	}
+}
+
 type writeOp struct {
 	what     []byte
 	key      tuple.Tuple
@@ -120,44 +230,93 @@ func AsWriteOps(p []byte, off int64, writeSize int) (stream []writeOp) {
 	return stream
 }
 
+// WriteAt writes p at off, it is not allowed on files opened with O_APPEND
 func (f *FoundationDbFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.checkWritable("writeat"); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: fmt.Errorf("invalid_use_of_writeat_with_append")}
+	}
+
+	return f.writeAt(p, off)
+}
+
+func (f *FoundationDbFile) writeAt(p []byte, off int64) (int, error) {
 
 	//unfortunately if off misses exact bucket start, we incur penalty of read-before-write, since we
 	// have to set only changed bytes in a target bucket
 	// alternatively, slice p[] with offset off can be represented as a stream of slices ,
 	//  which will have bucket key, offset and length to write less or equal than bucket size
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: syscall.EINVAL}
+	}
+
 	var written int = 0
-	var err error = nil
 
 	stream := AsWriteOps(p, off, int(rEADSIZE))
-	for i := range stream {
-		currWritten, err := f.doWrite(stream[i])
-		if currWritten < len(stream[i].what) {
-			err = io.ErrShortWrite
+	batch := f.fs.batch()
+	for start := 0; start < len(stream); start += batch {
+		end := start + batch
+		if end > len(stream) {
+			end = len(stream)
 		}
+
+		currWritten, err := f.doWrite(off+int64(written), stream[start:end])
 		written += currWritten
 		if err != nil {
-			break
+			return written, err
 		}
 	}
 
-	return written, err
+	return written, nil
 }
 
 //in theory this function is much more testable as doWrite since it does not need to be part of file.
-// it needs just a key and write op data
-func asWrite(key fdb.Key, op writeOp) func(fdb.Transaction) (interface{}, error) {
-	return func(tx fdb.Transaction) (ret interface{}, err error) {
-		return WriteBlock(tx, &NarrowGetterCast{tx}, key, op)
+// it needs just a subspace and write op data
+func asWrite(sp subspace.Subspace, ops []writeOp) func(KvTransaction) (interface{}, error) {
+	return func(tx KvTransaction) (ret interface{}, err error) {
+		written := 0
+		for i := range ops {
+			currWritten, err := WriteBlock(tx, tx, sp.Pack(ops[i].key), ops[i])
+			written += currWritten
+			if err != nil {
+				return written, err
+			}
+			if currWritten < len(ops[i].what) {
+				return written, io.ErrShortWrite
+			}
+		}
+		return written, nil
 	}
 }
 
-func (f *FoundationDbFile) doWrite(op writeOp) (int, error) {
-	//writes exactly writeOp
-
-	written, err := f.fs.db.Transact(asWrite((*f.sp).Pack(op.key), op))
+// doWrite writes ops starting at off, the first write overwriting content keeps it as a version
+func (f *FoundationDbFile) doWrite(off int64, ops []writeOp) (int, error) {
+	//writes exactly writeOps, all of them in a single transaction
+	write := asWrite(f.sp, ops)
+
+	var kept bool
+	written, err := f.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		if !f.data.versioned {
+			var err error
+			if kept, err = f.fs.keepVersion(tx, f.id, off); err != nil {
+				return 0, err
+			}
+		}
+		if err := f.fs.modified(tx, f.id); err != nil {
+			return 0, err
+		}
+		return write(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if kept {
+		f.data.versioned = true
+	}
 
-	return written.(int), err
+	return written.(int), nil
 }
 
 func findPosition(off int64, readSz int64) (key tuple.Tuple, upperBound tuple.Tuple, bucketStart int) {
@@ -167,88 +326,215 @@ func findPosition(off int64, readSz int64) (key tuple.Tuple, upperBound tuple.Tu
 	return tuple.Tuple{0xFD, 0x00, startBucket}, tuple.Tuple{0xFD, 0x01}, bucketOffset
 }
 
-type readOp struct {
-	slice   fdb.KeyValue
-	hasMore bool
-}
-
 // ReadAt function that is directly compatible with stateless NFS
 func (f *FoundationDbFile) ReadAt(p []byte, off int64) (d int, e error) {
-	var tuPack, up, slice = findPosition(off, rEADSIZE)
-	key := (*f.sp).Pack(tuPack)
-	upper := (*f.sp).Pack(up)
-
-	read, err := f.fs.db.ReadTransact(func(tx fdb.ReadTransaction) (interface{}, error) {
-		rr := tx.GetRange(
-			fdb.KeyRange{key, upper},
-			fdb.RangeOptions{2, fdb.StreamingModeExact, false})
-		kv, err := rr.GetSliceWithError()
-		if err != nil {
-			return nil, err
-		}
+	if err := f.checkReadable("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
 
+	read, err := f.fs.store.ReadTransact(func(tx KvReadTransaction) (interface{}, error) {
 		//given semantics of readrange we don't need to store file size
-		// on fs. TO obtain file size we'll get firs end-most bucket and get its length.
+		// on fs. To obtain file size we'll get end-most bucket and get its length.
 		// then file size will be lastBucket * rEADSIZE + len(lastBucket)
-		return readOp{slice: kv[0], hasMore: len(kv) > 1}, nil
-	})
+		size, err := f.fs.size(tx, f.id)
+		if err != nil || off >= size {
+			return 0, err
+		}
 
-	bytes := read.(readOp).slice.Value
-	//in case passed offset off does not hit start of the bucket, we have to read from position
-	bytes = bytes[slice:]
+		end := off + int64(len(p))
+		if end > size {
+			end = size
+		}
+		if end == off {
+			return 0, nil
+		}
 
-	//todo return EOF. funky!
-	d = copy(p, bytes)
-	if err == nil {
-		//check for EOF condition
-		// if we fully transferred bytes from last available bucket
-		if d == len(bytes) && !read.(readOp).hasMore {
-			err = io.EOF
+		first, _, _ := findPosition(off, rEADSIZE)
+		last, _, _ := findPosition(end-1, rEADSIZE)
+		kvs, err := tx.GetRange(
+			fdb.KeyRange{Begin: f.sp.Pack(first), End: append(f.sp.Pack(last), 0x00)},
+			fdb.RangeOptions{Mode: fdb.StreamingModeWantAll})
+		if err != nil {
+			return 0, err
+		}
+
+		// missing buckets are holes of a sparse file
+		n := int(end - off)
+		for i := range p[0:n] {
+			p[i] = 0
+		}
+		for _, kv := range kvs {
+			bucket, err := f.fs.bucket(f.id, kv.Key)
+			if err != nil {
+				return 0, err
+			}
+
+			start := bucket * rEADSIZE
+			from, to := start, start+int64(len(kv.Value))
+			if from < off {
+				from = off
+			}
+			if to > end {
+				to = end
+			}
+			if from < to {
+				copy(p[from-off:to-off], kv.Value[from-start:to-start])
+			}
 		}
+
+		return n, nil
+	})
+
+	if err != nil {
+		return 0, err
 	}
 
-	return d, err
+	d = read.(int)
+	if d < len(p) {
+		e = io.EOF
+	}
+
+	return d, e
 }
 
 // Seek is not compatible with NFSv3 Only makes sense in context of writing because Write is stateful
 func (f *FoundationDbFile) Seek(offset int64, i int) (int64, error) {
+	if f.data.closed {
+		return 0, os.ErrClosed
+	}
+
+	pos := f.data.pos
 	switch i {
 	case io.SeekStart:
-		f.data.pos = offset
+		pos = offset
 	case io.SeekCurrent:
-		f.data.pos += offset
+		pos += offset
 	case io.SeekEnd:
-		return 0, fmt.Errorf("end_file_seek_not_supported, %v", offset)
+		size, err := f.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+			return f.fs.size(r, f.id)
+		})
+		if err != nil {
+			return 0, err
+		}
+		pos = size.(int64) + offset
+	default:
+		return 0, fmt.Errorf("unknown_whence %v", i)
 	}
+
+	if pos < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.data.pos = pos
 	return f.data.pos, nil
 }
 
 // Truncate truncates file
 func (f *FoundationDbFile) Truncate(size int64) error {
+	if err := f.checkWritable("truncate"); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
 
 	//truncate operation is 2-fold. if we are not on exact range, then drop keys from next bucket and
-	// do bitwise and with reduced length of current bucket.
-	return nil
+	// cut or zero-extend the bucket size falls into.
+	_, err := f.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		if err := f.fs.truncate(tx, f.id, size); err != nil {
+			return nil, err
+		}
+		return nil, f.fs.modified(tx, f.id)
+	})
+
+	return err
 }
 
-// Close have no meaning in NFSv3
-func (*FoundationDbFile) Close() error {
-	//does nothing
+// Close have no meaning in NFSv3, it only invalidates this handle
+func (f *FoundationDbFile) Close() error {
+	if f.data.closed {
+		return os.ErrClosed
+	}
+	err := f.unlock()
+	if f.data.owner != nil {
+		if released := f.data.owner.Release(); err == nil {
+			err = released
+		}
+	}
+	f.data.closed = true
 
+	if err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
 	return nil
 }
 
-// Lock is not supported
-func (*FoundationDbFile) Lock() error {
+func (f *FoundationDbFile) checkReadable(op string) error {
+	if f.data.closed {
+		return os.ErrClosed
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
 	return nil
 }
 
-// Unlock is not supported
-func (*FoundationDbFile) Unlock() error {
+func (f *FoundationDbFile) checkWritable(op string) error {
+	if f.data.closed {
+		return os.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
 	return nil
 }
 
+// Stat returns file info of the node even when it was renamed since opening
+func (f *FoundationDbFile) Stat() (os.FileInfo, error) {
+	if f.data.closed {
+		return nil, os.ErrClosed
+	}
+	return f.fs.StatHandle(f.Handle())
+}
+
+// Readdir lists directory the same way os.File.Readdir does. Entries are listed in name order, listing resumes
+// after the last returned name, so entries added or removed meanwhile do not shift it.
+func (f *FoundationDbFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := f.checkReadable("readdir"); err != nil {
+		return nil, err
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = 0
+	}
+	list, err := f.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		mode, err := f.fs.mode(r, f.id)
+		if err != nil {
+			return nil, err
+		}
+		if !mode.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		return f.fs.list(r, f.id, f.data.listed, limit)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: err}
+	}
+
+	infos := list.([]os.FileInfo)
+	if len(infos) > 0 {
+		f.data.listed = infos[len(infos)-1].Name()
+	} else if count > 0 {
+		return nil, io.EOF
+	}
+
+	return infos, nil
+}
+
 // Name returns file name
 func (f *FoundationDbFile) Name() string {
-	return filepath.Join((*f.sp).GetPath()...)
+	return f.name
 }
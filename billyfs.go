@@ -1,45 +1,113 @@
 package billyfs
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
-	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 	pkg_errors "github.com/pkg/errors"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
-	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
 )
 
 // FoundationDbFs representds a billy filesystem over FoundationDb KV store
 type FoundationDbFs struct {
-	db fdb.Database
+	store KvStore
+	root  subspace.Subspace
+	// writeBatch is the number of buckets written in one transaction
+	writeBatch int
+	// transactLimit bounds bytes written by Transact, zero is defaultTransactLimit
+	transactLimit int64
+	// lockLease is how long locks outlive their holders, zero is defaultLockLease
+	lockLease time.Duration
+	// versioning configures versions of overwritten files
+	versioning versioning
+	// trashing configures trash of removed nodes
+	trashing trashMode
+	// snapshots enables copy-on-write writes snapshots rely on, see Snapshot
+	snapshots bool
+	// confined is the path symlinks may not lead out of, see Confine
+	confined []string
 }
 
 // ensure that FoundationDbFs fulfills interfaces
 var _ billy.Basic = FoundationDbFs{}
 var _ billy.Dir = FoundationDbFs{}
 var _ billy.Capable = FoundationDbFs{}
+var _ billy.Filesystem = FoundationDbFs{}
+var _ billy.Change = FoundationDbFs{}
+
+// NewFoundationDbFs Creates new FoundationDBFs, DefaultAPIVersion is selected unless it was selected already
+func NewFoundationDbFs(clusterFile string) (FoundationDbFs, error) {
+	return NewFoundationDbFsWithOptions(WithClusterFile(clusterFile))
+}
 
-func init() {
-	fdb.APIVersion(620)
+// NewFoundationDbFsFromStore Creates new FoundationDBFs over any KvStore, e.g. MemoryStore
+func NewFoundationDbFsFromStore(store KvStore) FoundationDbFs {
+	return FoundationDbFs{store: store, root: subspace.Sub("billyfs"), writeBatch: wRITEBATCH}
 }
 
-// NewFoundationDbFs Creates new FoundationDBFs
-func NewFoundationDbFs(clusterFile string) (FoundationDbFs, error) {
-	//fdb.setAPIVersion
-	db, error := fdb.OpenDatabase(clusterFile)
-	if error != nil {
-		return FoundationDbFs{}, error
+// NodeSubspace returns the subspace layers built on top of the filesystem keep their own keys of node in, it is
+// ("n", node, 0xFA, layer). Keys go with the node, they are cleared when the node is removed. Write them with
+// TransactNodes, so snapshots keep the values they were taken with.
+func (fs FoundationDbFs) NodeSubspace(node int64, layer string) subspace.Subspace {
+	return fs.node(node).Sub(0xFA, layer)
+}
+
+// TransactNodes runs f in a transaction that copies keys of NodeSubspace snapshots see before f overwrites them
+func (fs FoundationDbFs) TransactNodes(f func(tx KvTransaction) (interface{}, error)) (interface{}, error) {
+	return fs.transact(f)
+}
+
+// batch is the number of buckets written in one transaction
+func (fs FoundationDbFs) batch() int {
+	if fs.writeBatch <= 0 {
+		return wRITEBATCH
 	}
+	return fs.writeBatch
+}
 
-	return FoundationDbFs{db}, nil
+// Store returns the store filesystem runs on, e.g. for layers keeping their own keys next to the filesystem
+func (fs FoundationDbFs) Store() KvStore {
+	return fs.store
+}
+
+// Subspace returns the subspace all filesystem keys are in. Subspaces of it other than "n", "e", "l", "r", "c",
+// "f", "v", "h", "t", "u" and "m" are free for layers built on top of the filesystem.
+func (fs FoundationDbFs) Subspace() subspace.Subspace {
+	return fs.root
+}
+
+// Confine returns a view of filesystem whose symlinks may not lead out of root, following a symlink there fails
+// with os.ErrPermission. Checks are made when symlinks are followed, so moving a relative symlink can't lead it out
+// either. Root itself is taken as is.
+func (fs FoundationDbFs) Confine(root string) FoundationDbFs {
+	fs.confined = fs.split(root)
+	return fs
+}
 
+// confines tells whether fsPath is below the root of Confine
+func (fs FoundationDbFs) confines(fsPath []string) bool {
+	if len(fsPath) < len(fs.confined) {
+		return false
+	}
+	for i := range fs.confined {
+		if fsPath[i] != fs.confined[i] {
+			return false
+		}
+	}
+	return true
 }
 
 //billy.Dir methods
@@ -47,7 +115,6 @@ func NewFoundationDbFs(clusterFile string) (FoundationDbFs, error) {
 // MkdirAll creates full path
 func (fs FoundationDbFs) MkdirAll(path string, perm os.FileMode) error {
 
-	//TODO : add meta key to preserve file info
 	_, err := fs.createOrGet(path, &fileModeApplicator{perm, nil})
 
 	if err != nil {
@@ -57,67 +124,48 @@ func (fs FoundationDbFs) MkdirAll(path string, perm os.FileMode) error {
 	return err
 }
 
+// fileModeApplicator initialises created directories with a mode
 type fileModeApplicator struct {
 	perm       os.FileMode
 	permAsByte []byte
 }
 
-func (p *fileModeApplicator) visit(w fdb.Transaction, step *opResult) {
+// defaultDirectoryMode is a mode of parent directories created implicitly
+const defaultDirectoryMode os.FileMode = 0755
+
+func (p *fileModeApplicator) visit(w KvTransaction, step *opResult) {
 	if step.wasCreated {
 		if p.permAsByte == nil {
 			p.permAsByte = make([]byte, 4)
-			binary.LittleEndian.PutUint32(p.permAsByte, uint32(p.perm))
+			binary.LittleEndian.PutUint32(p.permAsByte, uint32(p.perm|os.ModeDir))
 		}
-		w.Set(step.Pack(tuple.Tuple{0xFC, 0x00}), p.permAsByte)
+		w.Set(step.Pack(modeKey), p.permAsByte)
+		touch := make([]byte, 8)
+		binary.LittleEndian.PutUint64(touch, uint64(time.Now().UnixNano()))
+		w.Set(step.Pack(mtimeKey), touch)
 	}
 }
 
 type opResult struct {
 	subspace.Subspace
+	id         int64
 	wasCreated bool
 }
 
 type SpaceVisitor interface {
-	visit(w fdb.Transaction, result *opResult)
+	visit(w KvTransaction, result *opResult)
 }
 
 func (fs *FoundationDbFs) createOrGet(path string, txSpaceVisitor SpaceVisitor) (*opResult, error) {
 
 	fsPath := fs.split(path)
 
-	var out interface{}
-	var err error
-
-	for i := range fsPath {
-		out, err = fs.db.Transact(func(w fdb.Transaction) (interface{}, error) {
-			path := fsPath[0 : i+1]
-			once, err := directory.Exists(w, path)
-			if err != nil {
-				return nil, err
-			}
-			created, err := directory.CreateOrOpen(w, path, nil)
-			if err != nil {
-				return nil, err
-			}
-
-			dang := &opResult{
-				Subspace:   created,
-				wasCreated: !once,
-			}
-
-			//in reality this visitor have to be called for every node in path, since we need to
-			// apply txSpaceVisitor per subspace
-			if txSpaceVisitor != nil {
-				txSpaceVisitor.visit(w, dang)
-			}
-
-			return dang, nil
-		})
-
-		if err != nil {
-			return nil, pkg_errors.WithMessagef(err, "Unable to obtain subspace %s", path)
-		}
+	out, err := fs.transact(func(w KvTransaction) (interface{}, error) {
+		return fs.mkdirAll(w, fsPath, txSpaceVisitor)
+	})
 
+	if err != nil {
+		return nil, pkg_errors.WithMessagef(&os.PathError{Op: "mkdir", Path: path, Err: err}, "Unable to obtain subspace %s", path)
 	}
 
 	return out.(*opResult), nil
@@ -126,26 +174,23 @@ func (fs *FoundationDbFs) createOrGet(path string, txSpaceVisitor SpaceVisitor)
 // ReadDir returns all file entries in a pth
 func (fs FoundationDbFs) ReadDir(path string) ([]os.FileInfo, error) {
 	fsPath := fs.split(path)
-	list, err := fs.db.ReadTransact(func(r fdb.ReadTransaction) (interface{}, error) {
-		entries, err := directory.List(r, fsPath)
+	list, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		res, err := fs.resolve(r, fsPath, true)
 		if err != nil {
 			return nil, err
 		}
-
-		result := make([]os.FileInfo, len(entries))
-		//below is bad, since we have to read all entries for path!
-		// it's not that bad, since entries are last element, so we need to just construct subspace and unpack
-		node, err := nodeOrRoot(r, fsPath)
-		for i := range entries {
-			result[i], err = stat(r, node, entries[i])
-
+		if res.id == noNode {
+			return nil, os.ErrNotExist
+		}
+		if !res.mode.IsDir() {
+			return nil, syscall.ENOTDIR
 		}
 
-		return result, nil
+		return fs.list(r, res.id, "", 0)
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
 	}
 
 	slice, ok := list.([]os.FileInfo)
@@ -156,31 +201,64 @@ func (fs FoundationDbFs) ReadDir(path string) ([]os.FileInfo, error) {
 	return slice, nil
 }
 
-func nodeOrRoot(r fdb.ReadTransaction, p []string) (directory.Directory, error) {
-	var node directory.Directory
-	var err error
-	if len(p) == 0 {
-		node = directory.Root()
-	} else {
-		node, err = directory.Open(r, p, nil)
+// HiddenPrefix starts names of root directory entries that listings skip. Layers keep their private files under
+// such names, e.g. staging of s3gw, they are still reachable by path.
+const HiddenPrefix = ".billyfs-"
+
+// list returns file infos of directory entries following after, at most limit of them unless limit is 0. Hidden
+// entries of root are skipped.
+func (fs FoundationDbFs) list(r KvReadTransaction, id int64, after string, limit int) ([]os.FileInfo, error) {
+	b, e := fs.entries(id).FDBRangeKeys()
+	begin, end := b.FDBKey(), e.FDBKey()
+	if after != "" {
+		begin = append(fs.entries(id).Pack(tuple.Tuple{after}), 0x00)
+	}
+
+	ranges := []fdb.KeyRange{{Begin: begin, End: end}}
+	if id == rootNode {
+		// packed string is its bytes between 0x02 and 0x00, names with the prefix share the packed prefix
+		hidden := append(append(fs.entries(id).Bytes(), 0x02), HiddenPrefix...)
+		past, err := fdb.Strinc(hidden)
 		if err != nil {
 			return nil, err
 		}
+		ranges = []fdb.KeyRange{{Begin: begin, End: fdb.Key(hidden)}, {Begin: fdb.Key(past), End: end}}
+		if bytes.Compare(begin, past) > 0 {
+			ranges[1].Begin = begin
+		}
 	}
 
-	return node, nil
-
-}
-
-func stat(r fdb.ReadTransaction, p directory.Directory, n string) (os.FileInfo, error) {
-	entry, err := p.Open(r, []string{n}, nil)
-	if err != nil {
-		return nil, err
+	var entries []fdb.KeyValue
+	for _, rng := range ranges {
+		if bytes.Compare(rng.Begin.FDBKey(), rng.End.FDBKey()) >= 0 {
+			continue
+		}
+		options := fdb.RangeOptions{}
+		if limit > 0 {
+			if len(entries) >= limit {
+				break
+			}
+			options.Limit = limit - len(entries)
+		}
+		kvs, err := r.GetRange(rng, options)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, kvs...)
 	}
 
-	bytes := r.Get(entry.Pack(tuple.Tuple{0xFC, 0x00})).MustGet()
+	result := make([]os.FileInfo, len(entries))
+	for i := range entries {
+		name, child, err := fs.entry(id, entries[i])
+		if err != nil {
+			return nil, err
+		}
+		if result[i], err = fs.stat(r, child, name); err != nil {
+			return nil, err
+		}
+	}
 
-	return dirFileInfo{n, os.FileMode(binary.LittleEndian.Uint32(bytes))}, nil
+	return result, nil
 }
 
 //billy.Basic methods
@@ -197,8 +275,8 @@ func (fs FoundationDbFs) Create(path string) (billy.File, error) {
 }
 
 func (fs *FoundationDbFs) split(in string) []string {
-	//need to add normalisation
-	clean := path.Clean(in)
+	//paths are always relative to the root, so ".." can't escape it
+	clean := path.Clean("/" + in)
 	return fs.norm(strings.Split(clean, "/"))
 }
 
@@ -223,61 +301,270 @@ func (FoundationDbFs) norm(in []string) []string {
 // OpenFile full fledged call
 func (fs FoundationDbFs) OpenFile(path string, flag int, perm os.FileMode) (billy.File, error) {
 
-	return NewFile(&fs, path, flag, perm)
+	file, err := NewFile(&fs, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
 }
 
 // Remove deletes path
 func (fs FoundationDbFs) Remove(path string) error {
 
 	fsPath := fs.split(path)
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		return nil, fs.remove(tx, fsPath)
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// remove unlinks file or empty directory at fsPath and discards its node
+func (fs FoundationDbFs) remove(tx KvTransaction, fsPath []string) error {
+	if len(fsPath) == 0 {
+		return os.ErrPermission
+	}
+	res, err := fs.resolve(tx, fsPath, false)
+	if err != nil {
+		return err
+	}
+	if res.id == noNode {
+		return os.ErrNotExist
+	}
+	if res.mode.IsDir() {
+		empty, err := fs.isEmpty(tx, res.id)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return syscall.ENOTEMPTY
+		}
+	}
+
+	fs.unlink(tx, res.parent, res.name)
+	fs.changed(tx, ChangeRemove, res.id, res.path, nil)
+	return fs.discard(tx, res.id, res.path)
+}
+
+// RemoveAll removes path with everything under it, missing path is not an error. The tree is unlinked in a single
+// transaction and is invisible from then on, its nodes are removed bottom-up in batches of removeBatch nodes
+// afterwards. Removal interrupted meanwhile is finished by ResumeRemovals.
+func (fs FoundationDbFs) RemoveAll(path string) error {
+	fsPath := fs.split(path)
+	if len(fsPath) == 0 {
+		return &os.PathError{Op: "removeall", Path: path, Err: os.ErrPermission}
+	}
 
-	_, err := fs.db.Transact(func(tx fdb.Transaction) (interface{}, error) {
-		return directory.Root().Remove(tx, fsPath)
+	id, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		res, err := fs.resolve(tx, fsPath, false)
+		if err != nil {
+			return noNode, err
+		}
+		if res.id == noNode {
+			return noNode, nil
+		}
+
+		fs.unlink(tx, res.parent, res.name)
+		fs.changed(tx, ChangeRemove, res.id, res.path, nil)
+		if fs.trashing.enabled {
+			return noNode, fs.discard(tx, res.id, res.path)
+		}
+		tx.Set(fs.removals().Pack(tuple.Tuple{res.id}), []byte{})
+		return res.id, nil
 	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		err = fs.drain(id.(int64))
+	}
 
-	return err
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+
+	return nil
 }
 
-// Rename renames path
-func (FoundationDbFs) Rename(from string, to string) error {
+// Rename renames path, target is replaced when it is a file or an empty directory
+func (fs FoundationDbFs) Rename(from string, to string) error {
+	fromPath, toPath := fs.split(from), fs.split(to)
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		return nil, fs.rename(tx, fromPath, toPath)
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
 	return nil
 }
 
-// Stat obtains file meta
-func (fs FoundationDbFs) Stat(path string) (os.FileInfo, error) {
-	fsPath := fs.split(path)
+// rename moves node at fromPath to toPath, replacing a file or an empty directory there
+func (fs FoundationDbFs) rename(tx KvTransaction, fromPath []string, toPath []string) error {
+	if len(fromPath) == 0 || len(toPath) == 0 {
+		return syscall.EINVAL
+	}
 
-	if len(fsPath) == 0 {
-		return dirFileInfo{name: "/", mode: os.ModeDir | os.ModePerm}, nil
+	src, err := fs.resolve(tx, fromPath, false)
+	if err != nil {
+		return err
+	}
+	if src.id == noNode {
+		return os.ErrNotExist
 	}
 
-	stat, err := fs.db.ReadTransact(func(r fdb.ReadTransaction) (interface{}, error) {
+	if _, err = fs.mkdirAll(tx, toPath[0:len(toPath)-1], &fileModeApplicator{perm: defaultDirectoryMode}); err != nil {
+		return err
+	}
+	dst, err := fs.resolve(tx, toPath, false)
+	if err != nil {
+		return err
+	}
 
-		ind := len(fsPath) - 1
-		node, err := nodeOrRoot(r, fsPath[0:ind])
+	if dst.id == src.id {
+		return nil
+	}
+	if src.mode.IsDir() && isPrefix(src.path, dst.path) {
+		return syscall.EINVAL
+	}
 
+	if dst.id != noNode {
+		switch {
+		case dst.mode.IsDir() && !src.mode.IsDir():
+			return syscall.EISDIR
+		case !dst.mode.IsDir() && src.mode.IsDir():
+			return syscall.ENOTDIR
+		case dst.mode.IsDir():
+			empty, err := fs.isEmpty(tx, dst.id)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return syscall.ENOTEMPTY
+			}
+		}
+		// replaced file lives on as versions of the file replacing it unless trash keeps it
+		if fs.versioning.enabled && !fs.trashing.enabled && dst.mode.IsRegular() && src.mode.IsRegular() {
+			err = fs.inheritVersions(tx, src.id, dst.id)
+		} else {
+			err = fs.discard(tx, dst.id, dst.path)
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		fs.changed(tx, ChangeRemove, dst.id, dst.path, nil)
+	}
+
+	fs.unlink(tx, src.parent, src.name)
+	fs.link(tx, dst.parent, dst.name, src.id)
+	fs.changed(tx, ChangeRename, src.id, dst.path, src.path)
+	return nil
+}
+
+func isPrefix(prefix []string, p []string) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != p[i] {
+			return false
 		}
+	}
+	return true
+}
 
-		return stat(r, node, fsPath[ind])
+// Stat obtains file meta
+func (fs FoundationDbFs) Stat(path string) (os.FileInfo, error) {
+	return fs.statPath("stat", path, true)
+}
+
+func (fs FoundationDbFs) statPath(op string, path string, followLast bool) (os.FileInfo, error) {
+	fsPath := fs.split(path)
 
+	stat, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return fs.statNode(r, fsPath, followLast)
 	})
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: op, Path: path, Err: err}
 	}
 	return stat.(os.FileInfo), nil
 }
 
+// statNode returns file info of node at fsPath
+func (fs FoundationDbFs) statNode(r KvReadTransaction, fsPath []string, followLast bool) (os.FileInfo, error) {
+	if len(fsPath) == 0 {
+		return fs.stat(r, rootNode, "/")
+	}
+
+	res, err := fs.resolve(r, fsPath, followLast)
+	if err != nil {
+		return nil, err
+	}
+	if res.id == noNode {
+		return nil, os.ErrNotExist
+	}
+
+	return fs.stat(r, res.id, fsPath[len(fsPath)-1])
+}
+
 // Join joins path
 func (FoundationDbFs) Join(arr ...string) string {
 	return path.Join(arr...)
 }
 
+//billy.TempFile methods
+
+// TempFile creates a new file with random name in dir, dir is created when missing
+func (fs FoundationDbFs) TempFile(dir, prefix string) (billy.File, error) {
+	for try := 0; ; try++ {
+		name := fs.Join(dir, prefix+nextRandom())
+		f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) && try < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+var tempRand uint32
+var tempRandMu sync.Mutex
+
+// nextRandom is the same pseudo-random sequence ioutil.TempFile uses
+func nextRandom() string {
+	tempRandMu.Lock()
+	r := tempRand
+	if r == 0 {
+		r = uint32(time.Now().UnixNano() + int64(os.Getpid()))
+	}
+	r = r*1664525 + 1013904223
+	tempRand = r
+	tempRandMu.Unlock()
+	return strconv.Itoa(int(1e9 + r%1e9))[1:]
+}
+
+//billy.Chroot methods
+
+// Chroot returns filesystem rooted at path
+func (fs FoundationDbFs) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, fs.Join(fs.Root(), path)), nil
+}
+
+// Root is always the root of a tree
+func (FoundationDbFs) Root() string {
+	return "/"
+}
+
 //billy.Capable methods
 
 // Capabilities what fs can do
 func (FoundationDbFs) Capabilities() billy.Capability {
-	return billy.ReadAndWriteCapability | billy.SeekCapability | billy.TruncateCapability
+	return billy.WriteCapability | billy.ReadCapability | billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability | billy.LockCapability
 
 }
@@ -0,0 +1,81 @@
+package billyfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/test"
+	"github.com/go-git/go-billy/v5/util"
+	. "gopkg.in/check.v1"
+)
+
+// go-billy shared suites run over FoundationDbFs with in-memory store, so the behaviour is checked against the
+// same expectations as memfs and osfs
+func TestConformance(t *testing.T) {
+	TestingT(t)
+}
+
+type ConformanceSuite struct {
+	test.FilesystemSuite
+	fs FoundationDbFs
+}
+
+var _ = Suite(&ConformanceSuite{})
+
+func (s *ConformanceSuite) SetUpTest(c *C) {
+	s.fs = NewFoundationDbFsFromStore(NewMemoryStore())
+	s.FilesystemSuite = test.NewFilesystemSuite(s.fs)
+}
+
+// go-billy has no shared suite for billy.Change, so Change methods are checked here
+
+func (s *ConformanceSuite) TestChmod(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(s.fs.Chmod("foo", 0600), IsNil)
+
+	fi, err := s.fs.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0600))
+}
+
+func (s *ConformanceSuite) TestChmodDirKeepsType(c *C) {
+	c.Assert(s.fs.MkdirAll("dir", 0755), IsNil)
+	c.Assert(s.fs.Chmod("dir", 0700), IsNil)
+
+	fi, err := s.fs.Stat("dir")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.ModeDir|0700)
+}
+
+func (s *ConformanceSuite) TestChtimes(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", nil, 0644), IsNil)
+	at := time.Date(2020, 12, 24, 10, 0, 0, 0, time.UTC)
+	c.Assert(s.fs.Chtimes("foo", at, at), IsNil)
+
+	fi, err := s.fs.Stat("foo")
+	c.Assert(err, IsNil)
+	c.Assert(fi.ModTime().Equal(at), Equals, true)
+}
+
+func (s *ConformanceSuite) TestChownAndLchown(c *C) {
+	c.Assert(util.WriteFile(s.fs, "foo", nil, 0644), IsNil)
+	c.Assert(s.fs.Symlink("foo", "link"), IsNil)
+
+	c.Assert(s.fs.Chown("link", 1000, 1001), IsNil)
+	c.Assert(s.fs.Lchown("link", 2000, 2001), IsNil)
+
+	fi, err := s.fs.Stat("foo")
+	c.Assert(err, IsNil)
+	stat := fi.Sys().(*NodeStat)
+	c.Assert(*stat, Equals, NodeStat{Node: stat.Node, Uid: 1000, Gid: 1001, Generation: stat.Generation})
+
+	fi, err = s.fs.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Sys().(*NodeStat).Uid, Equals, 2000)
+}
+
+func (s *ConformanceSuite) TestChangeNonExistent(c *C) {
+	c.Assert(os.IsNotExist(s.fs.Chmod("missing", 0600)), Equals, true)
+	c.Assert(os.IsNotExist(s.fs.Chtimes("missing", time.Now(), time.Now())), Equals, true)
+}
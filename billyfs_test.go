@@ -3,21 +3,18 @@ package billyfs
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"runtime"
+	"syscall"
 	"testing"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
-	docker "github.com/docker/docker/client"
-	"github.com/docker/go-connections/nat"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/iggyzap/foundationdb-billyfs/fdbtest"
 	pkg_errors "github.com/pkg/errors"
 	"github.com/stretchr/testify/suite"
 )
@@ -26,26 +23,38 @@ type FsTestSuite struct {
 	suite.Suite
 	fdbfs *FoundationDbFs
 	t     *testing.T
+	// memory runs suite over MemoryStore instead of local fdbserver
+	memory bool
 }
 
 func (s *FsTestSuite) SetupSuite() {
 	s.t = s.T()
+	if s.memory {
+		fdbFs := NewFoundationDbFsFromStore(NewMemoryStore())
+		s.fdbfs = &fdbFs
+		return
+	}
+
 	defer func() {
 		handleError(s.T())
 	}()
 
-	var filePath, err = startFdb(s.t)
-	checkError(err, "Failed starting fdb for %s", filePath)
-	s.t.Cleanup(func() {
-		os.Remove(filePath)
-	})
+	server := fdbtest.Start(s.t)
 
-	var fdbFs, error = NewFoundationDbFs(filePath)
-	checkError(error, "Failed creating Fs %s for %s", error, filePath)
+	var fdbFs, error = NewFoundationDbFs(server.ClusterFile)
+	checkError(error, "Failed creating Fs %s for %s", error, server.ClusterFile)
 
 	s.fdbfs = &fdbFs
 }
 
+// subFs creates a filesystem with opts in subspace name of the suite filesystem
+func (s *FsTestSuite) subFs(name string, opts ...Option) FoundationDbFs {
+	opts = append([]Option{WithStore(s.fdbfs.Store()), WithSubspace(s.fdbfs.Subspace().Sub(name))}, opts...)
+	fs, err := NewFoundationDbFsWithOptions(opts...)
+	s.Require().NoError(err)
+	return fs
+}
+
 func (s *FsTestSuite) TearDownSuite() {
 
 }
@@ -54,6 +63,10 @@ func TestEntry(t *testing.T) {
 	suite.Run(t, new(FsTestSuite))
 }
 
+func TestMemoryEntry(t *testing.T) {
+	suite.Run(t, &FsTestSuite{memory: true})
+}
+
 func (s *FsTestSuite) TestFsCreated() {
 	s.Require().NotEmpty(s.fdbfs, "File system should be created")
 }
@@ -79,8 +92,8 @@ func (s *FsTestSuite) TestCanCreateDirAndSeesIt() {
 
 func (s *FsTestSuite) TestCreateFile() {
 
-	s.fdbfs.MkdirAll("/foo/bar", os.ModeDir|os.ModePerm)
-	//in reality, mkdir all for full path should not be needed. We should need to have /foo prefix in place
+	s.fdbfs.MkdirAll("/foo", os.ModeDir|os.ModePerm)
+	//files are not directories, so only /foo prefix has to be in place
 	file, err := s.fdbfs.Create("/foo/bar")
 	s.Assert().Empty(err, "No Errors")
 	toWrite := []byte{0xff, 0x00, 0x20}
@@ -99,8 +112,7 @@ func (s *FsTestSuite) TestReadWriteFullFile() {
 	rndContent := make([]byte, 65536)
 
 	rand.Read(rndContent)
-	s.fdbfs.MkdirAll("/foo/bar", os.ModeDir|os.ModePerm)
-	file, err := s.fdbfs.Create("/foo/bar")
+	file, err := s.fdbfs.Create("/foo/full")
 	s.Assert().Empty(err, "No Errors")
 
 	w, err := io.Copy(file, bytes.NewReader(rndContent))
@@ -115,6 +127,63 @@ func (s *FsTestSuite) TestReadWriteFullFile() {
 
 }
 
+// yieldingStore lets other goroutines run after every read transaction
+type yieldingStore struct {
+	KvStore
+}
+
+func (s yieldingStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	defer runtime.Gosched()
+	return s.KvStore.ReadTransact(f)
+}
+
+func (s *FsTestSuite) TestConcurrentAppend() {
+	// reads yield, so appenders run between reading the end of file and writing there
+	fs := s.subFs("append", WithStore(yieldingStore{s.fdbfs.Store()}), WithWriteBatch(2*int(rEADSIZE)))
+	const appenders, records = 4, 25
+
+	errs := make(chan error, appenders)
+	for i := 0; i < appenders; i++ {
+		go func(i int) {
+			f, err := fs.OpenFile("/log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer f.Close()
+			for j := 0; j < records; j++ {
+				if _, err = f.Write(bytes.Repeat([]byte{byte('a' + i)}, 100)); err != nil {
+					break
+				}
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < appenders; i++ {
+		s.Require().NoError(<-errs)
+	}
+
+	content, err := util.ReadFile(fs, "/log")
+	s.Require().NoError(err)
+	s.Require().Len(content, appenders*records*100, "Appenders do not overwrite each other")
+	for off := 0; off < len(content); off += 100 {
+		s.Equal(bytes.Repeat(content[off:off+1], 100), content[off:off+100], "Record at %d is whole", off)
+	}
+
+	// writes larger than a batch go a batch at a time from the end of file
+	f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0644)
+	s.Require().NoError(err)
+	large := make([]byte, 5*rEADSIZE)
+	rand.Read(large)
+	n, err := f.Write(large)
+	s.Require().NoError(err)
+	s.Equal(len(large), n)
+	s.Require().NoError(f.Close())
+	content, err = util.ReadFile(fs, "/log")
+	s.Require().NoError(err)
+	s.Equal(large, content[appenders*records*100:])
+}
+
 func (s *FsTestSuite) TestFewNestedDirs() {
 	s.fdbfs.MkdirAll("/foo/bar", os.ModeDir|os.ModePerm)
 	s.fdbfs.MkdirAll("/foo/baz", os.ModeDir|os.ModePerm)
@@ -176,114 +245,243 @@ func checkError(err error, s string, args ...interface{}) {
 	}
 }
 
-const FOUNDATION_DB_CONTAINER string = "foundationdb/foundationdb:6.2.25"
-
-// Starts foundation db container for specific test run. Defers container removal when test is finished.
-// WORK in progress
-func startFdb(t *testing.T) (string, error) {
-	//github actions only support version 1.40
-	var cli, err = docker.NewClientWithOpts(client.WithVersion("1.40"))
-	checkError(err, "Failed to create docker client")
-
-	pullImage(FOUNDATION_DB_CONTAINER, cli, t)
-
-	dbDef := "test:test@%s:%s"
-
-	conf := container.Config{
-		Image:        FOUNDATION_DB_CONTAINER,
-		ExposedPorts: nat.PortSet{"4500/tcp": {}},
-		Env: []string{fmt.Sprintf("%s=%s", "FDB_CLUSTER_FILE_CONTENTS", fmt.Sprintf(dbDef, "127.0.0.1", "4500")),
-			"FDB_NETWORKING_MODE=host"}}
-
-	cnt, err := cli.ContainerCreate(context.TODO(),
-		&conf,
-		&container.HostConfig{PortBindings: nat.PortMap{"4500/tcp": {{HostPort: "4500"}}}},
-		&network.NetworkingConfig{},
-		nil,
-		t.Name())
-
-	checkError(err, "Failed to create docker container")
-	t.Logf("Created container with config %+v\n", conf)
-	t.Cleanup(func() {
-		err := cli.ContainerRemove(context.TODO(), cnt.ID, types.ContainerRemoveOptions{RemoveVolumes: true, RemoveLinks: false, Force: true})
-		checkError(err, "Unable to kill container %s", cnt.ID)
-	})
+func (s *FsTestSuite) TestContextCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	err = cli.ContainerStart(context.TODO(), cnt.ID, types.ContainerStartOptions{})
-	checkError(err, "Failed to start container %s", cnt.ID)
+	err := s.fdbfs.WithContext(ctx).MkdirAll("/ctx/canceled", os.ModePerm)
+	s.True(errors.Is(err, context.Canceled), "Canceled context fails mkdir, got %v", err)
 
-	//run DB init with file. optionally supply different entry point.
-	id, err := cli.ContainerExecCreate(context.TODO(), cnt.ID, types.ExecConfig{Cmd: []string{"fdbcli", "--exec", "configure new single ssd ; status"}})
-	checkError(err, "Unable to create execution to init DB")
-	err = cli.ContainerExecStart(context.TODO(), id.ID, types.ExecStartCheck{})
-	checkError(err, "Unable to init db")
+	_, err = s.fdbfs.Stat("/ctx/canceled")
+	s.True(os.IsNotExist(err), "Nothing is created")
+}
 
-	json, err := cli.ContainerInspect(context.TODO(), cnt.ID)
-	checkError(err, "Failed to inspect container %s", cnt.ID)
+func (s *FsTestSuite) TestContextDeadline() {
+	file, err := s.fdbfs.Create("/ctx/deadline")
+	s.Require().NoError(err)
+	_, err = file.Write([]byte("data"))
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err = s.fdbfs.OpenFileContext(ctx, "/ctx/deadline", os.O_RDONLY, 0)
+	s.True(errors.Is(err, context.DeadlineExceeded), "Open past deadline fails, got %v", err)
+
+	bound := file.(*FoundationDbFile)
+	_, err = bound.WriteAtContext(ctx, []byte("late"), 0)
+	s.True(errors.Is(err, context.DeadlineExceeded), "Write past deadline fails, got %v", err)
+	_, err = bound.ReadAtContext(ctx, make([]byte, 4), 0)
+	s.True(errors.Is(err, context.DeadlineExceeded), "Read past deadline fails, got %v", err)
+
+	live, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	read := make([]byte, 4)
+	n, err := bound.ReadAtContext(live, read, 0)
+	s.NoError(err)
+	s.Equal("data", string(read[:n]), "Late write is not applied")
+}
 
-	t.Logf("Started container info: %+v\n", json)
+func (s *FsTestSuite) TestNodePathFollowsRename() {
+	s.Require().NoError(s.fdbfs.MkdirAll("/node/from", os.ModePerm))
+	info, err := s.fdbfs.Stat("/node/from")
+	s.Require().NoError(err)
+	node := info.Sys().(*NodeStat).Node
 
-	if !json.State.Running {
-		checkError(fmt.Errorf("Container %s is not running. State: %s", cnt.ID, json.State.Status), "")
-	}
+	s.Require().NoError(s.fdbfs.Rename("/node/from", "/node/to"))
+	p, err := s.fdbfs.NodePath(node)
+	s.NoError(err)
+	s.Equal("/node/to", p)
 
-	ports := json.NetworkSettings.Ports["4500/tcp"]
-	file, err := ioutil.TempFile(t.TempDir(), "fdb-cluster-*.conf")
-	checkError(err, "Failed to create fdb file")
-	str := fmt.Sprintf(dbDef, "127.0.0.1", ports[0].HostPort)
-	written, err := file.WriteString(str)
-	checkError(err, "Failed to write to cluster file %s content '%s'", file.Name(), str)
-	if len(str) != written {
-		checkError(fmt.Errorf("Wrote wrong number of bytes, expected %d wrote %d", len(str), written), "")
-	}
+	s.Require().NoError(s.fdbfs.Remove("/node/to"))
+	_, err = s.fdbfs.NodePath(node)
+	s.True(os.IsNotExist(err), "Removed node has no path")
 
-	return file.Name(), nil
+	p, err = s.fdbfs.NodePath(rootNode)
+	s.NoError(err)
+	s.Equal("/", p)
 }
 
-type Loggly interface {
-	Log(args ...interface{})
-	Logf(fmt string, args ...interface{})
+func (s *FsTestSuite) TestOpenHandleAfterRename() {
+	file, err := s.fdbfs.Create("/handle/file")
+	s.Require().NoError(err)
+	_, err = file.Write([]byte("content"))
+	s.Require().NoError(err)
+
+	handle, err := s.fdbfs.Handle("/handle/file")
+	s.Require().NoError(err)
+	s.Equal(file.(*FoundationDbFile).Handle(), handle, "File and path give the same handle")
+
+	s.Require().NoError(s.fdbfs.Rename("/handle/file", "/handle/moved"))
+
+	opened, err := s.fdbfs.OpenHandle(handle, os.O_RDONLY)
+	s.Require().NoError(err)
+	s.Equal("handle/moved", opened.Name())
+	read, err := ioutil.ReadAll(opened)
+	s.NoError(err)
+	s.Equal("content", string(read))
+
+	info, err := s.fdbfs.StatHandle(handle)
+	s.Require().NoError(err)
+	s.Equal("moved", info.Name())
+
+	p, err := s.fdbfs.HandlePath(handle)
+	s.NoError(err)
+	s.Equal("/handle/moved", p)
+
+	_, err = s.fdbfs.OpenHandle(handle, os.O_RDWR|os.O_CREATE)
+	s.True(errors.Is(err, syscall.EINVAL), "Create makes no sense for a handle, got %v", err)
 }
 
-type Imagy interface {
-	ImagePull(ctx context.Context, imageDef string, options types.ImagePullOptions) (io.ReadCloser, error)
-	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+func (s *FsTestSuite) TestStaleHandle() {
+	s.Require().NoError(s.fdbfs.MkdirAll("/stale/dir", os.ModePerm))
+	handle, err := s.fdbfs.Handle("/stale/dir")
+	s.Require().NoError(err)
+
+	_, err = s.fdbfs.OpenHandle(handle, os.O_RDWR)
+	s.True(errors.Is(err, syscall.EISDIR), "Directories are read-only, got %v", err)
+
+	s.Require().NoError(s.fdbfs.Remove("/stale/dir"))
+
+	_, err = s.fdbfs.OpenHandle(handle, os.O_RDONLY)
+	s.True(errors.Is(err, ErrStaleHandle), "Removed node has stale handle, got %v", err)
+	_, err = s.fdbfs.StatHandle(handle)
+	s.True(errors.Is(err, ErrStaleHandle), "Removed node has stale handle, got %v", err)
+	_, err = s.fdbfs.HandlePath(handle)
+	s.True(errors.Is(err, ErrStaleHandle), "Removed node has stale handle, got %v", err)
 }
 
-//this func will panic if pulling fails, and wait a bit if image is too large to be pulled quickly
-func pullImage(imageDef string, cli Imagy, t Loggly) {
-	//for large images image pull returns too quickly
-
-	t.Logf("Pulling image %s", imageDef)
-	resp, err := cli.ImagePull(context.TODO(), imageDef, types.ImagePullOptions{})
-	checkError(err, "Failed to pull image %s", imageDef)
-	defer resp.Close()
-	args := filters.NewArgs(filters.KeyValuePair{Key: "reference", Value: imageDef})
-	firstTime := false
-
-	//TODO: there is alternative to listen to docker events for image, then wait by millis is not required.
-	// LATER.
-	for {
-		list, err := cli.ImageList(
-			context.TODO(),
-			types.ImageListOptions{
-				All:     false,
-				Filters: args})
-		checkError(err, "Failed listing images")
-		if len(list) > 0 {
-			break
-		}
-		if firstTime {
-			firstTime = false
-			t.Logf("Waiting for image %s to be pulled ", imageDef)
-		} else {
-			t.Log(".")
-		}
-		time.Sleep(500 * time.Millisecond)
+func (s *FsTestSuite) TestReusedNodeHandle() {
+	fs := s.subFs("reused")
+	s.Require().NoError(util.WriteFile(fs, "/file", []byte("old"), 0644))
+	handle, err := fs.Handle("/file")
+	s.Require().NoError(err)
+	id, _, err := handle.node()
+	s.Require().NoError(err)
+
+	// node of the same id allocated after the removed one has a generation of its own
+	_, err = fs.transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fs.node(id).Pack(generationKey), make([]byte, 8))
+		return nil, nil
+	})
+	s.Require().NoError(err)
+	_, err = fs.OpenHandle(handle, os.O_RDONLY)
+	s.True(errors.Is(err, ErrStaleHandle), "Handle does not reach another node of the same id, got %v", err)
+	_, err = fs.StatHandle(handle)
+	s.True(errors.Is(err, ErrStaleHandle), "Handle does not reach another node of the same id, got %v", err)
+
+	legacy := handle[:legacyHandleSize]
+	p, err := fs.HandlePath(legacy)
+	s.NoError(err, "Handles without generation reach nodes of generation zero")
+	s.Equal("/file", p)
+}
+
+func (s *FsTestSuite) TestRootModTime() {
+	fs := s.subFs("rootmtime")
+	info, err := fs.Stat("/")
+	s.Require().NoError(err)
+	s.True(info.ModTime().IsZero(), "Root that was never modified has no modification time")
+
+	s.Require().NoError(util.WriteFile(fs, "/file", nil, 0644))
+	info, err = fs.Stat("/")
+	s.Require().NoError(err)
+	s.False(info.ModTime().IsZero())
+	handle, err := fs.Handle("/")
+	s.Require().NoError(err)
+	byHandle, err := fs.StatHandle(handle)
+	s.Require().NoError(err)
+	s.Equal(byHandle.ModTime(), info.ModTime(), "Stat of root reads persisted modification time")
+	again, err := fs.Lstat("/")
+	s.Require().NoError(err)
+	s.Equal(info.ModTime(), again.ModTime())
+}
+
+func (s *FsTestSuite) TestHiddenEntries() {
+	fs := s.subFs("hidden")
+	for _, name := range []string{"/.a", "/" + HiddenPrefix + "private/file", "/z"} {
+		s.Require().NoError(fs.MkdirAll(name, os.ModePerm))
+	}
+
+	infos, err := fs.ReadDir("/")
+	s.Require().NoError(err)
+	s.Equal([]string{".a", "z"}, names(infos), "Hidden root entries are not listed")
+	_, err = fs.Stat("/" + HiddenPrefix + "private/file")
+	s.NoError(err, "Hidden entries are reachable by path")
+
+	dir, err := fs.Open("/")
+	s.Require().NoError(err)
+	for _, name := range []string{".a", "z"} {
+		infos, err = dir.(*FoundationDbFile).Readdir(1)
+		s.Require().NoError(err)
+		s.Equal([]string{name}, names(infos), "Hidden entries do not end listing early")
+	}
+	_, err = dir.(*FoundationDbFile).Readdir(1)
+	s.Equal(io.EOF, err)
+}
+
+func (s *FsTestSuite) TestFileReaddir() {
+	for _, name := range []string{"a", "b", "c"} {
+		s.Require().NoError(s.fdbfs.MkdirAll("/readdir/"+name, os.ModePerm))
 	}
-	t.Logf("Image %s pulled", imageDef)
+
+	dir, err := s.fdbfs.Open("/readdir")
+	s.Require().NoError(err)
+	f := dir.(*FoundationDbFile)
+
+	infos, err := f.Readdir(2)
+	s.Require().NoError(err)
+	s.Len(infos, 2)
+	s.Require().NoError(s.fdbfs.Remove("/readdir/a"))
+	s.Require().NoError(s.fdbfs.MkdirAll("/readdir/d", os.ModePerm))
+
+	infos, err = f.Readdir(0)
+	s.Require().NoError(err)
+	s.Len(infos, 2, "Listing resumes after the last returned name")
+	s.Equal("c", infos[0].Name())
+	_, err = f.Readdir(1)
+	s.Equal(io.EOF, err)
+
+	info, err := f.Stat()
+	s.Require().NoError(err)
+	s.Equal("readdir", info.Name())
+	s.True(info.IsDir())
 }
 
-func killFdb() {
+func (s *FsTestSuite) TestContentVersion() {
+	file, err := s.fdbfs.Create("/version")
+	s.Require().NoError(err)
+	version := func() uint64 {
+		info, err := file.(*FoundationDbFile).Stat()
+		s.Require().NoError(err)
+		return info.Sys().(*NodeStat).Version
+	}
+
+	before := version()
+	_, err = file.Write([]byte("content"))
+	s.Require().NoError(err)
+	written := version()
+	s.Greater(written, before, "Write bumps version")
+
+	s.Require().NoError(s.fdbfs.Chtimes("/version", time.Now(), time.Now()))
+	s.Equal(written, version(), "Chtimes keeps version")
+
+	s.Require().NoError(file.Truncate(1))
+	s.Greater(version(), written, "Truncate bumps version")
+}
 
+func (s *FsTestSuite) TestConfine() {
+	fs := s.subFs("confine")
+	s.Require().NoError(fs.MkdirAll("/home/u/a/b", os.ModePerm))
+	s.Require().NoError(fs.MkdirAll("/home/v", os.ModePerm))
+	s.Require().NoError(fs.Symlink("../..", "/home/u/a/b/up"))
+
+	confined := fs.Confine("/home/u")
+	_, err := confined.Stat("/home/u/a/b/up/a")
+	s.NoError(err, "Symlinks inside of root are followed")
+
+	s.Require().NoError(confined.Rename("/home/u/a/b/up", "/home/u/up"))
+	_, err = confined.Stat("/home/u/up/home/v")
+	s.True(errors.Is(err, os.ErrPermission), "Symlinks leading out of root are not followed, got %v", err)
+	_, err = fs.Stat("/home/u/up/home/v")
+	s.NoError(err, "Filesystem itself is not confined")
 }
@@ -0,0 +1,80 @@
+package billyfs
+
+import (
+	"os"
+	"syscall"
+)
+
+//billy.Symlink methods
+
+// Symlink creates link pointing to target. Target is stored as is, relative targets are resolved against
+// directory of the link.
+func (fs FoundationDbFs) Symlink(target, link string) error {
+	linkPath := fs.split(link)
+	if len(linkPath) == 0 {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		if _, err := fs.mkdirAll(tx, linkPath[0:len(linkPath)-1], &fileModeApplicator{perm: defaultDirectoryMode}); err != nil {
+			return nil, err
+		}
+
+		res, err := fs.resolve(tx, linkPath, false)
+		if err != nil {
+			return nil, err
+		}
+		if res.id != noNode {
+			return nil, os.ErrExist
+		}
+
+		id, err := fs.allocate(tx)
+		if err != nil {
+			return nil, err
+		}
+		fs.initNode(tx, id, os.ModeSymlink|os.ModePerm)
+		tx.Set(fs.node(id).Pack(targetKey), []byte(target))
+		fs.link(tx, res.parent, res.name, id)
+		fs.changed(tx, ChangeCreate, id, res.path, nil)
+
+		return nil, nil
+	})
+
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+// Lstat obtains file meta without following the last symlink
+func (fs FoundationDbFs) Lstat(path string) (os.FileInfo, error) {
+	return fs.statPath("lstat", path, false)
+}
+
+// Readlink returns target of a symlink
+func (fs FoundationDbFs) Readlink(link string) (string, error) {
+	linkPath := fs.split(link)
+
+	target, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		res, err := fs.resolve(r, linkPath, false)
+		if err != nil {
+			return nil, err
+		}
+		if res.id == noNode {
+			return nil, os.ErrNotExist
+		}
+		if res.mode&os.ModeSymlink == 0 {
+			return nil, syscall.EINVAL
+		}
+
+		bytes, err := r.Get(fs.node(res.id).Pack(targetKey)).Get()
+		return string(bytes), err
+	})
+
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: err}
+	}
+
+	return target.(string), nil
+}
@@ -0,0 +1,52 @@
+package billyfs
+
+import (
+	"errors"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// ErrNoVersions is returned by filesystems over stores that can not read at a past version
+var ErrNoVersions = errors.New("store does not support read versions")
+
+// ReadVersion returns the current version of the store. Views of the filesystem AtVersion of it see the state
+// the filesystem is in now, e.g. ReadDir followed by Stat of every entry sees a single state.
+func (fs FoundationDbFs) ReadVersion() (int64, error) {
+	return readVersion(fs.store)
+}
+
+// AtVersion returns a read-only view of the filesystem as it was at version v of the store, its writes fail with
+// syscall.EROFS. Versions are kept only within the MVCC window of the store, about 5 seconds on fdb, operations
+// of a view older than that fail with fdb transaction_too_old.
+func (fs FoundationDbFs) AtVersion(v int64) billy.Filesystem {
+	fs.store = readOnlyStore{storeAtVersion(fs.store, v)}
+	return fs
+}
+
+func readVersion(store KvStore) (int64, error) {
+	s, ok := store.(VersionStore)
+	if !ok {
+		return 0, ErrNoVersions
+	}
+	return s.ReadVersion()
+}
+
+// storeAtVersion pins reads of store to version v, transactions of stores without versions fail
+func storeAtVersion(store KvStore, v int64) KvStore {
+	s, ok := store.(VersionStore)
+	if !ok {
+		return unversionedStore{}
+	}
+	return s.AtVersion(v)
+}
+
+// unversionedStore fails every transaction with ErrNoVersions
+type unversionedStore struct{}
+
+func (unversionedStore) Transact(func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	return nil, ErrNoVersions
+}
+
+func (unversionedStore) ReadTransact(func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	return nil, ErrNoVersions
+}
@@ -0,0 +1,311 @@
+package billyfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Mutating operations append their changes to a change log in the same transaction, so the log has every change
+// that was committed and none that was not. Keys of the log:
+//
+//	("c", versionstamp) tuple of path, old path of a renamed node, node id and op
+//	("c") counter bumped by every transaction appending to the log, watchers watch it
+//
+// User version of the versionstamp numbers changes of a transaction in the order they were made. Every change is
+// kept, a file written in many batches logs a change per batch, until TrimChanges drops it. The log grows
+// without bound unless it is trimmed, see StartChangeTrimmer.
+
+// ChangeOp is the kind of a change
+type ChangeOp int64
+
+const (
+	ChangeCreate ChangeOp = iota + 1
+	ChangeWrite
+	ChangeRemove
+	ChangeRename
+	// ChangeChmod is a change of mode, owner or modification time
+	ChangeChmod
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeCreate:
+		return "create"
+	case ChangeWrite:
+		return "write"
+	case ChangeRemove:
+		return "remove"
+	case ChangeRename:
+		return "rename"
+	case ChangeChmod:
+		return "chmod"
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int64(op))
+}
+
+// Cursor is an opaque position in the change log, reading after it resumes where a consumer left off. Nil cursor
+// is the beginning of the log.
+type Cursor []byte
+
+// Change is an entry of the change log
+type Change struct {
+	Op   ChangeOp
+	Path string
+	// OldPath is the path a renamed node was moved from
+	OldPath string
+	Node    int64
+	// Cursor is the position of the change
+	Cursor Cursor
+}
+
+// changeBatch bounds changes read from the log in one transaction
+const changeBatch = 1000
+
+// maxChanges bounds changes logged by a transaction, they are numbered by the user version of the versionstamp
+const maxChanges = 0xFFFF
+
+func (fs FoundationDbFs) changeLog() subspace.Subspace {
+	return fs.root.Sub("c")
+}
+
+func (fs FoundationDbFs) changeHead() fdb.Key {
+	return fs.root.Pack(tuple.Tuple{"c"})
+}
+
+// changed appends change of node at fsPath to the change log. Changes of a transaction of transact are numbered
+// in order, other transactions log a single change.
+func (fs FoundationDbFs) changed(w KvTransaction, op ChangeOp, id int64, fsPath []string, oldPath []string) {
+	var order uint16
+	if cow, ok := w.(*cowTransaction); ok {
+		if cow.changes > maxChanges {
+			if cow.err == nil {
+				cow.err = fmt.Errorf("too_many_changes %v", cow.changes)
+			}
+			return
+		}
+		order = uint16(cow.changes)
+		cow.changes++
+	}
+
+	prefix := fs.changeLog().Bytes()
+	key := append(append([]byte{}, prefix...), tuple.Tuple{tuple.Versionstamp{UserVersion: order}}.Pack()...)
+	pos := make([]byte, 4)
+	// placeholder follows type code of the versionstamp
+	binary.LittleEndian.PutUint32(pos, uint32(len(prefix)+1))
+
+	value := tuple.Tuple{joinPath(fsPath), "", id, int64(op)}
+	if oldPath != nil {
+		value[1] = joinPath(oldPath)
+	}
+	w.SetVersionstampedKey(fdb.Key(append(key, pos...)), value.Pack())
+
+	one := make([]byte, 8)
+	binary.LittleEndian.PutUint64(one, 1)
+	w.Add(fs.changeHead(), one)
+}
+
+func joinPath(fsPath []string) string {
+	return path.Join(append([]string{"/"}, fsPath...)...)
+}
+
+// Changes reads up to limit changes after cursor, limit of zero or less reads a default batch
+func (fs FoundationDbFs) Changes(after Cursor, limit int) ([]Change, error) {
+	changes, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return fs.changes(r, after, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes.([]Change), nil
+}
+
+// LastCursor returns cursor of the last change, reading after it yields only changes made from now on
+func (fs FoundationDbFs) LastCursor() (Cursor, error) {
+	cursor, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		last, err := r.GetRange(fs.changeLog(), fdb.RangeOptions{Limit: 1, Reverse: true})
+		if err != nil || len(last) == 0 {
+			return Cursor{}, err
+		}
+		return Cursor(last[0].Key[len(fs.changeLog().Bytes()):]), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursor.(Cursor), nil
+}
+
+func (fs FoundationDbFs) changes(r KvReadTransaction, after Cursor, limit int) ([]Change, error) {
+	if limit <= 0 {
+		limit = changeBatch
+	}
+	begin, end := fs.changeLog().FDBRangeKeys()
+	if len(after) > 0 {
+		begin = fdb.Key(append(append(fs.changeLog().Bytes(), after...), 0x00))
+	}
+
+	kvs, err := r.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, len(kvs))
+	for i, kv := range kvs {
+		if changes[i], err = fs.unpackChange(kv); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func (fs FoundationDbFs) unpackChange(kv fdb.KeyValue) (Change, error) {
+	key, err := fs.changeLog().Unpack(kv.Key)
+	if err != nil {
+		return Change{}, err
+	}
+	value, err := tuple.Unpack(kv.Value)
+	if err != nil {
+		return Change{}, err
+	}
+	if len(key) != 1 || len(value) != 4 {
+		return Change{}, fmt.Errorf("malformed_change %v %v", key, value)
+	}
+	p, ok := value[0].(string)
+	old, ok2 := value[1].(string)
+	id, ok3 := value[2].(int64)
+	op, ok4 := value[3].(int64)
+	if !ok || !ok2 || !ok3 || !ok4 {
+		return Change{}, fmt.Errorf("malformed_change %v %v", key, value)
+	}
+
+	return Change{
+		Op:      ChangeOp(op),
+		Path:    p,
+		OldPath: old,
+		Node:    id,
+		Cursor:  Cursor(kv.Key[len(fs.changeLog().Bytes()):]),
+	}, nil
+}
+
+// Watcher delivers changes of a path, see FoundationDbFs.Watch
+type Watcher struct {
+	// Events receives changes in log order, it is closed once the watcher is closed or fails
+	Events <-chan Change
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error
+}
+
+// Watch watches path for changes made from now on. Changes of path itself and of its direct children are
+// delivered, with recursive set changes of the whole subtree are. Renames are delivered when either side matches.
+func (fs FoundationDbFs) Watch(path string, recursive bool) (*Watcher, error) {
+	cursor, err := fs.LastCursor()
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: path, Err: err}
+	}
+	return fs.WatchFrom(path, recursive, cursor), nil
+}
+
+// WatchFrom watches path for changes after cursor, e.g. Cursor of the last change a consumer has processed
+func (fs FoundationDbFs) WatchFrom(path string, recursive bool, after Cursor) *Watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan Change)
+	w := &Watcher{Events: events, cancel: cancel, done: make(chan struct{})}
+
+	watched := joinPath(fs.split(path))
+	go func() {
+		defer close(w.done)
+		defer close(events)
+
+		err := fs.follow(ctx, after, func(c Change) bool {
+			if !watches(watched, recursive, c.Path) && (c.OldPath == "" || !watches(watched, recursive, c.OldPath)) {
+				return true
+			}
+			select {
+			case events <- c:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			w.mu.Lock()
+			w.err = &os.PathError{Op: "watch", Path: path, Err: err}
+			w.mu.Unlock()
+		}
+	}()
+	return w
+}
+
+// Close stops the watcher and closes Events
+func (w *Watcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+// Err returns the error the watcher failed with, nil when it did not fail
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// follow passes changes after cursor to f until f returns false or ctx is done. Once the log is read up to its
+// end it waits for the change counter to move.
+func (fs FoundationDbFs) follow(ctx context.Context, after Cursor, f func(Change) bool) error {
+	for {
+		type batch struct {
+			changes []Change
+			head    []byte
+		}
+		read, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+			head, err := r.Get(fs.changeHead()).Get()
+			if err != nil {
+				return nil, err
+			}
+			changes, err := fs.changes(r, after, changeBatch)
+			return batch{changes, head}, err
+		})
+		if err != nil {
+			return err
+		}
+
+		b := read.(batch)
+		for _, c := range b.changes {
+			if !f(c) {
+				return nil
+			}
+			after = c.Cursor
+		}
+		if len(b.changes) == changeBatch {
+			continue
+		}
+
+		if err = watch(fs.store, ctx, fs.changeHead(), b.head); err != nil {
+			return err
+		}
+	}
+}
+
+// watches tells whether change of p is delivered to a watcher of watched
+func watches(watched string, recursive bool, p string) bool {
+	if p == watched {
+		return true
+	}
+	prefix := strings.TrimSuffix(watched, "/") + "/"
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return recursive || !strings.Contains(p[len(prefix):], "/")
+}
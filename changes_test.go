@@ -0,0 +1,123 @@
+package billyfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) changesSince(cursor Cursor) []Change {
+	changes, err := s.fdbfs.Changes(cursor, 0)
+	s.Require().NoError(err)
+	for i := range changes {
+		changes[i].Cursor = nil
+		changes[i].Node = 0
+	}
+	return changes
+}
+
+func (s *FsTestSuite) TestChangeLog() {
+	cursor, err := s.fdbfs.LastCursor()
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.fdbfs.MkdirAll("/log/dir", 0755))
+	s.Require().NoError(util.WriteFile(s.fdbfs, "/log/dir/file", []byte("content"), 0644))
+	s.Require().NoError(s.fdbfs.Chmod("/log/dir/file", 0600))
+	s.Require().NoError(s.fdbfs.Rename("/log/dir/file", "/log/moved"))
+	s.Require().NoError(s.fdbfs.Remove("/log/dir"))
+
+	s.Equal([]Change{
+		{Op: ChangeCreate, Path: "/log"},
+		{Op: ChangeCreate, Path: "/log/dir"},
+		{Op: ChangeCreate, Path: "/log/dir/file"},
+		{Op: ChangeWrite, Path: "/log/dir/file"},
+		{Op: ChangeChmod, Path: "/log/dir/file"},
+		{Op: ChangeRename, Path: "/log/moved", OldPath: "/log/dir/file"},
+		{Op: ChangeRemove, Path: "/log/dir"},
+	}, s.changesSince(cursor))
+
+	changes, err := s.fdbfs.Changes(cursor, 2)
+	s.Require().NoError(err)
+	s.Require().Len(changes, 2)
+	s.Equal([]Change{{Op: ChangeCreate, Path: "/log/dir/file"}, {Op: ChangeWrite, Path: "/log/dir/file"}},
+		s.changesSince(changes[1].Cursor)[:2], "Reading resumes after cursor")
+}
+
+func (s *FsTestSuite) TestChangesOfTransaction() {
+	cursor, err := s.fdbfs.LastCursor()
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.fdbfs.Transact(func(tx FsTx) error {
+		if err := tx.WriteFile("/txlog/b", []byte("b"), 0644); err != nil {
+			return err
+		}
+		if err := tx.Rename("/txlog/b", "/txlog/c"); err != nil {
+			return err
+		}
+		return tx.Rename("/txlog/c", "/txlog/a")
+	}))
+
+	s.Equal([]Change{
+		{Op: ChangeCreate, Path: "/txlog"},
+		{Op: ChangeCreate, Path: "/txlog/b"},
+		{Op: ChangeWrite, Path: "/txlog/b"},
+		{Op: ChangeRename, Path: "/txlog/c", OldPath: "/txlog/b"},
+		{Op: ChangeRename, Path: "/txlog/a", OldPath: "/txlog/c"},
+	}, s.changesSince(cursor), "Changes of a transaction are all kept in the order they were made")
+}
+
+func (s *FsTestSuite) TestFailedChangeIsNotLogged() {
+	cursor, err := s.fdbfs.LastCursor()
+	s.Require().NoError(err)
+
+	s.Require().NoError(util.WriteFile(s.fdbfs, "/notlogged", nil, 0644))
+	_, err = s.fdbfs.OpenFile("/notlogged/child", os.O_CREATE|os.O_RDWR, 0644)
+	s.Require().Error(err)
+
+	s.Equal([]Change{{Op: ChangeCreate, Path: "/notlogged"}}, s.changesSince(cursor))
+}
+
+func (s *FsTestSuite) TestWatch() {
+	s.Require().NoError(s.fdbfs.MkdirAll("/watched/sub", 0755))
+	direct, err := s.fdbfs.Watch("/watched", false)
+	s.Require().NoError(err)
+	defer direct.Close()
+	recursive, err := s.fdbfs.Watch("/watched", true)
+	s.Require().NoError(err)
+	defer recursive.Close()
+
+	s.Require().NoError(util.WriteFile(s.fdbfs, "/watched/sub/deep", nil, 0644))
+	s.Require().NoError(util.WriteFile(s.fdbfs, "/unwatched", nil, 0644))
+	s.Require().NoError(s.fdbfs.Rename("/unwatched", "/watched/file"))
+
+	next := func(w *Watcher) Change {
+		select {
+		case c, ok := <-w.Events:
+			s.Require().True(ok, "Watcher failed with %v", w.Err())
+			return c
+		case <-time.After(5 * time.Second):
+			s.FailNow("No change delivered")
+		}
+		return Change{}
+	}
+
+	s.Equal("/watched/sub/deep", next(recursive).Path)
+	c := next(recursive)
+	s.Equal(ChangeRename, c.Op)
+	s.Equal("/watched/file", c.Path)
+	s.Equal(ChangeRename, next(direct).Op, "Changes deeper than direct children are skipped")
+
+	// resuming from cursor of a delivered change redelivers nothing before it
+	resumed := s.fdbfs.WatchFrom("/watched", true, c.Cursor)
+	defer resumed.Close()
+	s.Require().NoError(s.fdbfs.Remove("/watched/file"))
+	removed := next(resumed)
+	s.Equal(ChangeRemove, removed.Op, "Resumed watcher starts after cursor")
+	s.Equal(removed, next(direct))
+
+	s.Require().NoError(direct.Close())
+	_, ok := <-direct.Events
+	s.False(ok, "Close closes events")
+	s.NoError(direct.Err())
+}
@@ -0,0 +1,38 @@
+// Command fdb9p serves FoundationDbFs over 9P2000.L.
+//
+// Mount it with e.g. mount -t 9p -o trans=tcp,port=564,version=9p2000.L 127.0.0.1 /mnt, or serve a unix socket
+// with -net unix -listen /run/fdb9p.sock and mount it with trans=unix. Attach name selects the exported directory.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+func main() {
+	network := flag.String("net", "tcp", "network to listen on, tcp or unix")
+	listen := flag.String("listen", ":564", "address or socket path to serve 9P on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	listener, err := net.Listen(*network, *listen)
+	if err != nil {
+		log.Fatalf("Failed listening on %s: %v", *listen, err)
+	}
+	log.Printf("Serving 9P on %s", listener.Addr())
+
+	log.Fatal(newServer(fs).serve(listener))
+}
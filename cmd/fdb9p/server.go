@@ -0,0 +1,825 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/ninep"
+)
+
+// maxMsize bounds message size clients may negotiate
+const maxMsize = 1 << 20
+
+// blockSize is reported as preferred io size and statfs block size
+const blockSize = 64 * 1024
+
+// v9fsMagic is the filesystem type Linux reports for 9p mounts
+const v9fsMagic = 0x01021997
+
+// server serves 9P2000.L over FoundationDbFs
+type server struct {
+	fs billyfs.FoundationDbFs
+}
+
+func newServer(fs billyfs.FoundationDbFs) *server {
+	return &server{fs: fs}
+}
+
+// serve accepts connections until listener is closed
+func (s *server) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// fid is a reference of a client to a node. Fids keep handle of the node and resolve its path on use, so they
+// survive renames. Opened fids keep the opened file.
+type fid struct {
+	// mu serializes requests using the fid
+	mu     sync.Mutex
+	handle billyfs.Handle
+	// root is the attach root, walks do not leave it
+	root string
+	file *billyfs.FoundationDbFile
+	// entries are listed when a directory is read from offset 0, later reads continue in them
+	entries []os.FileInfo
+}
+
+// call is a request being served, flush cancels its context and waits for it
+type call struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// conn is the state of a connection. Requests are served concurrently, each in its own goroutine.
+type conn struct {
+	fs    billyfs.FoundationDbFs
+	rw    io.ReadWriteCloser
+	msize uint32
+
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	fids  map[uint32]*fid
+	calls map[uint16]*call
+	wg    sync.WaitGroup
+}
+
+func (s *server) serveConn(rw io.ReadWriteCloser) {
+	c := &conn{fs: s.fs, rw: rw, msize: maxMsize, fids: map[uint32]*fid{}, calls: map[uint16]*call{}}
+	defer c.close()
+
+	for {
+		tag, m, err := ninep.ReadMessage(rw, c.msize)
+		var unknown *ninep.UnknownMessageError
+		switch {
+		case errors.As(err, &unknown):
+			c.reply(tag, &ninep.Rlerror{Ecode: uint32(syscall.ENOSYS)})
+			continue
+		case errors.Is(err, ninep.ErrShortMessage):
+			c.reply(tag, &ninep.Rlerror{Ecode: uint32(syscall.EINVAL)})
+			continue
+		case err != nil:
+			if err != io.EOF {
+				log.Printf("Failed reading request: %v", err)
+			}
+			return
+		}
+
+		switch m := m.(type) {
+		case *ninep.Tversion:
+			// version starts a new session, fids of the old one are clunked
+			c.wg.Wait()
+			c.reset()
+			c.reply(tag, c.version(m))
+		case *ninep.Tflush:
+			c.flush(tag, m.OldTag)
+		default:
+			c.start(tag, m)
+		}
+	}
+}
+
+// start serves request in its own goroutine
+func (c *conn) start(tag uint16, m ninep.Message) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cl := &call{cancel: cancel, done: make(chan struct{})}
+	c.mu.Lock()
+	c.calls[tag] = cl
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(cl.done)
+		defer cancel()
+
+		reply, err := c.handle(ctx, m)
+		if err != nil {
+			reply = &ninep.Rlerror{Ecode: uint32(errno(err))}
+		}
+		c.mu.Lock()
+		delete(c.calls, tag)
+		c.mu.Unlock()
+		c.reply(tag, reply)
+	}()
+}
+
+// flush cancels request and replies once the request has replied
+func (c *conn) flush(tag uint16, oldTag uint16) {
+	c.mu.Lock()
+	cl := c.calls[oldTag]
+	c.mu.Unlock()
+	if cl == nil {
+		c.reply(tag, &ninep.Rflush{})
+		return
+	}
+
+	cl.cancel()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		<-cl.done
+		c.reply(tag, &ninep.Rflush{})
+	}()
+}
+
+func (c *conn) reply(tag uint16, m ninep.Message) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := ninep.WriteMessage(c.rw, tag, m); err != nil {
+		c.rw.Close()
+	}
+}
+
+// reset clunks all fids
+func (c *conn) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, f := range c.fids {
+		if f.file != nil {
+			f.file.Close()
+		}
+		delete(c.fids, id)
+	}
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	for _, cl := range c.calls {
+		cl.cancel()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	c.reset()
+	c.rw.Close()
+}
+
+func (c *conn) version(m *ninep.Tversion) ninep.Message {
+	if m.Msize < c.msize {
+		c.msize = m.Msize
+	}
+	if m.Version != ninep.Version {
+		return &ninep.Rversion{Msize: c.msize, Version: "unknown"}
+	}
+	return &ninep.Rversion{Msize: c.msize, Version: ninep.Version}
+}
+
+// errno maps filesystem errors to Linux errnos
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case os.IsNotExist(err):
+		return syscall.ENOENT
+	case os.IsExist(err):
+		return syscall.EEXIST
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
+
+// fid finds fid and locks it, caller unlocks it
+func (c *conn) fid(id uint32) (*fid, error) {
+	c.mu.Lock()
+	f := c.fids[id]
+	c.mu.Unlock()
+	if f == nil {
+		return nil, syscall.EBADF
+	}
+	f.mu.Lock()
+	return f, nil
+}
+
+// bind binds new fid, the id must be unused
+func (c *conn) bind(id uint32, f *fid) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, used := c.fids[id]; used || id == ninep.NoFid {
+		return syscall.EBADF
+	}
+	c.fids[id] = f
+	return nil
+}
+
+// unbind forgets fid and closes its file
+func (c *conn) unbind(id uint32) error {
+	c.mu.Lock()
+	f := c.fids[id]
+	delete(c.fids, id)
+	c.mu.Unlock()
+	if f == nil {
+		return syscall.EBADF
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+func qid(info os.FileInfo) ninep.Qid {
+	q := ninep.Qid{Type: ninep.QTFile}
+	switch {
+	case info.IsDir():
+		q.Type = ninep.QTDir
+	case info.Mode()&os.ModeSymlink != 0:
+		q.Type = ninep.QTSymlink
+	}
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		q.Path = uint64(stat.Node)
+	}
+	return q
+}
+
+// unixMode converts os.FileMode to mode bits of stat
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	default:
+		m |= syscall.S_IFREG
+	}
+	return m
+}
+
+// openFlags maps Linux open flags of Tlopen and Tlcreate to os flags. Append is dropped, clients write at offsets.
+func openFlags(flags uint32) int {
+	flag := int(flags) & syscall.O_ACCMODE
+	if flags&syscall.O_TRUNC != 0 {
+		flag |= os.O_TRUNC
+	}
+	return flag
+}
+
+func (c *conn) handle(ctx context.Context, m ninep.Message) (ninep.Message, error) {
+	fsys := c.fs.WithContext(ctx)
+
+	switch m := m.(type) {
+	case *ninep.Tattach:
+		return c.attach(fsys, m)
+	case *ninep.Twalk:
+		return c.walk(fsys, m)
+	case *ninep.Tclunk:
+		return &ninep.Rclunk{}, c.unbind(m.Fid)
+	case *ninep.Tremove:
+		return c.remove(fsys, m)
+	case *ninep.Trenameat:
+		return c.renameat(fsys, m)
+	case *ninep.Trename:
+		return c.rename(fsys, m)
+	case *ninep.Tstatfs:
+		// capacity of a cluster is not known to a filesystem
+		return &ninep.Rstatfs{Type: v9fsMagic, BSize: blockSize, NameLen: 255}, nil
+	}
+
+	// remaining requests work on a single fid
+	var id uint32
+	switch m := m.(type) {
+	case *ninep.Tlopen:
+		id = m.Fid
+	case *ninep.Tlcreate:
+		id = m.Fid
+	case *ninep.Tread:
+		id = m.Fid
+	case *ninep.Twrite:
+		id = m.Fid
+	case *ninep.Tgetattr:
+		id = m.Fid
+	case *ninep.Tsetattr:
+		id = m.Fid
+	case *ninep.Treaddir:
+		id = m.Fid
+	case *ninep.Tfsync:
+		id = m.Fid
+	case *ninep.Treadlink:
+		id = m.Fid
+	case *ninep.Tmkdir:
+		id = m.DirFid
+	case *ninep.Tsymlink:
+		id = m.Fid
+	case *ninep.Tunlinkat:
+		id = m.DirFid
+	default:
+		return nil, syscall.ENOSYS
+	}
+
+	f, err := c.fid(id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.mu.Unlock()
+
+	switch m := m.(type) {
+	case *ninep.Tlopen:
+		return c.open(fsys, f, m)
+	case *ninep.Tlcreate:
+		return c.create(fsys, f, m)
+	case *ninep.Tread:
+		return c.read(ctx, f, m)
+	case *ninep.Twrite:
+		return write(ctx, f, m)
+	case *ninep.Tgetattr:
+		return getattr(fsys, f)
+	case *ninep.Tsetattr:
+		return setattr(fsys, f, m)
+	case *ninep.Treaddir:
+		return readdir(fsys, f, m)
+	case *ninep.Tfsync:
+		// writes are committed before they are acknowledged
+		return &ninep.Rfsync{}, nil
+	case *ninep.Treadlink:
+		return readlink(fsys, f)
+	case *ninep.Tmkdir:
+		return mkdir(fsys, f, m)
+	case *ninep.Tsymlink:
+		return symlink(fsys, f, m)
+	case *ninep.Tunlinkat:
+		return unlinkat(fsys, f, m)
+	}
+
+	return nil, syscall.ENOSYS
+}
+
+func (f *fid) path(fsys billyfs.FoundationDbFs) (string, error) {
+	return fsys.HandlePath(f.handle)
+}
+
+// child resolves path of name in directory f, names have to be single path elements
+func (f *fid) child(fsys billyfs.FoundationDbFs, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || path.Base(name) != name {
+		return "", syscall.EINVAL
+	}
+	p, err := f.path(fsys)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(p, name), nil
+}
+
+// attach binds fid to aname, the root of the filesystem when empty
+func (c *conn) attach(fsys billyfs.FoundationDbFs, m *ninep.Tattach) (ninep.Message, error) {
+	if m.Afid != ninep.NoFid {
+		return nil, syscall.EINVAL
+	}
+	root := path.Clean("/" + m.Aname)
+	info, err := fsys.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	handle, err := fsys.Handle(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.bind(m.Fid, &fid{handle: handle, root: root}); err != nil {
+		return nil, err
+	}
+	return &ninep.Rattach{Qid: qid(info)}, nil
+}
+
+// walk resolves names one by one, ".." does not leave the attach root
+func (c *conn) walk(fsys billyfs.FoundationDbFs, m *ninep.Twalk) (ninep.Message, error) {
+	f, err := c.fid(m.Fid)
+	if err != nil {
+		return nil, err
+	}
+	opened, handle, root := f.file != nil, f.handle, f.root
+	f.mu.Unlock()
+	if opened {
+		return nil, syscall.EBADF
+	}
+
+	p, err := fsys.HandlePath(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	qids := make([]ninep.Qid, 0, len(m.Names))
+	for _, name := range m.Names {
+		switch {
+		case name == "..":
+			if p != root {
+				p = path.Dir(p)
+			}
+		case name == "" || name == "." || path.Base(name) != name:
+			err = syscall.EINVAL
+		default:
+			p = path.Join(p, name)
+		}
+
+		var info os.FileInfo
+		if err == nil {
+			info, err = fsys.Lstat(p)
+		}
+		if err != nil {
+			if len(qids) == 0 {
+				return nil, err
+			}
+			return &ninep.Rwalk{Qids: qids}, nil
+		}
+		qids = append(qids, qid(info))
+	}
+
+	if handle, err = fsys.Handle(p); err != nil {
+		return nil, err
+	}
+	walked := &fid{handle: handle, root: root}
+	if m.NewFid == m.Fid {
+		c.mu.Lock()
+		c.fids[m.Fid] = walked
+		c.mu.Unlock()
+	} else if err = c.bind(m.NewFid, walked); err != nil {
+		return nil, err
+	}
+
+	return &ninep.Rwalk{Qids: qids}, nil
+}
+
+// open opens fid by handle. The file is not bound to context of the request, it outlives it.
+func (c *conn) open(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tlopen) (ninep.Message, error) {
+	if f.file != nil {
+		return nil, syscall.EBADF
+	}
+	info, err := fsys.StatHandle(f.handle)
+	if err != nil {
+		return nil, err
+	}
+	file, err := c.fs.OpenHandle(f.handle, openFlags(m.Flags))
+	if err != nil {
+		return nil, err
+	}
+
+	f.file, f.entries = file, nil
+	return &ninep.Rlopen{Qid: qid(info), Iounit: c.msize - ninep.IOHeaderSize}, nil
+}
+
+// create creates file in directory f, f becomes the opened file
+func (c *conn) create(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tlcreate) (ninep.Message, error) {
+	if f.file != nil {
+		return nil, syscall.EBADF
+	}
+	p, err := f.child(fsys, m.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := openFlags(m.Flags) | os.O_CREATE
+	if m.Flags&syscall.O_EXCL != 0 {
+		flag |= os.O_EXCL
+	}
+	opened, err := c.fs.OpenFile(p, flag, os.FileMode(m.Mode).Perm())
+	if err != nil {
+		return nil, err
+	}
+	file := opened.(*billyfs.FoundationDbFile)
+	info, err := fsys.StatHandle(file.Handle())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f.handle, f.file, f.entries = file.Handle(), file, nil
+	return &ninep.Rlcreate{Qid: qid(info), Iounit: c.msize - ninep.IOHeaderSize}, nil
+}
+
+func (c *conn) read(ctx context.Context, f *fid, m *ninep.Tread) (ninep.Message, error) {
+	if f.file == nil {
+		return nil, syscall.EBADF
+	}
+	count := m.Count
+	if max := c.msize - ninep.IOHeaderSize; count > max {
+		count = max
+	}
+
+	data := make([]byte, count)
+	n, err := f.file.ReadAtContext(ctx, data, int64(m.Offset))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &ninep.Rread{Data: data[:n]}, nil
+}
+
+// write reports partial writes as short writes
+func write(ctx context.Context, f *fid, m *ninep.Twrite) (ninep.Message, error) {
+	if f.file == nil {
+		return nil, syscall.EBADF
+	}
+	n, err := f.file.WriteAtContext(ctx, m.Data, int64(m.Offset))
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return &ninep.Rwrite{Count: uint32(n)}, nil
+}
+
+func getattr(fsys billyfs.FoundationDbFs, f *fid) (ninep.Message, error) {
+	info, err := fsys.StatHandle(f.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	mtime := info.ModTime()
+	sec, nsec := uint64(mtime.Unix()), uint64(mtime.Nanosecond())
+	r := &ninep.Rgetattr{
+		Valid:     ninep.GetattrBasic,
+		Qid:       qid(info),
+		Mode:      unixMode(info.Mode()),
+		Nlink:     1,
+		Size:      uint64(info.Size()),
+		BlockSize: blockSize,
+		Blocks:    (uint64(info.Size()) + 511) / 512,
+		AtimeSec:  sec,
+		AtimeNsec: nsec,
+		MtimeSec:  sec,
+		MtimeNsec: nsec,
+		CtimeSec:  sec,
+		CtimeNsec: nsec,
+	}
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		r.UID, r.GID = uint32(stat.Uid), uint32(stat.Gid)
+	}
+	return r, nil
+}
+
+// setattr truncates, changes mode, owner and times. Change time is not kept and is ignored.
+func setattr(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tsetattr) (ninep.Message, error) {
+	if m.Valid&ninep.SetattrSize != 0 {
+		file, err := fsys.OpenHandle(f.handle, os.O_WRONLY)
+		if err != nil {
+			return nil, err
+		}
+		err = file.Truncate(int64(m.Size))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := f.path(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if m.Valid&ninep.SetattrMode != 0 {
+		if err = fsys.Chmod(p, os.FileMode(m.Mode).Perm()); err != nil {
+			return nil, err
+		}
+	}
+	if m.Valid&(ninep.SetattrUID|ninep.SetattrGID) != 0 {
+		info, err := fsys.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		uid, gid := int(m.UID), int(m.GID)
+		if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+			if m.Valid&ninep.SetattrUID == 0 {
+				uid = stat.Uid
+			}
+			if m.Valid&ninep.SetattrGID == 0 {
+				gid = stat.Gid
+			}
+		}
+		if err = fsys.Lchown(p, uid, gid); err != nil {
+			return nil, err
+		}
+	}
+	if m.Valid&ninep.SetattrMtime != 0 {
+		// without MtimeSet the time is the time of the request
+		mtime := time.Now()
+		if m.Valid&ninep.SetattrMtimeSet != 0 {
+			mtime = time.Unix(int64(m.MtimeSec), int64(m.MtimeNsec))
+		}
+		atime := time.Unix(int64(m.AtimeSec), int64(m.AtimeNsec))
+		if err = fsys.Chtimes(p, atime, mtime); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ninep.Rsetattr{}, nil
+}
+
+// readdir lists directory at offset 0 and serves later offsets from the listing. Offset of an entry is its index
+// in the listing plus one.
+func readdir(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Treaddir) (ninep.Message, error) {
+	if f.file == nil {
+		return nil, syscall.EBADF
+	}
+	if m.Offset == 0 || f.entries == nil {
+		p, err := f.path(fsys)
+		if err != nil {
+			return nil, err
+		}
+		if f.entries, err = fsys.ReadDir(p); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &ninep.Rreaddir{}
+	size := 0
+	for i := m.Offset; i < uint64(len(f.entries)); i++ {
+		info := f.entries[i]
+		entry := ninep.Dirent{Qid: qid(info), Offset: i + 1, Type: ninep.DTReg, Name: info.Name()}
+		switch entry.Qid.Type {
+		case ninep.QTDir:
+			entry.Type = ninep.DTDir
+		case ninep.QTSymlink:
+			entry.Type = ninep.DTLink
+		}
+		if size+entry.Size() > int(m.Count) {
+			break
+		}
+		size += entry.Size()
+		r.Entries = append(r.Entries, entry)
+	}
+	return r, nil
+}
+
+func readlink(fsys billyfs.FoundationDbFs, f *fid) (ninep.Message, error) {
+	p, err := f.path(fsys)
+	if err != nil {
+		return nil, err
+	}
+	target, err := fsys.Readlink(p)
+	if err != nil {
+		return nil, err
+	}
+	return &ninep.Rreadlink{Target: target}, nil
+}
+
+// mkdir creates directory in f, fs would accept existing directories so they are checked first
+func mkdir(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tmkdir) (ninep.Message, error) {
+	p, err := f.child(fsys, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fsys.Lstat(p); err == nil {
+		return nil, syscall.EEXIST
+	}
+	if err = fsys.MkdirAll(p, os.ModeDir|os.FileMode(m.Mode).Perm()); err != nil {
+		return nil, err
+	}
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return &ninep.Rmkdir{Qid: qid(info)}, nil
+}
+
+func symlink(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tsymlink) (ninep.Message, error) {
+	p, err := f.child(fsys, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = fsys.Symlink(m.Target, p); err != nil {
+		return nil, err
+	}
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return &ninep.Rsymlink{Qid: qid(info)}, nil
+}
+
+// unlinkat removes a file, or an empty directory with AtRemoveDir
+func unlinkat(fsys billyfs.FoundationDbFs, f *fid, m *ninep.Tunlinkat) (ninep.Message, error) {
+	p, err := f.child(fsys, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = removeEntry(fsys, p, m.Flags&ninep.AtRemoveDir != 0); err != nil {
+		return nil, err
+	}
+	return &ninep.Runlinkat{}, nil
+}
+
+// removeEntry removes file at p, or empty directory when dir is set
+func removeEntry(fsys billyfs.FoundationDbFs, p string, dir bool) error {
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dir && !info.IsDir():
+		return syscall.ENOTDIR
+	case !dir && info.IsDir():
+		return syscall.EISDIR
+	}
+	return fsys.Remove(p)
+}
+
+// remove removes file or empty directory of fid, the fid is clunked either way
+func (c *conn) remove(fsys billyfs.FoundationDbFs, m *ninep.Tremove) (ninep.Message, error) {
+	f, err := c.fid(m.Fid)
+	if err != nil {
+		return nil, err
+	}
+	handle, root := f.handle, f.root
+	f.mu.Unlock()
+	defer c.unbind(m.Fid)
+
+	p, err := fsys.HandlePath(handle)
+	if err != nil {
+		return nil, err
+	}
+	if p == root {
+		return nil, syscall.EBUSY
+	}
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if err = removeEntry(fsys, p, info.IsDir()); err != nil {
+		return nil, err
+	}
+	return &ninep.Rremove{}, nil
+}
+
+func (c *conn) renameat(fsys billyfs.FoundationDbFs, m *ninep.Trenameat) (ninep.Message, error) {
+	from, err := c.childOf(fsys, m.OldDirFid, m.OldName)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.childOf(fsys, m.NewDirFid, m.NewName)
+	if err != nil {
+		return nil, err
+	}
+	if err = fsys.Rename(from, to); err != nil {
+		return nil, err
+	}
+	return &ninep.Rrenameat{}, nil
+}
+
+// rename moves file of fid, it is the request of clients not supporting Trenameat
+func (c *conn) rename(fsys billyfs.FoundationDbFs, m *ninep.Trename) (ninep.Message, error) {
+	f, err := c.fid(m.Fid)
+	if err != nil {
+		return nil, err
+	}
+	from, err := f.path(fsys)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.childOf(fsys, m.DirFid, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = fsys.Rename(from, to); err != nil {
+		return nil, err
+	}
+	return &ninep.Rrename{}, nil
+}
+
+// childOf resolves path of name in directory of fid id
+func (c *conn) childOf(fsys billyfs.FoundationDbFs, id uint32, name string) (string, error) {
+	f, err := c.fid(id)
+	if err != nil {
+		return "", err
+	}
+	defer f.mu.Unlock()
+	return f.child(fsys, name)
+}
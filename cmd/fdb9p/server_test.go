@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/ninep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dial serves fs on a unix socket and connects a client attached at fid 0 to aname
+func dial(t *testing.T, fs billyfs.FoundationDbFs, aname string) *ninep.Client {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "9p.sock"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go newServer(fs).serve(listener)
+
+	conn, err := net.Dial("unix", listener.Addr().String())
+	require.NoError(t, err)
+	client, err := ninep.NewClient(conn, 8192)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	_, err = client.Attach(0, "user", aname)
+	require.NoError(t, err)
+	return client
+}
+
+func TestReadWrite(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	client := dial(t, fs, "")
+
+	_, err := client.Mkdir(0, "dir", 0755)
+	require.NoError(t, err)
+	_, err = client.Mkdir(0, "dir", 0755)
+	assert.Equal(t, syscall.EEXIST, err)
+
+	_, err = client.Walk(0, 1, "dir")
+	require.NoError(t, err)
+	_, iounit, err := client.Create(1, "file", syscall.O_RDWR, 0644)
+	require.NoError(t, err)
+	assert.Equal(t, client.Msize()-ninep.IOHeaderSize, iounit)
+
+	content := make([]byte, 20000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	n, err := client.WriteAt(1, content, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(content), n, "Writes are split by msize")
+	require.NoError(t, client.Fsync(1))
+	require.NoError(t, client.Clunk(1))
+
+	stored, err := fs.Open("/dir/file")
+	require.NoError(t, err)
+	data, _ := ioutil.ReadAll(stored)
+	stored.Close()
+	assert.Equal(t, content, data)
+
+	_, err = client.Walk(0, 2, "dir", "file")
+	require.NoError(t, err)
+	_, _, err = client.Open(2, syscall.O_RDONLY)
+	require.NoError(t, err)
+	read := make([]byte, 100)
+	n, err = client.ReadAt(2, read, 19950)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, content[19950:], read[:n])
+
+	attr, err := client.Getattr(2, ninep.GetattrAll)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(content)), attr.Size)
+	assert.Equal(t, uint32(syscall.S_IFREG|0644), attr.Mode)
+	assert.Equal(t, uint8(ninep.QTFile), attr.Qid.Type)
+
+	require.NoError(t, client.Setattr(2, ninep.Tsetattr{Valid: ninep.SetattrSize | ninep.SetattrMode, Size: 3, Mode: 0600}))
+	attr, err = client.Getattr(2, ninep.GetattrAll)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), attr.Size)
+	assert.Equal(t, uint32(syscall.S_IFREG|0600), attr.Mode)
+
+	_, err = client.Walk(0, 3, "missing")
+	assert.Equal(t, syscall.ENOENT, err)
+	qids, err := client.Walk(0, 3, "dir", "missing")
+	assert.Len(t, qids, 1, "Walk stops at the first missing name")
+	_, err = client.Getattr(3, ninep.GetattrBasic)
+	assert.Equal(t, syscall.EBADF, err, "Partial walk does not bind new fid")
+}
+
+func TestDirectories(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	require.NoError(t, fs.MkdirAll("/export/dir", os.ModePerm))
+	for _, name := range []string{"a", "b", "c"} {
+		f, err := fs.Create("/export/dir/" + name)
+		require.NoError(t, err)
+		f.Close()
+	}
+	require.NoError(t, fs.MkdirAll("/private", os.ModePerm))
+	client := dial(t, fs, "/export")
+
+	_, err := client.Walk(0, 1, "..", "private")
+	assert.Equal(t, syscall.ENOENT, err, "Walks do not leave attach root")
+
+	_, err = client.Walk(0, 1, "dir")
+	require.NoError(t, err)
+	_, _, err = client.Open(1, syscall.O_RDONLY|syscall.O_DIRECTORY)
+	require.NoError(t, err)
+	entries, err := client.Readdir(1)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+		assert.Equal(t, uint8(ninep.DTReg), entry.Type)
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, names)
+
+	_, err = client.Walk(0, 2, "dir")
+	require.NoError(t, err)
+	require.NoError(t, client.Renameat(2, "a", 0, "moved"))
+	_, err = fs.Stat("/export/moved")
+	assert.NoError(t, err)
+
+	require.NoError(t, fs.Rename("/export/dir", "/export/renamed"))
+	require.NoError(t, client.Unlinkat(2, "b", 0), "Fids follow renames")
+	assert.Equal(t, syscall.ENOTEMPTY, client.Unlinkat(0, "renamed", ninep.AtRemoveDir))
+	assert.Equal(t, syscall.EISDIR, client.Unlinkat(0, "renamed", 0))
+	require.NoError(t, client.Unlinkat(2, "c", 0))
+	require.NoError(t, client.Remove(2))
+	_, err = fs.Stat("/export/renamed")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = client.Symlink(0, "link", "moved")
+	require.NoError(t, err)
+	_, err = client.Walk(0, 3, "link")
+	require.NoError(t, err)
+	target, err := client.Readlink(3)
+	require.NoError(t, err)
+	assert.Equal(t, "moved", target)
+}
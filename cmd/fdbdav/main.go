@@ -0,0 +1,38 @@
+// Command fdbdav serves FoundationDbFs over WebDAV. Locks are kept in fdb, so any number of replicas can serve the
+// same filesystem behind a load balancer.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/davfs"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to serve WebDAV on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	handler := davfs.Handler(fs)
+	handler.Logger = func(r *http.Request, err error) {
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+
+	log.Printf("Serving WebDAV on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, handler))
+}
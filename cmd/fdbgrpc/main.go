@@ -0,0 +1,40 @@
+// Command fdbgrpc serves FoundationDbFs over gRPC, see grpcfs/fspb/fs.proto for the service definition.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	listen := flag.String("listen", ":9090", "address to serve gRPC on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed listening on %s: %v", *listen, err)
+	}
+
+	server := grpc.NewServer()
+	fspb.RegisterFileSystemServer(server, grpcfs.NewServer(fs))
+
+	log.Printf("Serving gRPC on %s", listener.Addr())
+	log.Fatal(server.Serve(listener))
+}
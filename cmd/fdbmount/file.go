@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// file is an open file, reads and writes of kernel go to ReadAt and WriteAt of the file
+type file struct {
+	f        *billyfs.FoundationDbFile
+	writable bool
+}
+
+var (
+	_ fs.FileReader   = &file{}
+	_ fs.FileWriter   = &file{}
+	_ fs.FileReleaser = &file{}
+)
+
+// openFlags keeps access mode and truncation of open flags. Append is dropped, kernel passes offsets of appends.
+func openFlags(flags uint32) int {
+	return int(flags) & (syscall.O_ACCMODE | os.O_TRUNC)
+}
+
+func newFile(f *billyfs.FoundationDbFile, flags uint32) *file {
+	return &file{f: f, writable: int(flags)&syscall.O_ACCMODE != os.O_RDONLY}
+}
+
+// Read reads at off, request context bounds the read
+func (f *file) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := f.f.ReadAtContext(ctx, dest, off)
+	if n == 0 && err != nil && !errors.Is(err, io.EOF) {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// Write writes at off, request context bounds the write
+func (f *file) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	n, err := f.f.WriteAtContext(ctx, data, off)
+	if err != nil && n == 0 {
+		return 0, errno(err)
+	}
+	return uint32(n), 0
+}
+
+// Release closes the file
+func (f *file) Release(context.Context) syscall.Errno {
+	return errno(f.f.Close())
+}
@@ -0,0 +1,89 @@
+// Command fdbmount mounts FoundationDbFs with FUSE.
+//
+// Mount it with e.g. fdbmount -cluster /etc/foundationdb/fdb.cluster /mnt, unmount with fusermount -u /mnt or by
+// interrupting the command.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// mountOptions configures kernel caches of a mount
+type mountOptions struct {
+	entryTimeout    time.Duration
+	attrTimeout     time.Duration
+	negativeTimeout time.Duration
+	allowOther      bool
+	debug           bool
+}
+
+// mount mounts fsys at dir
+func mount(fsys billyfs.FoundationDbFs, dir string, opts mountOptions) (*fuse.Server, error) {
+	root, err := newRoot(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Mount(dir, root, &fs.Options{
+		EntryTimeout:    &opts.entryTimeout,
+		AttrTimeout:     &opts.attrTimeout,
+		NegativeTimeout: &opts.negativeTimeout,
+		MountOptions: fuse.MountOptions{
+			FsName:      "fdb",
+			Name:        "fdbmount",
+			AllowOther:  opts.allowOther,
+			Debug:       opts.debug,
+			DirectMount: true,
+		},
+	})
+}
+
+func main() {
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "mount in-process memory store instead of fdb, content is lost on exit")
+	var opts mountOptions
+	flag.DurationVar(&opts.entryTimeout, "entry-timeout", time.Second, "how long kernel caches names")
+	flag.DurationVar(&opts.attrTimeout, "attr-timeout", time.Second, "how long kernel caches attributes")
+	flag.DurationVar(&opts.negativeTimeout, "negative-timeout", 0, "how long kernel caches missing names")
+	flag.BoolVar(&opts.allowOther, "allow-other", false, "allow other users to access the mount")
+	flag.BoolVar(&opts.debug, "debug", false, "log fuse requests")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: %s [flags] mountpoint", os.Args[0])
+	}
+
+	fsOpts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		fsOpts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fsys, err := billyfs.NewFoundationDbFsWithOptions(fsOpts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	server, err := mount(fsys, flag.Arg(0), opts)
+	if err != nil {
+		log.Fatalf("Failed mounting %s: %v", flag.Arg(0), err)
+	}
+	log.Printf("Mounted on %s", flag.Arg(0))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := server.Unmount(); err != nil {
+			log.Printf("Failed unmounting: %v", err)
+		}
+	}()
+
+	server.Wait()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mountTemp mounts fsys into a temp dir without kernel caches, test is skipped when fuse is not usable
+func mountTemp(t *testing.T, fsys billyfs.FoundationDbFs) string {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("/dev/fuse is not available")
+	}
+
+	dir := t.TempDir()
+	server, err := mount(fsys, dir, mountOptions{})
+	if err != nil {
+		t.Skipf("Mounting is not permitted: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("Failed unmounting %s: %v", dir, err)
+		}
+	})
+	return dir
+}
+
+func TestMount(t *testing.T) {
+	fsys := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	dir := mountTemp(t, fsys)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	assert.True(t, os.IsExist(os.Mkdir(filepath.Join(dir, "sub"), 0755)), "Directory exists")
+
+	name := filepath.Join(dir, "sub", "file")
+	require.NoError(t, ioutil.WriteFile(name, []byte("0123456789"), 0644))
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("ab"), 4)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	content, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "0123ab6789", string(content))
+
+	stored, err := fsys.Open("/sub/file")
+	require.NoError(t, err, "Files are written to the filesystem")
+	content, _ = ioutil.ReadAll(stored)
+	stored.Close()
+	assert.Equal(t, "0123ab6789", string(content))
+
+	require.NoError(t, os.Truncate(name, 3))
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), info.Size())
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "sub"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "file", entries[0].Name())
+
+	moved := filepath.Join(dir, "moved")
+	require.NoError(t, os.Rename(name, moved))
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+
+	err = syscall.Rmdir(filepath.Join(dir, "sub"))
+	assert.NoError(t, err, "Emptied directory is removed")
+	assert.Equal(t, syscall.EISDIR, syscall.Unlink(dir), "Unlink does not remove directories")
+	require.NoError(t, os.Remove(moved))
+	_, err = fsys.Stat("/moved")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMountSeesOtherClients(t *testing.T) {
+	fsys := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	dir := mountTemp(t, fsys)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "full"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "full", "file"), nil, 0644))
+	assert.Equal(t, syscall.ENOTEMPTY, syscall.Rmdir(filepath.Join(dir, "full")))
+
+	f, err := os.Open(filepath.Join(dir, "full", "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, fsys.Rename("/full", "/renamed"))
+	written, err := fsys.Create("/renamed/other")
+	require.NoError(t, err)
+	_, err = written.Write([]byte("other"))
+	require.NoError(t, err)
+	written.Close()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "renamed", "other"))
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+	_, err = os.Stat(filepath.Join(dir, "full"))
+	assert.True(t, os.IsNotExist(err), "Rename of other client is seen")
+
+	_, err = f.Stat()
+	assert.NoError(t, err, "Open file follows rename")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"golang.org/x/sys/unix"
+)
+
+// blockSize is reported to the kernel as preferred io size
+const blockSize = 64 * 1024
+
+// node is a kernel inode backed by a filesystem node. It keeps billyfs handle and resolves its current path on
+// every operation, so renames and removals done by other clients are seen once kernel caches expire.
+type node struct {
+	fs.Inode
+	fs     billyfs.FoundationDbFs
+	handle billyfs.Handle
+}
+
+var (
+	_ fs.NodeLookuper   = &node{}
+	_ fs.NodeGetattrer  = &node{}
+	_ fs.NodeSetattrer  = &node{}
+	_ fs.NodeReaddirer  = &node{}
+	_ fs.NodeOpener     = &node{}
+	_ fs.NodeCreater    = &node{}
+	_ fs.NodeMkdirer    = &node{}
+	_ fs.NodeUnlinker   = &node{}
+	_ fs.NodeRmdirer    = &node{}
+	_ fs.NodeRenamer    = &node{}
+	_ fs.NodeReadlinker = &node{}
+)
+
+// newRoot creates node of the filesystem root
+func newRoot(fsys billyfs.FoundationDbFs) (*node, error) {
+	handle, err := fsys.Handle("/")
+	if err != nil {
+		return nil, err
+	}
+	return &node{fs: fsys, handle: handle}, nil
+}
+
+// errno maps filesystem errors to errnos kernel understands
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &e):
+		return e
+	case os.IsNotExist(err):
+		return syscall.ENOENT
+	case os.IsExist(err):
+		return syscall.EEXIST
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
+
+// unixMode converts os.FileMode to mode bits of stat
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	default:
+		m |= syscall.S_IFREG
+	}
+	return m
+}
+
+func ino(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		// fuse reserves 1 for the root, which is node 0
+		return uint64(stat.Node) + 1
+	}
+	return 1
+}
+
+func fillAttr(info os.FileInfo, out *fuse.Attr) {
+	out.Ino = ino(info)
+	out.Mode = unixMode(info.Mode())
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	out.Blksize = blockSize
+	out.Nlink = 1
+	mtime := info.ModTime()
+	out.SetTimes(&mtime, &mtime, &mtime)
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		out.Uid, out.Gid = uint32(stat.Uid), uint32(stat.Gid)
+	}
+}
+
+// bound returns filesystem bound to context of the request, it ends when the request is interrupted
+func (n *node) bound(ctx context.Context) billyfs.FoundationDbFs {
+	return n.fs.WithContext(ctx)
+}
+
+func (n *node) path(fsys billyfs.FoundationDbFs) (string, syscall.Errno) {
+	p, err := fsys.HandlePath(n.handle)
+	return p, errno(err)
+}
+
+// child resolves path of entry name of directory n
+func (n *node) child(fsys billyfs.FoundationDbFs, name string) (string, syscall.Errno) {
+	p, e := n.path(fsys)
+	if e != 0 {
+		return "", e
+	}
+	return path.Join(p, name), 0
+}
+
+// newChild stats path and creates inode for it
+func (n *node) newChild(ctx context.Context, fsys billyfs.FoundationDbFs, p string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	handle, err := fsys.Handle(p)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	fillAttr(info, &out.Attr)
+	child := &node{fs: n.fs, handle: handle}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT, Ino: out.Attr.Ino}), 0
+}
+
+// Lookup finds entry of directory
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fsys := n.bound(ctx)
+	p, e := n.child(fsys, name)
+	if e != 0 {
+		return nil, e
+	}
+	return n.newChild(ctx, fsys, p, out)
+}
+
+// Getattr stats node without resolving its path
+func (n *node) Getattr(ctx context.Context, _ fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := n.bound(ctx).StatHandle(n.handle)
+	if err != nil {
+		return errno(err)
+	}
+	fillAttr(info, &out.Attr)
+	return 0
+}
+
+// Setattr truncates, changes mode, owner and modification time
+func (n *node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fsys := n.bound(ctx)
+
+	if size, ok := in.GetSize(); ok {
+		if e := n.truncate(fsys, fh, int64(size)); e != 0 {
+			return e
+		}
+	}
+
+	p, e := n.path(fsys)
+	if e != 0 {
+		return e
+	}
+	if mode, ok := in.GetMode(); ok {
+		if err := fsys.Chmod(p, os.FileMode(mode).Perm()); err != nil {
+			return errno(err)
+		}
+	}
+	uid, hasUID := in.GetUID()
+	gid, hasGID := in.GetGID()
+	if hasUID || hasGID {
+		info, err := fsys.Lstat(p)
+		if err != nil {
+			return errno(err)
+		}
+		if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+			if !hasUID {
+				uid = uint32(stat.Uid)
+			}
+			if !hasGID {
+				gid = uint32(stat.Gid)
+			}
+		}
+		if err = fsys.Lchown(p, int(uid), int(gid)); err != nil {
+			return errno(err)
+		}
+	}
+	if mtime, ok := in.GetMTime(); ok {
+		atime, _ := in.GetATime()
+		if err := fsys.Chtimes(p, atime, mtime); err != nil {
+			return errno(err)
+		}
+	}
+
+	return n.Getattr(ctx, fh, out)
+}
+
+// truncate resizes through open file when kernel passes one
+func (n *node) truncate(fsys billyfs.FoundationDbFs, fh fs.FileHandle, size int64) syscall.Errno {
+	if f, ok := fh.(*file); ok && f.writable {
+		return errno(f.f.Truncate(size))
+	}
+
+	f, err := fsys.OpenHandle(n.handle, os.O_WRONLY)
+	if err != nil {
+		return errno(err)
+	}
+	err = f.Truncate(size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errno(err)
+}
+
+// Readdir lists entries of directory at once, the stream is served from memory
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	fsys := n.bound(ctx)
+	p, e := n.path(fsys)
+	if e != 0 {
+		return nil, e
+	}
+	infos, err := fsys.ReadDir(p)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fuse.DirEntry{Name: info.Name(), Mode: unixMode(info.Mode()), Ino: ino(info)})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+// Open opens node by handle, files stay usable after being renamed. Files are not bound to context of the
+// request, they outlive it.
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	f, err := n.fs.OpenHandle(n.handle, openFlags(flags))
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	return newFile(f, flags), 0, 0
+}
+
+// Create creates and opens a file
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	fsys := n.bound(ctx)
+	p, e := n.child(fsys, name)
+	if e != 0 {
+		return nil, nil, 0, e
+	}
+
+	f, err := n.fs.OpenFile(p, openFlags(flags)|os.O_CREATE, os.FileMode(mode).Perm())
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	inode, e := n.newChild(ctx, fsys, p, out)
+	if e != 0 {
+		f.Close()
+		return nil, nil, 0, e
+	}
+	return inode, newFile(f.(*billyfs.FoundationDbFile), flags), 0, 0
+}
+
+// Mkdir creates directory, fs would create missing parents and accept existing directories, kernel has checked
+// the former and the latter is checked here
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fsys := n.bound(ctx)
+	p, e := n.child(fsys, name)
+	if e != 0 {
+		return nil, e
+	}
+
+	if _, err := fsys.Lstat(p); err == nil {
+		return nil, syscall.EEXIST
+	}
+	if err := fsys.MkdirAll(p, os.ModeDir|os.FileMode(mode).Perm()); err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, fsys, p, out)
+}
+
+// Unlink removes a file or symlink
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	fsys := n.bound(ctx)
+	p, e := n.child(fsys, name)
+	if e != 0 {
+		return e
+	}
+
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return errno(err)
+	}
+	if info.IsDir() {
+		return syscall.EISDIR
+	}
+	return errno(fsys.Remove(p))
+}
+
+// Rmdir removes an empty directory
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	fsys := n.bound(ctx)
+	p, e := n.child(fsys, name)
+	if e != 0 {
+		return e
+	}
+
+	info, err := fsys.Lstat(p)
+	if err != nil {
+		return errno(err)
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return errno(fsys.Remove(p))
+}
+
+// Rename moves entry, RENAME_NOREPLACE is honoured and RENAME_EXCHANGE is not supported
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&fs.RENAME_EXCHANGE != 0 {
+		return syscall.ENOTSUP
+	}
+
+	fsys := n.bound(ctx)
+	from, e := n.child(fsys, name)
+	if e != 0 {
+		return e
+	}
+	parent, ok := newParent.(*node)
+	if !ok {
+		return syscall.EXDEV
+	}
+	to, e := parent.child(fsys, newName)
+	if e != 0 {
+		return e
+	}
+
+	if flags&unix.RENAME_NOREPLACE != 0 {
+		if _, err := fsys.Lstat(to); err == nil {
+			return syscall.EEXIST
+		}
+	}
+	return errno(fsys.Rename(from, to))
+}
+
+// Readlink reads target of symlink
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	fsys := n.bound(ctx)
+	p, e := n.path(fsys)
+	if e != 0 {
+		return nil, e
+	}
+	target, err := fsys.Readlink(p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return []byte(target), 0
+}
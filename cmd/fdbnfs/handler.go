@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/file"
+)
+
+// handleLimit bounds entries returned by a single readdir call to half of it
+const handleLimit = 1024
+
+// handler serves FoundationDbFs over NFSv3. File handles are billyfs handles, so they stay valid across renames
+// and server restarts, handles of removed nodes are reported stale.
+type handler struct {
+	fs nodeFs
+}
+
+var _ nfs.Handler = &handler{}
+
+func newHandler(fs billyfs.FoundationDbFs) *handler {
+	return &handler{nodeFs{fs}}
+}
+
+// Mount exports the whole filesystem to anybody
+func (h *handler) Mount(context.Context, net.Conn, nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	return nfs.MountStatusOk, h.fs, []nfs.AuthFlavor{nfs.AuthFlavorNull}
+}
+
+// Change allows chmod, chown and chtimes
+func (h *handler) Change(billy.Filesystem) billy.Change {
+	return h.fs
+}
+
+// FSStat keeps defaults, capacity of a cluster is not known to a filesystem
+func (h *handler) FSStat(context.Context, billy.Filesystem, *nfs.FSStat) error {
+	return nil
+}
+
+// ToHandle returns stable handle of path, symlinks are not followed
+func (h *handler) ToHandle(_ billy.Filesystem, path []string) []byte {
+	handle, err := h.fs.Handle("/" + strings.Join(path, "/"))
+	if err != nil {
+		return nil
+	}
+	return handle
+}
+
+// FromHandle finds current path of node
+func (h *handler) FromHandle(handle []byte) (billy.Filesystem, []string, error) {
+	p, err := h.fs.HandlePath(handle)
+	switch {
+	case errors.Is(err, billyfs.ErrStaleHandle):
+		return nil, nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale, WrappedErr: err}
+	case errors.Is(err, syscall.EBADF):
+		return nil, nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusBadHandle, WrappedErr: err}
+	case err != nil:
+		return nil, nil, err
+	}
+
+	return h.fs, split(p), nil
+}
+
+// InvalidateHandle does nothing, handles are not cached
+func (h *handler) InvalidateHandle(billy.Filesystem, []byte) error {
+	return nil
+}
+
+// HandleLimit is not a limit of handles, which are stateless, it sizes readdir replies
+func (h *handler) HandleLimit() int {
+	return handleLimit
+}
+
+func split(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
+
+// node returns node id of file info, root has none
+func node(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		return stat.Node
+	}
+	return 0
+}
+
+// nodeFs reports node ids as NFS file ids, otherwise they would be hashes of paths changing with every rename
+type nodeFs struct {
+	billyfs.FoundationDbFs
+}
+
+func (fs nodeFs) Stat(path string) (os.FileInfo, error) {
+	return withFileId(fs.FoundationDbFs.Stat(path))
+}
+
+func (fs nodeFs) Lstat(path string) (os.FileInfo, error) {
+	return withFileId(fs.FoundationDbFs.Lstat(path))
+}
+
+func (fs nodeFs) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := fs.FoundationDbFs.ReadDir(path)
+	for i := range infos {
+		infos[i], _ = withFileId(infos[i], nil)
+	}
+	return infos, err
+}
+
+// fileIdInfo exposes file.FileInfo go-nfs looks for in Sys
+type fileIdInfo struct {
+	os.FileInfo
+	sys file.FileInfo
+}
+
+func (f fileIdInfo) Sys() interface{} {
+	return f.sys
+}
+
+// withFileId maps node to file id + 1, since 0 is not a valid inode number
+func withFileId(info os.FileInfo, err error) (os.FileInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	sys := file.FileInfo{Nlink: 1, Fileid: uint64(node(info)) + 1}
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		sys.UID, sys.GID = uint32(stat.Uid), uint32(stat.Gid)
+	}
+
+	return fileIdInfo{info, sys}, nil
+}
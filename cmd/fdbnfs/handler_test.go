@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	nfs "github.com/willscott/go-nfs"
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// serve starts NFS server over fs and mounts it with pure-Go client
+func serve(t *testing.T, fs billyfs.FoundationDbFs) *nfsc.Target {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go nfs.Serve(listener, newHandler(fs))
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	client, err := rpc.DialTCP("tcp", listener.Addr().String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	mount := nfsc.Mount{Client: client}
+	target, err := mount.Mount("/", rpc.AuthNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func TestReadWrite(t *testing.T) {
+	target := serve(t, billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+
+	if _, err := target.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Create("/dir/hello", 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := target.OpenFile("/dir/hello", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("hello over nfs")
+	if _, err = w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r, err := target.Open("/dir/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	read, err := io.ReadAll(r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, read) {
+		t.Errorf("Read %q, wrote %q", read, content)
+	}
+}
+
+func TestHandlesSurviveRenameAndRestart(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	if err := fs.MkdirAll("/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/a/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, handle, err := serve(t, fs).Lookup("/a/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// another server over the same store stands for a restarted one
+	restarted := serve(t, fs)
+	attr, err := restarted.GetAttr(handle)
+	if err != nil {
+		t.Fatalf("Handle is not valid after rename and restart: %v", err)
+	}
+	if attr.Type != nfsc.NF3Reg {
+		t.Errorf("Handle resolves to %v, not a regular file", attr.Type)
+	}
+
+	if err = fs.Remove("/b/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = restarted.GetAttr(handle); err == nil {
+		t.Error("Handle of removed file is still valid")
+	}
+}
+
+func TestStaleHandle(t *testing.T) {
+	h := newHandler(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+
+	_, _, err := h.FromHandle([]byte{0, 0, 0, 0, 0, 0, 0, 42})
+	var status *nfs.NFSStatusError
+	if !errors.As(err, &status) || status.NFSStatus != nfs.NFSStatusStale {
+		t.Errorf("Unknown node is not stale: %v", err)
+	}
+
+	_, _, err = h.FromHandle([]byte{1})
+	if !errors.As(err, &status) || status.NFSStatus != nfs.NFSStatusBadHandle {
+		t.Errorf("Short handle is not bad: %v", err)
+	}
+}
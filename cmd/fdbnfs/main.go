@@ -0,0 +1,37 @@
+// Command fdbnfs serves FoundationDbFs over NFSv3.
+//
+// Mount it with e.g. mount -t nfs -o port=2049,mountport=2049,nfsvers=3,noacl,tcp localhost:/ /mnt
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	nfs "github.com/willscott/go-nfs"
+)
+
+func main() {
+	listen := flag.String("listen", ":2049", "address to serve NFS and mount protocols on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed listening on %s: %v", *listen, err)
+	}
+	log.Printf("Serving NFS on %s", listener.Addr())
+
+	log.Fatal(nfs.Serve(listener, newHandler(fs)))
+}
@@ -0,0 +1,31 @@
+// Command fdbs3 serves FoundationDbFs over S3 compatible REST API with path-style addressing. Buckets are top level
+// directories, so the same content can be served by fdbnfs or fdbdav. Requests are not authenticated.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/s3gw"
+)
+
+func main() {
+	listen := flag.String("listen", ":9000", "address to serve S3 API on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	log.Printf("Serving S3 on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, s3gw.New(fs)))
+}
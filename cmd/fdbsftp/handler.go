@@ -0,0 +1,266 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/pkg/sftp"
+)
+
+// handler serves sftp requests of a single session. Paths of requests and symlinks they follow are confined to
+// root, files are FoundationDbFile, so reads and writes of clients go to ReadAt and WriteAt of the file.
+type handler struct {
+	fs   billyfs.FoundationDbFs
+	root string
+}
+
+var (
+	_ sftp.OpenFileWriter       = &handler{}
+	_ sftp.FileReader           = &handler{}
+	_ sftp.PosixRenameFileCmder = &handler{}
+	_ sftp.LstatFileLister      = &handler{}
+	_ sftp.ReadlinkFileLister   = &handler{}
+)
+
+func newHandler(fs billyfs.FoundationDbFs, root string) *handler {
+	root = path.Clean("/" + root)
+	return &handler{fs: fs.Confine(root), root: root}
+}
+
+// handlers serves all kinds of requests with h
+func (h *handler) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// path maps path of request, which sftp cleans to an absolute path, below root
+func (h *handler) path(p string) string {
+	return path.Join(h.root, p)
+}
+
+// within tells whether p is root or below it
+func (h *handler) within(p string) bool {
+	return h.root == "/" || p == h.root || strings.HasPrefix(p, h.root+"/")
+}
+
+// relative strips root of p, it is the reverse of path
+func (h *handler) relative(p string) string {
+	if h.root == "/" {
+		return p
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(p, h.root), "/")
+}
+
+// Fileread opens file for reading
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return h.open(r, os.O_RDONLY)
+}
+
+// Filewrite opens file for writing
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.open(r, os.O_WRONLY)
+}
+
+// OpenFile opens file for reading and writing
+func (h *handler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return h.open(r, os.O_RDWR)
+}
+
+// open opens file bound to context of request, which ends when client closes the file. Append is ignored,
+// offsets of writes are chosen by client.
+func (h *handler) open(r *sftp.Request, flag int) (*billyfs.FoundationDbFile, error) {
+	flags := r.Pflags()
+	if flags.Creat {
+		flag |= os.O_CREATE
+	}
+	if flags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if flags.Excl {
+		flag |= os.O_EXCL
+	}
+
+	perm := os.FileMode(0644)
+	if r.AttrFlags().Permissions {
+		perm = r.Attributes().FileMode().Perm()
+	}
+
+	f, err := h.fs.OpenFileContext(r.Context(), h.path(r.Filepath), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*billyfs.FoundationDbFile), nil
+}
+
+// Filecmd runs requests changing the filesystem
+func (h *handler) Filecmd(r *sftp.Request) error {
+	fs := h.fs.WithContext(r.Context())
+	p := h.path(r.Filepath)
+
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(fs, r, p)
+	case "Rename":
+		// sftp rename does not replace existing files, PosixRename does
+		if _, err := fs.Lstat(h.path(r.Target)); err == nil {
+			return &os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrExist}
+		}
+		return fs.Rename(p, h.path(r.Target))
+	case "Rmdir":
+		info, err := fs.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: syscall.ENOTDIR}
+		}
+		if p == h.root {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: syscall.EPERM}
+		}
+		return fs.Remove(p)
+	case "Remove":
+		info, err := fs.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return &os.PathError{Op: "remove", Path: r.Filepath, Err: syscall.EISDIR}
+		}
+		return fs.Remove(p)
+	case "Mkdir":
+		// fs creates missing parents, sftp clients expect them to exist
+		parent, err := fs.Stat(path.Dir(p))
+		if err != nil {
+			return err
+		}
+		if !parent.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: r.Filepath, Err: syscall.ENOTDIR}
+		}
+		if _, err = fs.Lstat(p); err == nil {
+			return &os.PathError{Op: "mkdir", Path: r.Filepath, Err: os.ErrExist}
+		}
+		return fs.MkdirAll(p, os.ModeDir|0755)
+	case "Symlink":
+		// Filepath is the target as sent by client, Target is the link
+		return h.symlink(fs, r.Filepath, r.Target)
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// symlink links inside of root. Absolute targets are relative to root, relative targets may not lead out of it
+// where they are created. Renames may move them out, resolution of the confined fs refuses to follow them then.
+func (h *handler) symlink(fs billyfs.FoundationDbFs, target string, link string) error {
+	p := h.path(link)
+	if path.IsAbs(target) {
+		target = h.path(target)
+	} else if !h.within(path.Join(path.Dir(p), target)) {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: syscall.EPERM}
+	}
+
+	return fs.Symlink(target, p)
+}
+
+func (h *handler) setstat(fs billyfs.FoundationDbFs, r *sftp.Request, p string) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+
+	if flags.Size {
+		f, err := fs.OpenFile(p, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(int64(attrs.Size))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := fs.Chmod(p, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := fs.Chown(p, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		atime, mtime := time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)
+		if err := fs.Chtimes(p, atime, mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PosixRename replaces existing target
+func (h *handler) PosixRename(r *sftp.Request) error {
+	return h.fs.WithContext(r.Context()).Rename(h.path(r.Filepath), h.path(r.Target))
+}
+
+// Filelist lists directories and stats files
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	fs := h.fs.WithContext(r.Context())
+	p := h.path(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		infos, err := fs.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := fs.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats symlinks instead of their targets
+func (h *handler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.fs.WithContext(r.Context()).Lstat(h.path(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+	return listerAt{info}, nil
+}
+
+// Readlink reads target of symlink, absolute targets inside of root are made relative to it
+func (h *handler) Readlink(p string) (string, error) {
+	target, err := h.fs.Readlink(h.path(p))
+	if err != nil {
+		return "", err
+	}
+	if path.IsAbs(target) && h.within(target) {
+		return h.relative(target), nil
+	}
+	return target, nil
+}
+
+type listerAt []os.FileInfo
+
+// ListAt copies infos starting at offset to ls
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
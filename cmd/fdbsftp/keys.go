@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"golang.org/x/crypto/ssh"
+)
+
+// keySource finds public keys user may log in with
+type keySource interface {
+	keys(user string) ([]ssh.PublicKey, error)
+}
+
+// dirKeys reads authorized_keys formatted file named after user from a local directory
+type dirKeys string
+
+func (d dirKeys) keys(user string) ([]ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(d), user))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseAuthorizedKeys(data)
+}
+
+// fsKeys reads .ssh/authorized_keys from the home of user in the filesystem, so users can manage their keys over
+// sftp once they have logged in
+type fsKeys struct {
+	fs   billyfs.FoundationDbFs
+	home func(user string) string
+}
+
+func (k fsKeys) keys(user string) ([]ssh.PublicKey, error) {
+	f, err := k.fs.Open(path.Join(k.home(user), ".ssh", "authorized_keys"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return parseAuthorizedKeys(data)
+}
+
+func parseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
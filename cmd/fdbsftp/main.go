@@ -0,0 +1,73 @@
+// Command fdbsftp serves FoundationDbFs over SFTP. Users log in with public keys and are confined to their home
+// directories, which are created on first login.
+//
+// Authorized keys are read from a local directory holding an authorized_keys file per user when -authorized-keys
+// is set, otherwise from .ssh/authorized_keys of the home of user in the filesystem.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	listen := flag.String("listen", ":2022", "address to serve SFTP on")
+	clusterFile := flag.String("cluster", "", "fdb cluster file, default cluster file when empty")
+	memory := flag.Bool("memory", false, "serve in-process memory store instead of fdb, content is lost on exit")
+	hostKeyFile := flag.String("host-key", "", "PEM private host key, a key is generated on every start when empty")
+	authorizedKeys := flag.String("authorized-keys", "", "directory of authorized_keys files named after users")
+	home := flag.String("home", "/home/%s", "home directory users are confined to, %s is replaced with user name")
+	flag.Parse()
+
+	opts := []billyfs.Option{billyfs.WithClusterFile(*clusterFile)}
+	if *memory {
+		opts = []billyfs.Option{billyfs.WithStore(billyfs.NewMemoryStore())}
+	}
+	fs, err := billyfs.NewFoundationDbFsWithOptions(opts...)
+	if err != nil {
+		log.Fatalf("Failed opening filesystem: %v", err)
+	}
+
+	hostKey, err := loadHostKey(*hostKeyFile)
+	if err != nil {
+		log.Fatalf("Failed loading host key: %v", err)
+	}
+
+	homes := homeTemplate(*home)
+	var keys keySource = fsKeys{fs: fs, home: homes}
+	if *authorizedKeys != "" {
+		keys = dirKeys(*authorizedKeys)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed listening on %s: %v", *listen, err)
+	}
+	log.Printf("Serving SFTP on %s", listener.Addr())
+
+	log.Fatal(newServer(fs, keys, homes, hostKey).serve(listener))
+}
+
+func loadHostKey(file string) (ssh.Signer, error) {
+	if file == "" {
+		log.Printf("Using generated host key, clients will see a new one after restart")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pem)
+}
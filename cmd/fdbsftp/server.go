@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// rootExtension passes chroot of authenticated user to its session
+const rootExtension = "fdbsftp-root"
+
+// server accepts ssh connections and serves sftp subsystem of their sessions
+type server struct {
+	fs     billyfs.FoundationDbFs
+	config *ssh.ServerConfig
+	// home maps user to the directory it is confined to
+	home func(user string) string
+}
+
+func newServer(fs billyfs.FoundationDbFs, keys keySource, home func(string) string, hostKey ssh.Signer) *server {
+	s := &server{fs: fs, home: home}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return s.authorize(keys, meta.User(), key)
+		},
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// homeTemplate maps users to directories by replacing %s of template with user name
+func homeTemplate(template string) func(string) string {
+	return func(user string) string {
+		return strings.ReplaceAll(template, "%s", user)
+	}
+}
+
+func (s *server) authorize(keys keySource, user string, key ssh.PublicKey) (*ssh.Permissions, error) {
+	// user names become paths
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\x00") {
+		return nil, fmt.Errorf("invalid_user %q", user)
+	}
+
+	authorized, err := keys.keys(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range authorized {
+		if k.Type() == key.Type() && bytes.Equal(k.Marshal(), key.Marshal()) {
+			return &ssh.Permissions{Extensions: map[string]string{rootExtension: s.home(user)}}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown_key_for_user %q", user)
+}
+
+// serve accepts connections until listener is closed
+func (s *server) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *server) handle(conn net.Conn) {
+	sconn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Printf("Handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(requests)
+
+	root := sconn.Permissions.Extensions[rootExtension]
+	if err = s.fs.MkdirAll(root, os.ModeDir|0755); err != nil {
+		log.Printf("Failed creating root %s of %s: %v", root, sconn.User(), err)
+		return
+	}
+
+	for ch := range channels {
+		if ch.ChannelType() != "session" {
+			ch.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			log.Printf("Failed accepting channel of %s: %v", sconn.User(), err)
+			continue
+		}
+		go s.session(channel, requests, root)
+	}
+}
+
+// session serves sftp once client asks for the subsystem, other requests are refused
+func (s *server) session(channel ssh.Channel, requests <-chan *ssh.Request, root string) {
+	defer channel.Close()
+
+	for req := range requests {
+		// payload of subsystem request is ssh string with subsystem name
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+		server := sftp.NewRequestServer(channel, newHandler(s.fs, root).handlers())
+		if err := server.Serve(); err != nil && err != io.EOF {
+			log.Printf("Sftp session failed: %v", err)
+		}
+		server.Close()
+		return
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+// serve starts server over fs and returns its address
+func serve(t *testing.T, fs billyfs.FoundationDbFs, keys keySource) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go newServer(fs, keys, homeTemplate("/home/%s"), newSigner(t)).serve(listener)
+	return listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string, user string, key ssh.Signer) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	return client, nil
+}
+
+// writeKeys authorizes key of user in directory dir
+func writeKeys(t *testing.T, dir string, user string, key ssh.Signer) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, user), ssh.MarshalAuthorizedKey(key.PublicKey()), 0600))
+}
+
+func TestReadWrite(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	dir := t.TempDir()
+	key := newSigner(t)
+	writeKeys(t, dir, "alice", key)
+
+	client, err := dial(t, serve(t, fs, dirKeys(dir)), "alice", key)
+	require.NoError(t, err)
+
+	require.NoError(t, client.Mkdir("/in"))
+	f, err := client.Create("/in/data")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("ab"), 4)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	stored, err := fs.Open("/home/alice/in/data")
+	require.NoError(t, err, "User is confined to its home")
+	content, _ := ioutil.ReadAll(stored)
+	stored.Close()
+	assert.Equal(t, "0123ab6789", string(content))
+
+	f, err = client.Open("/in/data")
+	require.NoError(t, err)
+	read := make([]byte, 4)
+	n, err := f.ReadAt(read, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "3ab6", string(read[:n]))
+	f.Close()
+
+	infos, err := client.ReadDir("/in")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "data", infos[0].Name())
+	assert.Equal(t, int64(10), infos[0].Size())
+
+	require.NoError(t, client.Truncate("/in/data", 4))
+	info, err := client.Stat("/in/data")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+
+	require.NoError(t, client.Rename("/in/data", "/in/moved"))
+	other, err := client.Create("/in/other")
+	require.NoError(t, err)
+	other.Close()
+	assert.Error(t, client.Rename("/in/other", "/in/moved"), "Rename does not replace")
+	assert.NoError(t, client.PosixRename("/in/other", "/in/moved"), "Posix rename replaces")
+
+	assert.Error(t, client.Mkdir("/missing/dir"), "Parent has to exist")
+	assert.Error(t, client.RemoveDirectory("/in"), "Directory is not empty")
+	assert.Error(t, client.Remove("/in"), "Directories are removed with rmdir")
+	require.NoError(t, client.Remove("/in/moved"))
+	require.NoError(t, client.RemoveDirectory("/in"))
+	_, err = client.Stat("/in")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChroot(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	require.NoError(t, fs.MkdirAll("/home/bob", os.ModePerm))
+	secret, err := fs.Create("/home/bob/secret")
+	require.NoError(t, err)
+	secret.Close()
+
+	dir := t.TempDir()
+	key := newSigner(t)
+	writeKeys(t, dir, "alice", key)
+	client, err := dial(t, serve(t, fs, dirKeys(dir)), "alice", key)
+	require.NoError(t, err)
+
+	_, err = client.Stat("/../bob/secret")
+	assert.True(t, os.IsNotExist(err), "Paths can't leave home")
+
+	assert.Error(t, client.Symlink("../bob/secret", "/escape"), "Symlinks can't point out of home")
+	require.NoError(t, client.Symlink("/target", "/link"))
+	target, err := client.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/target", target, "Absolute targets are relative to home")
+	stored, err := fs.Readlink("/home/alice/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/alice/target", stored)
+
+	require.NoError(t, client.MkdirAll("/a/b/c"))
+	require.NoError(t, client.Symlink("../../..", "/a/b/c/up"))
+	_, err = client.Stat("/a/b/c/up/a")
+	assert.NoError(t, err, "Relative symlinks inside of home are followed")
+	require.NoError(t, client.Rename("/a/b/c/up", "/up"))
+	_, err = client.Stat("/up/home/bob/secret")
+	assert.Error(t, err, "Symlinks moved to lead out of home are not followed")
+	_, err = client.Open("/up/home/bob/secret")
+	assert.Error(t, err)
+}
+
+func TestAuthorization(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	key, other := newSigner(t), newSigner(t)
+	require.NoError(t, fs.MkdirAll("/home/carol/.ssh", os.ModePerm))
+	keys, err := fs.Create("/home/carol/.ssh/authorized_keys")
+	require.NoError(t, err)
+	_, err = keys.Write(ssh.MarshalAuthorizedKey(key.PublicKey()))
+	require.NoError(t, err)
+	keys.Close()
+
+	addr := serve(t, fs, fsKeys{fs: fs, home: homeTemplate("/home/%s")})
+
+	_, err = dial(t, addr, "carol", other)
+	assert.Error(t, err, "Unknown key is refused")
+	_, err = dial(t, addr, "dave", key)
+	assert.Error(t, err, "Key is authorized for a single user")
+	_, err = dial(t, addr, "..", key)
+	assert.Error(t, err, "User names are path elements")
+
+	client, err := dial(t, addr, "carol", key)
+	require.NoError(t, err)
+	infos, err := client.ReadDir("/.ssh")
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
@@ -0,0 +1,94 @@
+// Package davfs serves FoundationDbFs over WebDAV.
+package davfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"syscall"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem adapts FoundationDbFs to webdav.FileSystem. Every operation is bound to the context of its request.
+type FileSystem struct {
+	fs billyfs.FoundationDbFs
+}
+
+var _ webdav.FileSystem = FileSystem{}
+
+// New creates webdav filesystem over fs
+func New(fs billyfs.FoundationDbFs) FileSystem {
+	return FileSystem{fs}
+}
+
+// Handler returns webdav handler serving fs with locks kept next to it
+func Handler(fs billyfs.FoundationDbFs) *webdav.Handler {
+	return &webdav.Handler{
+		FileSystem: New(fs),
+		LockSystem: NewLockSystem(fs),
+	}
+}
+
+// Mkdir creates a single directory, unlike MkdirAll the parent has to exist
+func (d FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fs := d.fs.WithContext(ctx)
+	if err := parentIsDir(fs, "mkdir", name); err != nil {
+		return err
+	}
+	if _, err := fs.Lstat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	return fs.MkdirAll(name, perm)
+}
+
+// OpenFile opens a file, O_CREATE requires parent directory to exist
+func (d FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fs := d.fs.WithContext(ctx)
+	if flag&os.O_CREATE != 0 {
+		if err := parentIsDir(fs, "open", name); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.(*billyfs.FoundationDbFile), nil
+}
+
+// RemoveAll removes name with everything under it
+func (d FileSystem) RemoveAll(ctx context.Context, name string) error {
+	return d.fs.WithContext(ctx).RemoveAll(name)
+}
+
+// Rename moves oldName, parent of newName has to exist
+func (d FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	fs := d.fs.WithContext(ctx)
+	if err := parentIsDir(fs, "rename", newName); err != nil {
+		return err
+	}
+
+	return fs.Rename(oldName, newName)
+}
+
+// Stat returns file info of name
+func (d FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return d.fs.WithContext(ctx).Stat(name)
+}
+
+func parentIsDir(fs billyfs.FoundationDbFs, op string, name string) error {
+	info, err := fs.Stat(path.Dir(path.Clean("/" + name)))
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package davfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func request(t *testing.T, server *httptest.Server, method string, path string, body string, headers ...string) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+	return resp
+}
+
+func TestServeFiles(t *testing.T) {
+	server := httptest.NewServer(Handler(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())))
+	defer server.Close()
+
+	assert.Equal(t, http.StatusConflict, request(t, server, "PUT", "/missing/file", "x").StatusCode,
+		"Parent has to exist")
+	assert.Equal(t, http.StatusCreated, request(t, server, "MKCOL", "/dir", "").StatusCode)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(t, server, "MKCOL", "/dir", "").StatusCode,
+		"Directory exists")
+	assert.Equal(t, http.StatusCreated, request(t, server, "PUT", "/dir/file", "content").StatusCode)
+
+	resp := request(t, server, "GET", "/dir/file", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "content", string(body))
+
+	resp = request(t, server, "PROPFIND", "/dir", "", "Depth", "1")
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(body), "/dir/file")
+
+	assert.Equal(t, http.StatusCreated,
+		request(t, server, "MOVE", "/dir/file", "", "Destination", server.URL+"/dir/moved").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request(t, server, "GET", "/dir/file", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, request(t, server, "DELETE", "/dir", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request(t, server, "GET", "/dir/moved", "").StatusCode)
+}
+
+func TestLockIsSharedByReplicas(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	first := httptest.NewServer(Handler(fs))
+	defer first.Close()
+	second := httptest.NewServer(Handler(fs))
+	defer second.Close()
+
+	lockBody := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:">` +
+		`<D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	resp := request(t, first, "LOCK", "/locked", lockBody, "Timeout", "Second-60")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	token := resp.Header.Get("Lock-Token")
+	require.NotEmpty(t, token)
+
+	assert.Equal(t, http.StatusLocked, request(t, second, "PUT", "/locked", "other").StatusCode,
+		"Other replica sees the lock")
+	assert.Equal(t, http.StatusCreated,
+		request(t, second, "PUT", "/locked", "owner", "If", "("+token+")").StatusCode,
+		"Lock owner writes through any replica")
+
+	assert.Equal(t, http.StatusNoContent, request(t, second, "UNLOCK", "/locked", "", "Lock-Token", token).StatusCode)
+	assert.Equal(t, http.StatusCreated, request(t, first, "PUT", "/locked", "other").StatusCode)
+}
+
+func TestLockSystem(t *testing.T) {
+	ls := NewLockSystem(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+	now := time.Now()
+
+	infinite, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Minute})
+	require.NoError(t, err)
+
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/a/b", Duration: -1, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err, "Infinite lock covers descendants")
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/", Duration: -1})
+	assert.Equal(t, webdav.ErrLocked, err, "Infinite lock of ancestor conflicts with descendant locks")
+	sibling, err := ls.Create(now, webdav.LockDetails{Root: "/ab", Duration: -1})
+	assert.NoError(t, err, "Name prefix is not a descendant")
+
+	release, err := ls.Confirm(now, "/a/b/c", "", webdav.Condition{Token: infinite})
+	require.NoError(t, err)
+	_, err = ls.Confirm(now, "/a", "", webdav.Condition{Token: infinite})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err, "Held lock can't be confirmed twice")
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(now, infinite), "Held lock can't be unlocked")
+	release()
+
+	_, err = ls.Confirm(now, "/ab", "", webdav.Condition{Token: infinite})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err, "Lock does not cover other names")
+
+	details, err := ls.Refresh(now, infinite, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "/a", details.Root)
+	assert.Equal(t, time.Hour, details.Duration)
+
+	_, err = ls.Refresh(now.Add(2*time.Hour), infinite, time.Hour)
+	assert.Equal(t, webdav.ErrNoSuchLock, err, "Expired lock is gone")
+	_, err = ls.Create(now.Add(2*time.Hour), webdav.LockDetails{Root: "/a/b", Duration: -1})
+	assert.NoError(t, err, "Expired lock does not conflict")
+
+	assert.NoError(t, ls.Unlock(now, sibling))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, sibling))
+}
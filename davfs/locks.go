@@ -0,0 +1,377 @@
+package davfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"golang.org/x/net/webdav"
+)
+
+// holdTimeout bounds how long a lock stays confirmed when the server holding it dies before releasing it
+const holdTimeout = 10 * time.Minute
+
+// LockSystem is webdav.LockSystem keeping locks in the store of a filesystem, so all replicas of a server see
+// the same locks. Semantics follow webdav.NewMemLS: a name has at most one lock, infinite depth locks conflict
+// with locks of descendants, a confirmed lock can't be confirmed again, refreshed or unlocked until released.
+//
+// Lock of token t is ("t", t) => (root, zero depth, expiry, duration, owner, held until), ("r", root) => t
+// indexes locks by name. Expired locks are ignored and cleared when met.
+type LockSystem struct {
+	store billyfs.KvStore
+	sp    subspace.Subspace
+}
+
+var _ webdav.LockSystem = &LockSystem{}
+
+// NewLockSystem creates lock system in "dav" subspace of fs
+func NewLockSystem(fs billyfs.FoundationDbFs) *LockSystem {
+	return &LockSystem{store: fs.Store(), sp: fs.Subspace().Sub("dav")}
+}
+
+type lock struct {
+	token   string
+	details webdav.LockDetails
+	// expiry of zero never expires
+	expiry    time.Time
+	heldUntil time.Time
+}
+
+func (l *lock) expired(now time.Time) bool {
+	return !l.expiry.IsZero() && !now.Before(l.expiry)
+}
+
+func (l *lock) held(now time.Time) bool {
+	return now.Before(l.heldUntil)
+}
+
+func (l *lock) pack() []byte {
+	return tuple.Tuple{
+		l.details.Root,
+		l.details.ZeroDepth,
+		unixNano(l.expiry),
+		int64(l.details.Duration),
+		l.details.OwnerXML,
+		unixNano(l.heldUntil),
+	}.Pack()
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func unpackLock(token string, value []byte) (*lock, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(t) != 6 {
+		return nil, fmt.Errorf("malformed_lock %v", t)
+	}
+	root, ok0 := t[0].(string)
+	zeroDepth, ok1 := t[1].(bool)
+	expiry, ok2 := t[2].(int64)
+	duration, ok3 := t[3].(int64)
+	owner, ok4 := t[4].(string)
+	heldUntil, ok5 := t[5].(int64)
+	if !ok0 || !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("malformed_lock %v", t)
+	}
+
+	return &lock{
+		token: token,
+		details: webdav.LockDetails{
+			Root:      root,
+			Duration:  time.Duration(duration),
+			OwnerXML:  owner,
+			ZeroDepth: zeroDepth,
+		},
+		expiry:    fromUnixNano(expiry),
+		heldUntil: fromUnixNano(heldUntil),
+	}, nil
+}
+
+func (ls *LockSystem) tokenKey(token string) fdb.Key {
+	return ls.sp.Pack(tuple.Tuple{"t", token})
+}
+
+func (ls *LockSystem) rootKey(root string) fdb.Key {
+	return ls.sp.Pack(tuple.Tuple{"r", root})
+}
+
+// get returns live lock of token or nil, expired lock is cleared
+func (ls *LockSystem) get(tx billyfs.KvTransaction, now time.Time, token string) (*lock, error) {
+	value, err := tx.Get(ls.tokenKey(token)).Get()
+	if err != nil || value == nil {
+		return nil, err
+	}
+	l, err := unpackLock(token, value)
+	if err != nil {
+		return nil, err
+	}
+	if l.expired(now) {
+		ls.clear(tx, l)
+		return nil, nil
+	}
+
+	return l, nil
+}
+
+// byRoot returns live lock of name or nil
+func (ls *LockSystem) byRoot(tx billyfs.KvTransaction, now time.Time, root string) (*lock, error) {
+	token, err := tx.Get(ls.rootKey(root)).Get()
+	if err != nil || token == nil {
+		return nil, err
+	}
+	return ls.get(tx, now, string(token))
+}
+
+func (ls *LockSystem) put(tx billyfs.KvTransaction, l *lock) {
+	tx.Set(ls.tokenKey(l.token), l.pack())
+	tx.Set(ls.rootKey(l.details.Root), []byte(l.token))
+}
+
+func (ls *LockSystem) clear(tx billyfs.KvTransaction, l *lock) {
+	tx.Clear(ls.tokenKey(l.token))
+	tx.Clear(ls.rootKey(l.details.Root))
+}
+
+// Confirm holds locks of conditions that cover name0 and name1
+func (ls *LockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	held, err := ls.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		var held []*lock
+		for _, name := range []string{name0, name1} {
+			if name == "" {
+				continue
+			}
+			l, err := ls.lookup(tx, now, clean(name), conditions)
+			if err != nil {
+				return nil, err
+			}
+			if l == nil {
+				return nil, webdav.ErrConfirmationFailed
+			}
+			if len(held) == 0 || held[0].token != l.token {
+				held = append(held, l)
+			}
+		}
+
+		for _, l := range held {
+			l.heldUntil = now.Add(holdTimeout)
+			ls.put(tx, l)
+		}
+		return held, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ls.release(held.([]*lock))
+	}, nil
+}
+
+// lookup returns lock of conditions that covers name and isn't held by anybody
+func (ls *LockSystem) lookup(tx billyfs.KvTransaction, now time.Time, name string, conditions []webdav.Condition) (*lock, error) {
+	for _, c := range conditions {
+		if c.Token == "" {
+			continue
+		}
+		l, err := ls.get(tx, now, c.Token)
+		if err != nil {
+			return nil, err
+		}
+		if l == nil || l.held(now) {
+			continue
+		}
+		if covers(l, name) {
+			return l, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func covers(l *lock, name string) bool {
+	root := l.details.Root
+	if name == root {
+		return true
+	}
+	if l.details.ZeroDepth {
+		return false
+	}
+	return root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+// release drops hold of locks, there is nobody to report failure to, so a lock that can't be released stays
+// held till holdTimeout
+func (ls *LockSystem) release(held []*lock) {
+	ls.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		for _, h := range held {
+			value, err := tx.Get(ls.tokenKey(h.token)).Get()
+			if err != nil || value == nil {
+				return nil, err
+			}
+			l, err := unpackLock(h.token, value)
+			if err != nil {
+				return nil, err
+			}
+			l.heldUntil = time.Time{}
+			ls.put(tx, l)
+		}
+		return nil, nil
+	})
+}
+
+// Create locks details.Root unless it conflicts with an existing lock
+func (ls *LockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = clean(details.Root)
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ls.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		free, err := ls.canCreate(tx, now, details.Root, details.ZeroDepth)
+		if err != nil {
+			return nil, err
+		}
+		if !free {
+			return nil, webdav.ErrLocked
+		}
+
+		l := &lock{token: token, details: details}
+		if details.Duration >= 0 {
+			l.expiry = now.Add(details.Duration)
+		}
+		ls.put(tx, l)
+		return nil, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// canCreate tells whether name is free of locks of itself and infinite locks of ancestors. Infinite lock also
+// needs descendants to be free.
+func (ls *LockSystem) canCreate(tx billyfs.KvTransaction, now time.Time, name string, zeroDepth bool) (bool, error) {
+	for p := name; ; p = path.Dir(p) {
+		l, err := ls.byRoot(tx, now, p)
+		if err != nil {
+			return false, err
+		}
+		if l != nil && (p == name || !l.details.ZeroDepth) {
+			return false, nil
+		}
+		if p == "/" {
+			break
+		}
+	}
+	if zeroDepth {
+		return true, nil
+	}
+
+	prefix := name + "/"
+	if name == "/" {
+		prefix = "/"
+	}
+	// packed string ends with a terminating zero, without it the key is a prefix of packed longer strings
+	begin := ls.rootKey(prefix)
+	begin = begin[:len(begin)-1]
+	kvs, err := tx.GetRange(fdb.KeyRange{Begin: begin, End: append(begin, 0xFF)}, fdb.RangeOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, kv := range kvs {
+		l, err := ls.get(tx, now, string(kv.Value))
+		if err != nil {
+			return false, err
+		}
+		if l != nil && l.details.Root != name {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Refresh extends lock of token by duration
+func (ls *LockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := ls.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		l, err := ls.get(tx, now, token)
+		if err != nil {
+			return nil, err
+		}
+		if l == nil {
+			return nil, webdav.ErrNoSuchLock
+		}
+		if l.held(now) {
+			return nil, webdav.ErrLocked
+		}
+
+		l.details.Duration = duration
+		l.expiry = time.Time{}
+		if duration >= 0 {
+			l.expiry = now.Add(duration)
+		}
+		ls.put(tx, l)
+		return l.details, nil
+	})
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+
+	return details.(webdav.LockDetails), nil
+}
+
+// Unlock drops lock of token
+func (ls *LockSystem) Unlock(now time.Time, token string) error {
+	_, err := ls.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		l, err := ls.get(tx, now, token)
+		if err != nil {
+			return nil, err
+		}
+		if l == nil {
+			return nil, webdav.ErrNoSuchLock
+		}
+		if l.held(now) {
+			return nil, webdav.ErrLocked
+		}
+
+		ls.clear(tx, l)
+		return nil, nil
+	})
+
+	return err
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "opaquelocktoken:" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,189 @@
+// Package fdbtest starts throwaway single process foundation db servers for tests.
+//
+// Server binaries are looked up in PATH, FDBSERVER and FDBCLI environment variables override their locations.
+// Tests are skipped when binaries can't be found.
+package fdbtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	serverEnv = "FDBSERVER"
+	cliEnv    = "FDBCLI"
+)
+
+// StartTimeout bounds time server has to become available
+var StartTimeout = 60 * time.Second
+
+// Server is a running fdbserver process with its own data directory and cluster file
+type Server struct {
+	// ClusterFile is the path of cluster file to open database with
+	ClusterFile string
+	// Address is the listen address of server
+	Address string
+
+	cli string
+	cmd *exec.Cmd
+}
+
+// Start launches fdbserver for a test, it is stopped and its files are removed when test finishes.
+// Test is skipped when fdbserver or fdbcli are not installed.
+func Start(t testing.TB) *Server {
+	t.Helper()
+
+	server, cli, err := binaries()
+	if err != nil {
+		t.Skipf("local fdbserver is not available, install foundationdb server or set %s and %s: %v",
+			serverEnv, cliEnv, err)
+	}
+
+	s, err := StartServer(server, cli, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed starting fdbserver: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.Stop(); err != nil {
+			t.Logf("Failed stopping fdbserver %s: %v", s.Address, err)
+		}
+	})
+
+	return s
+}
+
+// StartServer launches server binary in dir, configures new single memory database and waits until it is available
+func StartServer(server string, cli string, dir string) (*Server, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ClusterFile: filepath.Join(dir, "fdb.cluster"),
+		Address:     fmt.Sprintf("127.0.0.1:%d", port),
+		cli:         cli,
+	}
+
+	cluster := fmt.Sprintf("test:%08x@%s\n", rand.Uint32(), s.Address)
+	if err = ioutil.WriteFile(s.ClusterFile, []byte(cluster), 0644); err != nil {
+		return nil, err
+	}
+
+	data, logs := filepath.Join(dir, "data"), filepath.Join(dir, "logs")
+	for _, d := range []string{data, logs} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	s.cmd = exec.Command(server,
+		"--cluster_file", s.ClusterFile,
+		"--public_address", s.Address,
+		"--listen_address", s.Address,
+		"--datadir", data,
+		"--logdir", logs)
+	if err = s.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StartTimeout)
+	defer cancel()
+	if err = s.configure(ctx); err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// configure creates database and waits until it reports to be available
+func (s *Server) configure(ctx context.Context) error {
+	for {
+		out, err := s.exec(ctx, "configure new single memory")
+		if err == nil || strings.Contains(out, "Database already exists") {
+			break
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("fdbserver_not_configured %s: %v %s", s.Address, err, out)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for {
+		out, err := s.exec(ctx, "status minimal")
+		if err == nil && strings.Contains(out, "The database is available") {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("fdbserver_not_available %s: %v %s", s.Address, err, out)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *Server) exec(ctx context.Context, command string) (string, error) {
+	out, err := exec.CommandContext(ctx, s.cli,
+		"--cluster_file", s.ClusterFile,
+		"--timeout", "5",
+		"--exec", command).CombinedOutput()
+	return string(out), err
+}
+
+// Stop kills server process and waits for it to exit
+func (s *Server) Stop() error {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return nil
+	}
+	cmd := s.cmd
+	s.cmd = nil
+
+	if err := cmd.Process.Kill(); err != nil {
+		return err
+	}
+	// killed process always exits with an error
+	cmd.Wait()
+
+	return nil
+}
+
+// binaries finds fdbserver and fdbcli
+func binaries() (string, string, error) {
+	server, err := lookup(serverEnv, "fdbserver")
+	if err != nil {
+		return "", "", err
+	}
+	cli, err := lookup(cliEnv, "fdbcli")
+	if err != nil {
+		return "", "", err
+	}
+
+	return server, cli, nil
+}
+
+func lookup(env string, name string) (string, error) {
+	if path := os.Getenv(env); path != "" {
+		return exec.LookPath(path)
+	}
+	return exec.LookPath(name)
+}
+
+// freePort asks kernel for a port nobody listens on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,40 @@
+package fdbtest
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+
+	cluster, err := ioutil.ReadFile(s.ClusterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(cluster), s.Address) {
+		t.Errorf("Cluster file %q does not point to %s", cluster, s.Address)
+	}
+}
+
+func TestServersDoNotShareAddress(t *testing.T) {
+	t.Parallel()
+	first, second := Start(t), Start(t)
+
+	if first.Address == second.Address {
+		t.Errorf("Both servers listen on %s", first.Address)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	s := Start(t)
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Errorf("Second stop fails: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package billyfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Consumers read the change log at their own pace and acknowledge what they have processed. Acknowledged cursor
+// of a consumer is kept at ("f", name), the log is trimmed only up to the oldest of them.
+
+// Consumer is a named durable reader of the change log, e.g. a search indexer or a replica
+type Consumer struct {
+	fs   FoundationDbFs
+	name string
+}
+
+func (fs FoundationDbFs) consumers() subspace.Subspace {
+	return fs.root.Sub("f")
+}
+
+// Consumer registers consumer name unless it exists already. A new consumer starts at the oldest change still
+// kept, it can Ack LastCursor to skip them.
+func (fs FoundationDbFs) Consumer(name string) (*Consumer, error) {
+	_, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		key := fs.consumers().Pack(tuple.Tuple{name})
+		cursor, err := tx.Get(key).Get()
+		if err == nil && cursor == nil {
+			tx.Set(key, nil)
+		}
+		return nil, err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "consumer", Path: name, Err: err}
+	}
+	return &Consumer{fs: fs, name: name}, nil
+}
+
+// Consumers lists acknowledged cursors of all consumers by name
+func (fs FoundationDbFs) Consumers() (map[string]Cursor, error) {
+	cursors, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return fs.consumerCursors(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursors.(map[string]Cursor), nil
+}
+
+// Name returns name of the consumer
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Cursor returns the last acknowledged cursor, empty when nothing was acknowledged yet
+func (c *Consumer) Cursor() (Cursor, error) {
+	cursor, err := c.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return c.cursor(r)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "consumer", Path: c.name, Err: err}
+	}
+	return cursor.(Cursor), nil
+}
+
+// Read reads up to limit changes after the acknowledged cursor. Changes are read again until they are
+// acknowledged, so a consumer that crashed resumes without losing any.
+func (c *Consumer) Read(limit int) ([]Change, error) {
+	changes, err := c.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		cursor, err := c.cursor(r)
+		if err != nil {
+			return nil, err
+		}
+		return c.fs.changes(r, cursor, limit)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "consumer", Path: c.name, Err: err}
+	}
+	return changes.([]Change), nil
+}
+
+// Ack records that changes up to cursor are processed. Cursor never moves back, acknowledging an older cursor
+// does nothing.
+func (c *Consumer) Ack(cursor Cursor) error {
+	_, err := c.fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		current, err := c.cursor(tx)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Compare(cursor, current) > 0 {
+			tx.Set(c.fs.consumers().Pack(tuple.Tuple{c.name}), cursor)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return &os.PathError{Op: "consumer", Path: c.name, Err: err}
+	}
+	return nil
+}
+
+// Remove unregisters the consumer, changes it has not acknowledged no longer hold back trimming
+func (c *Consumer) Remove() error {
+	_, err := c.fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Clear(c.fs.consumers().Pack(tuple.Tuple{c.name}))
+		return nil, nil
+	})
+	if err != nil {
+		return &os.PathError{Op: "consumer", Path: c.name, Err: err}
+	}
+	return nil
+}
+
+func (c *Consumer) cursor(r KvReadTransaction) (Cursor, error) {
+	cursor, err := r.Get(c.fs.consumers().Pack(tuple.Tuple{c.name})).Get()
+	if err != nil {
+		return nil, err
+	}
+	if cursor == nil {
+		return nil, os.ErrNotExist
+	}
+	return Cursor(cursor), nil
+}
+
+// TrimChanges drops changes every consumer has acknowledged and returns cursor of the last dropped one. Without
+// consumers the whole log is dropped, live watchers still get changes made from now on.
+func (fs FoundationDbFs) TrimChanges() (Cursor, error) {
+	trimmed, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		cursors, err := fs.consumerCursors(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		var oldest Cursor
+		if len(cursors) == 0 {
+			last, err := tx.GetRange(fs.changeLog(), fdb.RangeOptions{Limit: 1, Reverse: true})
+			if err != nil || len(last) == 0 {
+				return Cursor{}, err
+			}
+			oldest = Cursor(last[0].Key[len(fs.changeLog().Bytes()):])
+		} else {
+			first := true
+			for _, cursor := range cursors {
+				if first || bytes.Compare(cursor, oldest) < 0 {
+					oldest, first = cursor, false
+				}
+			}
+		}
+		if len(oldest) == 0 {
+			return Cursor{}, nil
+		}
+
+		begin, _ := fs.changeLog().FDBRangeKeys()
+		end := fdb.Key(append(append(fs.changeLog().Bytes(), oldest...), 0x00))
+		tx.ClearRange(fdb.KeyRange{Begin: begin, End: end})
+		return oldest, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trimmed.(Cursor), nil
+}
+
+// ChangeTrimmer runs TrimChanges in the background
+type ChangeTrimmer struct {
+	*job
+}
+
+// StartChangeTrimmer trims changes acknowledged by every consumer each interval until the trimmer is closed.
+// Without consumers every round drops the whole log, so watchers lagging behind may miss changes.
+func (fs FoundationDbFs) StartChangeTrimmer(interval time.Duration) *ChangeTrimmer {
+	return &ChangeTrimmer{startJob(interval, func() error {
+		_, err := fs.TrimChanges()
+		return err
+	})}
+}
+
+func (fs FoundationDbFs) consumerCursors(r KvReadTransaction) (map[string]Cursor, error) {
+	kvs, err := r.GetRange(fs.consumers(), fdb.RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	cursors := make(map[string]Cursor, len(kvs))
+	for _, kv := range kvs {
+		key, err := fs.consumers().Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 1 {
+			return nil, fmt.Errorf("malformed_consumer %v", key)
+		}
+		name, ok := key[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("malformed_consumer %v", key)
+		}
+		cursors[name] = Cursor(kv.Value)
+	}
+	return cursors, nil
+}
@@ -0,0 +1,67 @@
+package billyfs
+
+import (
+	"os"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestConsumers() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("feed")
+
+	indexer, err := fs.Consumer("indexer")
+	s.Require().NoError(err)
+	replica, err := fs.Consumer("replica")
+	s.Require().NoError(err)
+
+	s.Require().NoError(util.WriteFile(fs, "/first", []byte("1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/second", []byte("2"), 0644))
+
+	changes, err := indexer.Read(2)
+	s.Require().NoError(err)
+	s.Require().Len(changes, 2)
+	s.Equal("/first", changes[0].Path)
+	again, err := indexer.Read(2)
+	s.Require().NoError(err)
+	s.Equal(changes, again, "Changes are read again until acknowledged")
+
+	s.Require().NoError(indexer.Ack(changes[1].Cursor))
+	s.Require().NoError(indexer.Ack(changes[0].Cursor), "Acknowledging older cursor does nothing")
+	rest, err := indexer.Read(0)
+	s.Require().NoError(err)
+	s.Require().Len(rest, 2)
+	s.Equal("/second", rest[0].Path)
+
+	reopened, err := fs.Consumer("indexer")
+	s.Require().NoError(err)
+	cursor, err := reopened.Cursor()
+	s.Require().NoError(err)
+	s.Equal(changes[1].Cursor, cursor, "Acknowledged cursor is durable")
+
+	trimmed, err := fs.TrimChanges()
+	s.Require().NoError(err)
+	s.Empty(trimmed, "Replica acknowledged nothing yet")
+
+	s.Require().NoError(replica.Ack(rest[0].Cursor))
+	trimmed, err = fs.TrimChanges()
+	s.Require().NoError(err)
+	s.Equal(changes[1].Cursor, trimmed, "Log is trimmed up to the oldest consumer")
+	all, err := fs.Changes(nil, 0)
+	s.Require().NoError(err)
+	s.Equal(rest, all)
+
+	s.Require().NoError(indexer.Remove())
+	_, err = indexer.Read(0)
+	s.True(os.IsNotExist(err), "Removed consumer can not read, got %v", err)
+	s.Require().NoError(replica.Remove())
+	_, err = fs.TrimChanges()
+	s.Require().NoError(err)
+	all, err = fs.Changes(nil, 0)
+	s.Require().NoError(err)
+	s.Empty(all, "Log without consumers is trimmed whole")
+
+	consumers, err := fs.Consumers()
+	s.Require().NoError(err)
+	s.Empty(consumers)
+}
@@ -0,0 +1,122 @@
+package gitstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/test"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "gopkg.in/check.v1"
+)
+
+// go-git shared storage suite runs over Storage, so it is checked against the same expectations as memory and
+// filesystem storages
+func TestConformance(t *testing.T) {
+	TestingT(t)
+}
+
+type ConformanceSuite struct {
+	test.BaseStorageSuite
+}
+
+var _ = Suite(&ConformanceSuite{})
+
+func (s *ConformanceSuite) SetUpTest(c *C) {
+	s.BaseStorageSuite = test.NewBaseStorageSuite(
+		NewStorage(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()), "repo"))
+}
+
+func TestWorktreeNextToObjects(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	worktree, err := fs.Chroot("/work")
+	require.NoError(t, err)
+
+	repo, err := git.Init(NewStorage(fs, "work"), worktree)
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(worktree, "hello.txt", []byte("hello"), 0644))
+
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add("hello.txt")
+	require.NoError(t, err)
+	commit, err := w.Commit("first", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	// opening again stands for another process sharing the database
+	reopened, err := git.Open(NewStorage(fs, "work"), worktree)
+	require.NoError(t, err)
+	head, err := reopened.Head()
+	require.NoError(t, err)
+	assert.Equal(t, commit, head.Hash())
+
+	w, err = reopened.Worktree()
+	require.NoError(t, err)
+	status, err := w.Status()
+	require.NoError(t, err)
+	assert.True(t, status.IsClean(), "Index is persisted, got %v", status)
+
+	_, err = fs.Stat("/work/.git")
+	assert.Error(t, err, "Repository keeps nothing in the worktree")
+}
+
+func TestCheckAndSetReference(t *testing.T) {
+	s := NewStorage(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()), "repo")
+	name := plumbing.ReferenceName("refs/heads/main")
+	first := plumbing.NewHashReference(name, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	second := plumbing.NewHashReference(name, plumbing.NewHash("2222222222222222222222222222222222222222"))
+	third := plumbing.NewHashReference(name, plumbing.NewHash("3333333333333333333333333333333333333333"))
+
+	require.NoError(t, s.SetReference(first))
+	require.NoError(t, s.CheckAndSetReference(second, first))
+	assert.Equal(t, storage.ErrReferenceHasChanged, s.CheckAndSetReference(third, first),
+		"Update based on a stale reference is refused")
+
+	ref, err := s.Reference(name)
+	require.NoError(t, err)
+	assert.Equal(t, second.Hash(), ref.Hash())
+}
+
+func TestLargeObject(t *testing.T) {
+	s := NewStorage(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()), "repo")
+
+	content := make([]byte, 2*batchChunks*chunkSize+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	h, err := s.SetEncodedObject(obj)
+	require.NoError(t, err)
+	size, err := s.EncodedObjectSize(h)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	read, err := s.EncodedObject(plumbing.BlobObject, h)
+	require.NoError(t, err)
+	r, err := read.Reader()
+	require.NoError(t, err)
+	defer r.Close()
+	readContent, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, readContent), "Object content differs")
+
+	_, err = s.EncodedObject(plumbing.TreeObject, h)
+	assert.Equal(t, plumbing.ErrObjectNotFound, err)
+}
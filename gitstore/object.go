@@ -0,0 +1,201 @@
+package gitstore
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// batchChunks bounds object chunks written in one transaction, keeping it well below fdb 10MB limit
+const batchChunks = 64
+
+// ErrUnsupportedObjectType is returned for objects other than commits, trees, blobs and tags
+var ErrUnsupportedObjectType = fmt.Errorf("unsupported object type")
+
+func (s *Storage) header(h plumbing.Hash) fdb.Key {
+	return s.sp.Pack(tuple.Tuple{"o", h[:]})
+}
+
+func (s *Storage) content(h plumbing.Hash) subspace.Subspace {
+	return s.sp.Sub("d", h[:])
+}
+
+func (s *Storage) byType(t plumbing.ObjectType) subspace.Subspace {
+	return s.sp.Sub("t", int64(t))
+}
+
+// NewEncodedObject returns an in-memory object to be filled and stored with SetEncodedObject
+func (s *Storage) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+// SetEncodedObject stores object. Content of large objects takes several transactions, the header written by the
+// last one makes the object visible, so readers never see it partially stored.
+func (s *Storage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	switch obj.Type() {
+	case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+	default:
+		return plumbing.ZeroHash, ErrUnsupportedObjectType
+	}
+
+	h := obj.Hash()
+	if err := s.HasEncodedObject(h); err == nil {
+		return h, nil
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return h, err
+	}
+	defer r.Close()
+
+	buf := make([]byte, batchChunks*chunkSize)
+	for n := int64(0); ; n += batchChunks {
+		read, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return h, err
+		}
+
+		_, err = s.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+			if n == 0 {
+				tx.ClearRange(s.content(h))
+			}
+			if read > 0 || n == 0 {
+				setChunks(tx, s.content(h), n, buf[:read])
+			}
+			if last {
+				tx.Set(s.header(h), tuple.Tuple{int64(obj.Type()), obj.Size()}.Pack())
+				tx.Set(s.byType(obj.Type()).Pack(tuple.Tuple{h[:]}), nil)
+			}
+			return nil, nil
+		})
+		if err != nil || last {
+			return h, err
+		}
+	}
+}
+
+// EncodedObject reads object of type t, any type when t is plumbing.AnyObject
+func (s *Storage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		typ, size, err := s.readHeader(r, h)
+		if err != nil {
+			return nil, err
+		}
+		if t != plumbing.AnyObject && typ != t {
+			return nil, plumbing.ErrObjectNotFound
+		}
+
+		content, err := getChunks(r, s.content(h))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(content)) != size {
+			return nil, fmt.Errorf("object_size_mismatch %v %v != %v", h, len(content), size)
+		}
+
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(typ)
+		if _, err = obj.Write(content); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.(plumbing.EncodedObject), nil
+}
+
+// IterEncodedObjects iterates over objects of type t, objects are read lazily
+func (s *Storage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	types := []plumbing.ObjectType{t}
+	if t == plumbing.AnyObject {
+		types = []plumbing.ObjectType{plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject}
+	}
+
+	hashes, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		var hashes []plumbing.Hash
+		for _, typ := range types {
+			kvs, err := r.GetRange(s.byType(typ), fdb.RangeOptions{})
+			if err != nil {
+				return nil, err
+			}
+			for _, kv := range kvs {
+				key, err := s.byType(typ).Unpack(kv.Key)
+				if err != nil {
+					return nil, err
+				}
+				hash, ok := key[0].([]byte)
+				if !ok || len(hash) != len(plumbing.ZeroHash) {
+					return nil, fmt.Errorf("malformed_object_key %v", key)
+				}
+				var h plumbing.Hash
+				copy(h[:], hash)
+				hashes = append(hashes, h)
+			}
+		}
+		return hashes, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewEncodedObjectLookupIter(s, t, hashes.([]plumbing.Hash)), nil
+}
+
+// HasEncodedObject returns plumbing.ErrObjectNotFound when object is not stored
+func (s *Storage) HasEncodedObject(h plumbing.Hash) error {
+	_, err := s.EncodedObjectSize(h)
+	return err
+}
+
+// EncodedObjectSize returns size of object content
+func (s *Storage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		_, size, err := s.readHeader(r, h)
+		return size, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size.(int64), nil
+}
+
+// AddAlternate is not supported, all objects are in the store
+func (s *Storage) AddAlternate(string) error {
+	return fmt.Errorf("alternates_not_supported")
+}
+
+func (s *Storage) readHeader(r billyfs.KvReadTransaction, h plumbing.Hash) (plumbing.ObjectType, int64, error) {
+	value, err := r.Get(s.header(h)).Get()
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+	if value == nil {
+		return plumbing.InvalidObject, 0, plumbing.ErrObjectNotFound
+	}
+
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+	if len(t) != 2 {
+		return plumbing.InvalidObject, 0, fmt.Errorf("malformed_object_header %v", t)
+	}
+	typ, ok := t[0].(int64)
+	size, ok2 := t[1].(int64)
+	if !ok || !ok2 {
+		return plumbing.InvalidObject, 0, fmt.Errorf("malformed_object_header %v", t)
+	}
+
+	return plumbing.ObjectType(typ), size, nil
+}
@@ -0,0 +1,121 @@
+package gitstore
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+func (s *Storage) reference(name plumbing.ReferenceName) fdb.Key {
+	return s.sp.Pack(tuple.Tuple{"r", string(name)})
+}
+
+// SetReference stores ref unconditionally
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	return s.CheckAndSetReference(ref, nil)
+}
+
+// CheckAndSetReference stores ref when the stored one still points where old does. Check and update happen in
+// one transaction, so concurrent updates of the same reference never both succeed.
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	_, err := s.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		if old != nil {
+			current, err := s.readReference(tx, ref.Name())
+			if err != nil && err != plumbing.ErrReferenceNotFound {
+				return nil, err
+			}
+			if current != nil && current.Hash() != old.Hash() {
+				return nil, storage.ErrReferenceHasChanged
+			}
+		}
+
+		tx.Set(s.reference(ref.Name()), []byte(ref.Strings()[1]))
+		return nil, nil
+	})
+	return err
+}
+
+// Reference reads reference, plumbing.ErrReferenceNotFound when there is none
+func (s *Storage) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		return s.readReference(r, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ref.(*plumbing.Reference), nil
+}
+
+// IterReferences iterates over all references in name order
+func (s *Storage) IterReferences() (storer.ReferenceIter, error) {
+	refs, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		kvs, err := r.GetRange(s.sp.Sub("r"), fdb.RangeOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		refs := make([]*plumbing.Reference, len(kvs))
+		for i, kv := range kvs {
+			key, err := s.sp.Sub("r").Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("malformed_reference_key %v", key)
+			}
+			refs[i] = plumbing.NewReferenceFromStrings(name, string(kv.Value))
+		}
+		return refs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewReferenceSliceIter(refs.([]*plumbing.Reference)), nil
+}
+
+// RemoveReference removes reference, removing a missing one is not an error
+func (s *Storage) RemoveReference(name plumbing.ReferenceName) error {
+	_, err := s.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		tx.Clear(s.reference(name))
+		return nil, nil
+	})
+	return err
+}
+
+// CountLooseRefs counts all references, none of them is ever packed
+func (s *Storage) CountLooseRefs() (int, error) {
+	refs, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		return r.GetRange(s.sp.Sub("r"), fdb.RangeOptions{})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(refs.([]fdb.KeyValue)), nil
+}
+
+// PackRefs does nothing, references are single keys already
+func (s *Storage) PackRefs() error {
+	return nil
+}
+
+func (s *Storage) readReference(r billyfs.KvReadTransaction, name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	value, err := r.Get(s.reference(name)).Get()
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+	return plumbing.NewReferenceFromStrings(string(name), string(value)), nil
+}
@@ -0,0 +1,172 @@
+// Package gitstore implements go-git storage.Storer on fdb keys directly, next to FoundationDbFs in the same
+// database and root. Objects are kept whole in chunked values instead of loose files and packfiles, references
+// are single keys updated transactionally.
+package gitstore
+
+import (
+	"bytes"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/storage"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// chunkSize is the size of a value holding a piece of an object, index or shallow list, fdb values are limited
+// to 100KB
+const chunkSize = 64 << 10
+
+// Repository keys live in ("git", name) subspace of filesystem root:
+//
+//	("o", hash) object header, tuple of type and size
+//	("d", hash, n) n-th chunk of object content
+//	("t", type, hash) type index of objects
+//	("r", name) reference target
+//	("c", n), ("i", n), ("h", n) chunks of config, index and shallow commits
+//	("m", name) storage of submodule
+type Storage struct {
+	store billyfs.KvStore
+	sp    subspace.Subspace
+}
+
+var _ storage.Storer = &Storage{}
+
+// NewStorage creates storage of repository name in the same store and root as fs
+func NewStorage(fs billyfs.FoundationDbFs, name string) *Storage {
+	return &Storage{store: fs.Store(), sp: fs.Subspace().Sub("git", name)}
+}
+
+// Module returns storage of submodule, it is empty when the submodule is new
+func (s *Storage) Module(name string) (storage.Storer, error) {
+	return &Storage{store: s.store, sp: s.sp.Sub("m", name)}, nil
+}
+
+// Config returns repository config, default one when none was set
+func (s *Storage) Config() (*config.Config, error) {
+	b, err := s.get(s.sp.Sub("c"))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.NewConfig()
+	if b == nil {
+		return cfg, nil
+	}
+	return cfg, cfg.Unmarshal(b)
+}
+
+// SetConfig validates and stores config
+func (s *Storage) SetConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	b, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+	return s.set(s.sp.Sub("c"), b)
+}
+
+// Index returns staging index, an empty one when none was set
+func (s *Storage) Index() (*index.Index, error) {
+	b, err := s.get(s.sp.Sub("i"))
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index.Index{Version: 2}
+	if b == nil {
+		return idx, nil
+	}
+	return idx, index.NewDecoder(bytes.NewReader(b)).Decode(idx)
+}
+
+// SetIndex stores staging index
+func (s *Storage) SetIndex(idx *index.Index) error {
+	var b bytes.Buffer
+	if err := index.NewEncoder(&b).Encode(idx); err != nil {
+		return err
+	}
+	return s.set(s.sp.Sub("i"), b.Bytes())
+}
+
+// Shallow returns shallow commits
+func (s *Storage) Shallow() ([]plumbing.Hash, error) {
+	b, err := s.get(s.sp.Sub("h"))
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []plumbing.Hash
+	for ; len(b) >= len(plumbing.ZeroHash); b = b[len(plumbing.ZeroHash):] {
+		var h plumbing.Hash
+		copy(h[:], b)
+		commits = append(commits, h)
+	}
+	return commits, nil
+}
+
+// SetShallow replaces shallow commits
+func (s *Storage) SetShallow(commits []plumbing.Hash) error {
+	b := make([]byte, 0, len(commits)*len(plumbing.ZeroHash))
+	for _, h := range commits {
+		b = append(b, h[:]...)
+	}
+	return s.set(s.sp.Sub("h"), b)
+}
+
+// get reads value chunked by set, nil when there is none
+func (s *Storage) get(sp subspace.Subspace) ([]byte, error) {
+	b, err := s.store.ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		return getChunks(r, sp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.([]byte), nil
+}
+
+// set replaces value of sp in a single transaction, so it has to fit one
+func (s *Storage) set(sp subspace.Subspace, b []byte) error {
+	_, err := s.store.Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		tx.ClearRange(sp)
+		setChunks(tx, sp, 0, b)
+		return nil, nil
+	})
+	return err
+}
+
+// getChunks concatenates chunks of sp, nil when there are none
+func getChunks(r billyfs.KvReadTransaction, sp subspace.Subspace) ([]byte, error) {
+	kvs, err := r.GetRange(sp, fdb.RangeOptions{})
+	if err != nil || len(kvs) == 0 {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(kvs)*chunkSize)
+	for _, kv := range kvs {
+		b = append(b, kv.Value...)
+	}
+	return b, nil
+}
+
+// setChunks writes b as chunks of sp numbered from first on, empty b still gets a chunk to mark it is set
+func setChunks(tx billyfs.KvTransaction, sp subspace.Subspace, first int64, b []byte) {
+	n := first
+	for {
+		end := chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		tx.Set(sp.Pack(tuple.Tuple{n}), b[:end])
+		n++
+		if b = b[end:]; len(b) == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package grpcfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+	"google.golang.org/grpc"
+)
+
+// Client is billy.Filesystem backed by the filesystem service
+type Client struct {
+	rpc fspb.FileSystemClient
+	ctx context.Context
+}
+
+var _ billy.Filesystem = Client{}
+
+// NewClient creates filesystem calling the service over conn
+func NewClient(conn grpc.ClientConnInterface) Client {
+	return Client{rpc: fspb.NewFileSystemClient(conn), ctx: context.Background()}
+}
+
+// WithContext returns a view of filesystem whose calls are bound to ctx. Files opened through the view stay
+// bound to ctx.
+func (c Client) WithContext(ctx context.Context) Client {
+	c.ctx = ctx
+	return c
+}
+
+// Create creates or truncates a file
+func (c Client) Create(filename string) (billy.File, error) {
+	return c.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Open opens a file read-only
+func (c Client) Open(filename string) (billy.File, error) {
+	return c.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file according to os.OpenFile flags
+func (c Client) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	resp, err := c.rpc.Open(c.ctx, &fspb.OpenRequest{Path: filename, Flag: int64(flag), Perm: uint32(perm)})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return &file{c: c, handle: resp.Handle, name: resp.Name, flag: flag}, nil
+}
+
+// Stat returns file info of filename following symlinks
+func (c Client) Stat(filename string) (os.FileInfo, error) {
+	return c.stat(&fspb.StatRequest{Path: filename})
+}
+
+// Lstat returns file info of filename, symlink in the last element is not followed
+func (c Client) Lstat(filename string) (os.FileInfo, error) {
+	return c.stat(&fspb.StatRequest{Path: filename, Lstat: true})
+}
+
+func (c Client) stat(req *fspb.StatRequest) (os.FileInfo, error) {
+	info, err := c.rpc.Stat(c.ctx, req)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return fromProto(info), nil
+}
+
+// Rename moves oldpath to newpath
+func (c Client) Rename(oldpath, newpath string) error {
+	_, err := c.rpc.Rename(c.ctx, &fspb.RenameRequest{From: oldpath, To: newpath})
+	return fromStatus(err)
+}
+
+// Remove removes filename
+func (c Client) Remove(filename string) error {
+	_, err := c.rpc.Remove(c.ctx, &fspb.RemoveRequest{Path: filename})
+	return fromStatus(err)
+}
+
+// Join joins path elements
+func (Client) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// TempFile creates a new file with random name in dir, dir is created when missing
+func (c Client) TempFile(dir, prefix string) (billy.File, error) {
+	for try := 0; ; try++ {
+		name := c.Join(dir, prefix+nextRandom())
+		f, err := c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) && try < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// ReadDir lists directory
+func (c Client) ReadDir(dirname string) ([]os.FileInfo, error) {
+	resp, err := c.rpc.ReadDir(c.ctx, &fspb.ReadDirRequest{Path: dirname})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	infos := make([]os.FileInfo, len(resp.Entries))
+	for i := range resp.Entries {
+		infos[i] = fromProto(resp.Entries[i])
+	}
+	return infos, nil
+}
+
+// MkdirAll creates directory along with missing parents
+func (c Client) MkdirAll(filename string, perm os.FileMode) error {
+	_, err := c.rpc.Mkdir(c.ctx, &fspb.MkdirRequest{Path: filename, Perm: uint32(perm)})
+	return fromStatus(err)
+}
+
+// Symlink creates link pointing to target
+func (c Client) Symlink(target, link string) error {
+	_, err := c.rpc.Symlink(c.ctx, &fspb.SymlinkRequest{Target: target, Link: link})
+	return fromStatus(err)
+}
+
+// Readlink returns target of link
+func (c Client) Readlink(link string) (string, error) {
+	resp, err := c.rpc.Readlink(c.ctx, &fspb.ReadlinkRequest{Link: link})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	return resp.Target, nil
+}
+
+// Chroot returns filesystem rooted at path
+func (c Client) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(c, c.Join(c.Root(), path)), nil
+}
+
+// Root is always the root of a remote tree
+func (Client) Root() string {
+	return "/"
+}
+
+// Capabilities are the same as of FoundationDbFs
+func (Client) Capabilities() billy.Capability {
+	return billy.WriteCapability | billy.ReadCapability | billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability
+}
+
+var tempRand uint32
+var tempRandMu sync.Mutex
+
+// nextRandom is the same pseudo-random sequence ioutil.TempFile uses
+func nextRandom() string {
+	tempRandMu.Lock()
+	r := tempRand
+	if r == 0 {
+		r = uint32(time.Now().UnixNano() + int64(os.Getpid()))
+	}
+	r = r*1664525 + 1013904223
+	tempRand = r
+	tempRandMu.Unlock()
+	return strconv.Itoa(int(1e9 + r%1e9))[1:]
+}
@@ -0,0 +1,100 @@
+package grpcfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errno maps filesystem errors to Linux errnos, 0 when there is none
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case os.IsNotExist(err):
+		return syscall.ENOENT
+	case os.IsExist(err):
+		return syscall.EEXIST
+	case os.IsPermission(err):
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// code picks status code of errno, callers of other languages see it without decoding details
+func code(e syscall.Errno) codes.Code {
+	switch e {
+	case syscall.ENOENT, syscall.ESTALE:
+		return codes.NotFound
+	case syscall.EEXIST:
+		return codes.AlreadyExists
+	case syscall.EPERM, syscall.EACCES:
+		return codes.PermissionDenied
+	case syscall.EINVAL, syscall.EBADF:
+		return codes.InvalidArgument
+	case syscall.ENOTDIR, syscall.EISDIR, syscall.ENOTEMPTY, syscall.ELOOP:
+		return codes.FailedPrecondition
+	}
+	return codes.Unknown
+}
+
+// toStatus turns filesystem error into a status carrying Error detail
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	detail := &fspb.Error{Errno: uint32(errno(err)), Message: err.Error()}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		detail.Op, detail.Path = pathErr.Op, pathErr.Path
+		detail.Message = pathErr.Err.Error()
+	}
+
+	st, dErr := status.New(code(syscall.Errno(detail.Errno)), err.Error()).WithDetails(detail)
+	if dErr != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return st.Err()
+}
+
+// fromStatus restores filesystem error out of status, so that os.IsNotExist and friends work on the client
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+
+	switch st.Code() {
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+
+	for _, d := range st.Details() {
+		detail, ok := d.(*fspb.Error)
+		if !ok {
+			continue
+		}
+		var e error = syscall.Errno(detail.Errno)
+		if detail.Errno == 0 {
+			e = errors.New(detail.Message)
+		}
+		if detail.Op == "" {
+			return e
+		}
+		return &os.PathError{Op: detail.Op, Path: detail.Path, Err: e}
+	}
+
+	return err
+}
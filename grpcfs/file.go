@@ -0,0 +1,178 @@
+package grpcfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+)
+
+// file is an open remote file. It refers to the node by handle, so it keeps working after renames.
+type file struct {
+	c      Client
+	handle []byte
+	name   string
+	flag   int
+	pos    int64
+	closed bool
+}
+
+var _ billy.File = &file{}
+
+// Name returns the name file was opened by
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes in a single streaming call
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", os.O_WRONLY); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	stream, err := f.c.rpc.Read(f.c.ctx, &fspb.ReadRequest{Handle: f.handle, Offset: off, Length: int64(len(p))})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+
+	n := 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fromStatus(err)
+		}
+		n += copy(p[n:], resp.Data)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes p in chunks of a single streaming call
+func (f *file) Write(p []byte) (int, error) {
+	if err := f.check("write", os.O_RDONLY); err != nil {
+		return 0, err
+	}
+
+	stream, err := f.c.rpc.Write(f.c.ctx)
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+
+	appending := f.flag&os.O_APPEND != 0
+	for off := 0; off < len(p) || off == 0; off += chunkSize {
+		end := off + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		req := &fspb.WriteRequest{Handle: f.handle, Offset: f.pos + int64(off), Data: p[off:end], Append: appending}
+		if err = stream.Send(req); err != nil {
+			// the actual error is reported by CloseAndRecv
+			break
+		}
+		if end == len(p) {
+			break
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	f.pos = resp.Offset
+	if int(resp.Written) < len(p) {
+		return int(resp.Written), io.ErrShortWrite
+	}
+	return int(resp.Written), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	pos := f.pos
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	case io.SeekEnd:
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		pos = info.Size() + offset
+	default:
+		return 0, fmt.Errorf("unknown_whence %v", whence)
+	}
+
+	if pos < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.pos = pos
+	return f.pos, nil
+}
+
+// Stat returns file info of the node even when it was renamed since opening
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.c.stat(&fspb.StatRequest{Handle: f.handle})
+}
+
+func (f *file) Truncate(size int64) error {
+	if err := f.check("truncate", os.O_RDONLY); err != nil {
+		return err
+	}
+	_, err := f.c.rpc.Truncate(f.c.ctx, &fspb.TruncateRequest{Handle: f.handle, Size: size})
+	return fromStatus(err)
+}
+
+// Close only invalidates file, server keeps no state of it
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// Lock is not supported
+func (*file) Lock() error {
+	return nil
+}
+
+// Unlock is not supported
+func (*file) Unlock() error {
+	return nil
+}
+
+// check fails closed file or file opened with access mode that does not allow op
+func (f *file) check(op string, denied int) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) == denied {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+// Package fspb holds protobuf messages and gRPC stubs of the filesystem service.
+package fspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fs.proto
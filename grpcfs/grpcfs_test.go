@@ -0,0 +1,147 @@
+package grpcfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/test"
+	"github.com/go-git/go-billy/v5/util"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	. "gopkg.in/check.v1"
+)
+
+// serve serves fs over in-memory connection and returns client of it along with a function shutting both down
+func serve(fs billyfs.FoundationDbFs) (Client, func(), error) {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	fspb.RegisterFileSystemServer(server, NewServer(fs))
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		server.Stop()
+		return Client{}, nil, err
+	}
+
+	return NewClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}, nil
+}
+
+func dial(t *testing.T, fs billyfs.FoundationDbFs) Client {
+	c, stop, err := serve(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	return c
+}
+
+// go-billy shared suites run over the client, so remote filesystem behaves the same as local one
+func TestConformance(t *testing.T) {
+	TestingT(t)
+}
+
+type ConformanceSuite struct {
+	test.FilesystemSuite
+	stop func()
+}
+
+var _ = Suite(&ConformanceSuite{})
+
+func (s *ConformanceSuite) SetUpTest(c *C) {
+	client, stop, err := serve(billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+	c.Assert(err, IsNil)
+	s.FilesystemSuite, s.stop = test.NewFilesystemSuite(client), stop
+}
+
+func (s *ConformanceSuite) TearDownTest(c *C) {
+	s.stop()
+}
+
+func TestStreamingReadWrite(t *testing.T) {
+	c := dial(t, billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+
+	content := make([]byte, 3*chunkSize+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	require.NoError(t, util.WriteFile(c, "/dir/big", content, 0644))
+
+	f, err := c.Open("/dir/big")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, read), "Read content differs")
+
+	part := make([]byte, 10)
+	n, err := f.ReadAt(part, chunkSize-5)
+	require.NoError(t, err)
+	assert.Equal(t, content[chunkSize-5:chunkSize+5], part[:n])
+	require.NoError(t, f.Close())
+
+	info, err := c.Stat("/dir/big")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	assert.NotZero(t, info.Sys().(*billyfs.NodeStat).Node)
+}
+
+func TestFileFollowsRename(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	c := dial(t, fs)
+
+	f, err := c.OpenFile("/file", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("first"))
+	require.NoError(t, err)
+
+	require.NoError(t, fs.Rename("/file", "/moved"))
+	_, err = f.Write([]byte(" second"))
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(8))
+
+	read, err := util.ReadFile(fs, "/moved")
+	require.NoError(t, err)
+	assert.Equal(t, "first se", string(read))
+}
+
+func TestErrors(t *testing.T) {
+	c := dial(t, billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore()))
+
+	_, err := c.Stat("/missing")
+	assert.True(t, os.IsNotExist(err), "Missing file is reported as such, got %v", err)
+	var pathErr *os.PathError
+	require.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "/missing", pathErr.Path)
+
+	require.NoError(t, util.WriteFile(c, "/file", nil, 0644))
+	_, err = c.OpenFile("/file", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	assert.True(t, os.IsExist(err), "Existing file is reported as such, got %v", err)
+	_, err = c.ReadDir("/file")
+	assert.True(t, errors.Is(err, syscall.ENOTDIR), "File is not a directory, got %v", err)
+	require.NoError(t, util.WriteFile(c, "/dir/file", nil, 0644))
+	err = c.Remove("/dir")
+	assert.True(t, errors.Is(err, syscall.ENOTEMPTY), "Directory with content is not removed, got %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.WithContext(ctx).Stat("/file")
+	assert.True(t, errors.Is(err, context.Canceled), "Canceled call fails with context error, got %v", err)
+}
@@ -0,0 +1,232 @@
+// Package grpcfs serves FoundationDbFs over gRPC and provides a billy.Filesystem client of it, so that remote and
+// local filesystems are interchangeable. The service is defined in fspb/fs.proto for clients of other languages.
+package grpcfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/iggyzap/foundationdb-billyfs/grpcfs/fspb"
+)
+
+// chunkSize bounds data of a single read or write message, well below the default 4MiB message limit
+const chunkSize = 64 << 10
+
+// Server implements the filesystem service. Every call is bound to its context.
+type Server struct {
+	fspb.UnimplementedFileSystemServer
+	fs billyfs.FoundationDbFs
+}
+
+var _ fspb.FileSystemServer = &Server{}
+
+// NewServer creates service over fs, register it with fspb.RegisterFileSystemServer
+func NewServer(fs billyfs.FoundationDbFs) *Server {
+	return &Server{fs: fs}
+}
+
+func (s *Server) Stat(ctx context.Context, req *fspb.StatRequest) (*fspb.FileInfo, error) {
+	fs := s.fs.WithContext(ctx)
+
+	var info os.FileInfo
+	var err error
+	switch {
+	case req.Handle != nil:
+		info, err = fs.StatHandle(req.Handle)
+	case req.Lstat:
+		info, err = fs.Lstat(req.Path)
+	default:
+		info, err = fs.Stat(req.Path)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(info), nil
+}
+
+func (s *Server) ReadDir(ctx context.Context, req *fspb.ReadDirRequest) (*fspb.ReadDirResponse, error) {
+	infos, err := s.fs.WithContext(ctx).ReadDir(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &fspb.ReadDirResponse{Entries: make([]*fspb.FileInfo, len(infos))}
+	for i := range infos {
+		resp.Entries[i] = toProto(infos[i])
+	}
+	return resp, nil
+}
+
+func (s *Server) Mkdir(ctx context.Context, req *fspb.MkdirRequest) (*fspb.Empty, error) {
+	return &fspb.Empty{}, toStatus(s.fs.WithContext(ctx).MkdirAll(req.Path, os.FileMode(req.Perm)))
+}
+
+func (s *Server) Remove(ctx context.Context, req *fspb.RemoveRequest) (*fspb.Empty, error) {
+	return &fspb.Empty{}, toStatus(s.fs.WithContext(ctx).Remove(req.Path))
+}
+
+func (s *Server) Rename(ctx context.Context, req *fspb.RenameRequest) (*fspb.Empty, error) {
+	return &fspb.Empty{}, toStatus(s.fs.WithContext(ctx).Rename(req.From, req.To))
+}
+
+func (s *Server) Symlink(ctx context.Context, req *fspb.SymlinkRequest) (*fspb.Empty, error) {
+	return &fspb.Empty{}, toStatus(s.fs.WithContext(ctx).Symlink(req.Target, req.Link))
+}
+
+func (s *Server) Readlink(ctx context.Context, req *fspb.ReadlinkRequest) (*fspb.ReadlinkResponse, error) {
+	target, err := s.fs.WithContext(ctx).Readlink(req.Link)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &fspb.ReadlinkResponse{Target: target}, nil
+}
+
+// Open creates or truncates file as flag tells, the file itself is not kept open
+func (s *Server) Open(ctx context.Context, req *fspb.OpenRequest) (*fspb.OpenResponse, error) {
+	f, err := s.fs.WithContext(ctx).OpenFile(req.Path, int(req.Flag), os.FileMode(req.Perm))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer f.Close()
+
+	file := f.(*billyfs.FoundationDbFile)
+	info, err := file.Stat()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &fspb.OpenResponse{Handle: file.Handle(), Info: toProto(info), Name: file.Name()}, nil
+}
+
+func (s *Server) Read(req *fspb.ReadRequest, stream fspb.FileSystem_ReadServer) error {
+	f, err := s.fs.WithContext(stream.Context()).OpenHandle(req.Handle, os.O_RDONLY)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer f.Close()
+
+	off, left := req.Offset, req.Length
+	for req.Length <= 0 || left > 0 {
+		buf := make([]byte, chunkSize)
+		if req.Length > 0 && left < chunkSize {
+			buf = buf[:left]
+		}
+
+		n, err := f.ReadAt(buf, off)
+		if n > 0 {
+			if sErr := stream.Send(&fspb.ReadResponse{Data: buf[:n]}); sErr != nil {
+				return sErr
+			}
+			off += int64(n)
+			left -= int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) Write(stream fspb.FileSystem_WriteServer) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&fspb.WriteResponse{})
+	}
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_WRONLY
+	if req.Append {
+		flag |= os.O_APPEND
+	}
+	f, err := s.fs.WithContext(stream.Context()).OpenHandle(req.Handle, flag)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer f.Close()
+
+	resp := &fspb.WriteResponse{}
+	for {
+		var n int
+		if req.Append {
+			n, err = f.Write(req.Data)
+			if pos, sErr := f.Seek(0, io.SeekCurrent); sErr == nil {
+				resp.Offset = pos
+			}
+		} else {
+			n, err = f.WriteAt(req.Data, req.Offset)
+			resp.Offset = req.Offset + int64(n)
+		}
+		resp.Written += int64(n)
+		if err != nil {
+			return toStatus(err)
+		}
+
+		if req, err = stream.Recv(); err == io.EOF {
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) Truncate(ctx context.Context, req *fspb.TruncateRequest) (*fspb.Empty, error) {
+	f, err := s.fs.WithContext(ctx).OpenHandle(req.Handle, os.O_WRONLY)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer f.Close()
+
+	return &fspb.Empty{}, toStatus(f.Truncate(req.Size))
+}
+
+func toProto(info os.FileInfo) *fspb.FileInfo {
+	pb := &fspb.FileInfo{
+		Name:  info.Name(),
+		Size:  info.Size(),
+		Mode:  uint32(info.Mode()),
+		Mtime: info.ModTime().UnixNano(),
+	}
+	if stat, ok := info.Sys().(*billyfs.NodeStat); ok {
+		pb.Node, pb.Uid, pb.Gid, pb.Version = stat.Node, int64(stat.Uid), int64(stat.Gid), stat.Version
+	}
+	return pb
+}
+
+// fileInfo is FileInfo received from server, Sys is *billyfs.NodeStat same as for local nodes
+type fileInfo struct {
+	pb *fspb.FileInfo
+}
+
+func fromProto(pb *fspb.FileInfo) os.FileInfo {
+	return fileInfo{pb}
+}
+
+func (f fileInfo) Name() string {
+	return f.pb.Name
+}
+func (f fileInfo) Size() int64 {
+	return f.pb.Size
+}
+func (f fileInfo) Mode() os.FileMode {
+	return os.FileMode(f.pb.Mode)
+}
+func (f fileInfo) ModTime() time.Time {
+	return time.Unix(0, f.pb.Mtime)
+}
+func (f fileInfo) IsDir() bool {
+	return f.Mode().IsDir()
+}
+func (f fileInfo) Sys() interface{} {
+	return &billyfs.NodeStat{Node: f.pb.Node, Uid: int(f.pb.Uid), Gid: int(f.pb.Gid), Version: f.pb.Version}
+}
@@ -0,0 +1,160 @@
+package billyfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"syscall"
+)
+
+// Handle is a compact opaque reference to a node. It stays valid across renames and server restarts. Node ids are
+// random and a removed node's id may be given again, so handle carries the generation of its node as well and
+// goes stale instead of reaching the new node.
+type Handle []byte
+
+// handleSize is the size of a handle, it is a big-endian node id and big-endian generation. Handles issued before
+// generations are only the node id, they reach nodes of generation zero.
+const (
+	handleSize       = 16
+	legacyHandleSize = 8
+)
+
+// ErrStaleHandle is returned for handles of removed nodes
+var ErrStaleHandle error = syscall.ESTALE
+
+func handleOf(id int64, generation uint64) Handle {
+	h := make(Handle, handleSize)
+	binary.BigEndian.PutUint64(h, uint64(id))
+	binary.BigEndian.PutUint64(h[8:], generation)
+	return h
+}
+
+func (h Handle) node() (int64, uint64, error) {
+	switch len(h) {
+	case handleSize:
+		return int64(binary.BigEndian.Uint64(h)), binary.BigEndian.Uint64(h[8:]), nil
+	case legacyHandleSize:
+		return int64(binary.BigEndian.Uint64(h)), 0, nil
+	}
+	return noNode, 0, syscall.EBADF
+}
+
+func (h Handle) String() string {
+	return hex.EncodeToString(h)
+}
+
+// Handle returns handle of path, the last symlink is not followed
+func (fs FoundationDbFs) Handle(path string) (Handle, error) {
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat, ok := info.Sys().(*NodeStat); ok {
+		return handleOf(stat.Node, stat.Generation), nil
+	}
+
+	return handleOf(rootNode, 0), nil
+}
+
+// Handle returns handle of an opened file
+func (f *FoundationDbFile) Handle() Handle {
+	return f.handle
+}
+
+// HandlePath returns current path of node, ErrStaleHandle when it has been removed
+func (fs FoundationDbFs) HandlePath(h Handle) (string, error) {
+	p, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		_, fsPath, err := fs.handleNode(r, h)
+		return fsPath, err
+	})
+	if err != nil {
+		return "", &os.PathError{Op: "handle", Path: h.String(), Err: err}
+	}
+
+	return joinPath(p.([]string)), nil
+}
+
+// StatHandle returns file info of node, it is named by the current name of node
+func (fs FoundationDbFs) StatHandle(h Handle) (os.FileInfo, error) {
+	info, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		id, fsPath, err := fs.handleNode(r, h)
+		if err != nil {
+			return nil, err
+		}
+
+		name := "/"
+		if len(fsPath) > 0 {
+			name = fsPath[len(fsPath)-1]
+		}
+		return fs.stat(r, id, name)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: h.String(), Err: err}
+	}
+
+	return info.(os.FileInfo), nil
+}
+
+// OpenHandle opens node of handle without resolving its path. O_CREATE and O_EXCL make no sense for existing
+// nodes and are rejected, directories can only be opened read-only.
+func (fs FoundationDbFs) OpenHandle(h Handle, flag int) (*FoundationDbFile, error) {
+	if flag&(os.O_CREATE|os.O_EXCL) != 0 {
+		return nil, &os.PathError{Op: "open", Path: h.String(), Err: syscall.EINVAL}
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	var fsPath []string
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		id, p, err := fs.handleNode(tx, h)
+		if err != nil {
+			return nil, err
+		}
+		fsPath = p
+
+		mode, err := fs.mode(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case mode.IsDir() && writable:
+			return nil, syscall.EISDIR
+		case flag&os.O_TRUNC != 0 && writable:
+			if err = fs.truncate(tx, id, 0); err != nil {
+				return nil, err
+			}
+			if err = fs.modified(tx, id); err != nil {
+				return nil, err
+			}
+		}
+
+		return id, nil
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: h.String(), Err: err}
+	}
+
+	return fs.file(h, fsPath, flag), nil
+}
+
+// handleNode checks node of handle still exists and returns its path
+func (fs FoundationDbFs) handleNode(r KvReadTransaction, h Handle) (int64, []string, error) {
+	id, generation, err := h.node()
+	if err != nil {
+		return noNode, nil, err
+	}
+
+	fsPath, err := fs.nodePath(r, id)
+	if os.IsNotExist(err) {
+		return noNode, nil, ErrStaleHandle
+	}
+	if err != nil {
+		return noNode, nil, err
+	}
+
+	// node of the same id allocated after the node of handle was removed
+	current, err := fs.generation(r, id)
+	if err == nil && current != generation {
+		err = ErrStaleHandle
+	}
+	return id, fsPath, err
+}
@@ -0,0 +1,594 @@
+package billyfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-billy/v5"
+)
+
+// With file versions enabled, content of a file is kept as a copy in the node before it is dropped: by truncation,
+// e.g. opening it with O_TRUNC to overwrite it, by the first write of an open file overwriting content, or by a
+// rename replacing the file. Copy is numbered by the content version it had, see NodeStat.Version. Keys:
+//
+//	("n", id, 0xFB, 0x00, version) tuple of size, modification time, time the copy was kept and for copies held
+//	    by another node that node and the version they are kept under there
+//	("n", id, 0xFB, 0x01, version, bucket) data buckets of the copy
+//	("n", id, 0xFB, 0x02, version) whether a copy ahead by stageVersion is complete
+//	("h", kept, id, version) index of copies by the time they were kept, for pruning by retention
+//
+// Content larger than a write batch does not fit the transaction dropping it, it is copied ahead in transactions
+// of its own and the dropping transaction runs again, see staging. A file replaced by rename passes its content
+// and versions to the file replacing it, their data stays in the replaced node that is left holding only that.
+// Copies live and die with their node, so removing a file drops its versions as well.
+
+// versioning configures file versions, see WithFileVersions
+type versioning struct {
+	enabled bool
+	// keep bounds versions of a file, zero is unbounded
+	keep int
+	// retention bounds age of versions, zero is unbounded
+	retention time.Duration
+}
+
+// FileVersion is a kept content of a file
+type FileVersion struct {
+	// Version is the content version of the file the content had
+	Version uint64
+	Size    int64
+	ModTime time.Time
+	// Kept is when the content was replaced
+	Kept time.Time
+	// holder is the node data of a version inherited from a replaced file is kept in, under version held
+	holder int64
+	held   uint64
+}
+
+// unstagedVersion reports content of a file too large to be kept as a version by the transaction dropping it
+type unstagedVersion struct {
+	id      int64
+	version uint64
+}
+
+func (e *unstagedVersion) Error() string {
+	return fmt.Sprintf("unstaged_version %v %v", e.id, e.version)
+}
+
+func (fs FoundationDbFs) fileVersions(id int64) subspace.Subspace {
+	return fs.node(id).Sub(0xFB, 0x00)
+}
+
+func (fs FoundationDbFs) fileVersionData(id int64, version uint64) subspace.Subspace {
+	return fs.node(id).Sub(0xFB, 0x01, int64(version))
+}
+
+func (fs FoundationDbFs) stagedVersions(id int64) subspace.Subspace {
+	return fs.node(id).Sub(0xFB, 0x02)
+}
+
+// versionData is the subspace data buckets of version v of node id are in
+func (fs FoundationDbFs) versionData(id int64, v FileVersion) subspace.Subspace {
+	if v.holder != 0 {
+		return fs.fileVersionData(v.holder, v.held)
+	}
+	return fs.fileVersionData(id, v.Version)
+}
+
+func (fs FoundationDbFs) versionIndex() subspace.Subspace {
+	return fs.root.Sub("h")
+}
+
+// FileVersions lists kept versions of a file from the oldest one
+func (fs FoundationDbFs) FileVersions(path string) ([]FileVersion, error) {
+	versions, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		id, err := fs.versionedFile(r, path)
+		if err != nil {
+			return nil, err
+		}
+		return fs.listVersions(r, id)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "versions", Path: path, Err: err}
+	}
+	return versions.([]FileVersion), nil
+}
+
+// OpenFileVersion opens version of a file read-only
+func (fs FoundationDbFs) OpenFileVersion(path string, version uint64) (billy.File, error) {
+	content, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		id, err := fs.versionedFile(r, path)
+		if err != nil {
+			return nil, err
+		}
+		return fs.versionContent(r, id, version)
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &versionFile{Reader: bytes.NewReader(content.([]byte)), name: path}, nil
+}
+
+// RestoreFileVersion makes version the current content of a file, current content is kept as a version first.
+// Restore takes a single transaction, versions larger than a write batch fail with syscall.EFBIG, they can be
+// copied from OpenFileVersion instead.
+func (fs FoundationDbFs) RestoreFileVersion(path string, version uint64) error {
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		id, err := fs.versionedFile(tx, path)
+		if err != nil {
+			return nil, err
+		}
+		v, err := fs.fileVersion(tx, id, version)
+		if err != nil {
+			return nil, err
+		}
+		data := fs.versionData(id, v)
+		buckets, err := tx.GetRange(data, fdb.RangeOptions{Limit: fs.batch() + 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(buckets) > fs.batch() {
+			return nil, syscall.EFBIG
+		}
+
+		if err = fs.truncate(tx, id, 0); err != nil {
+			return nil, err
+		}
+		for _, kv := range buckets {
+			bucket, err := data.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			tx.Set(fs.data(id).Pack(bucket), kv.Value)
+		}
+		return nil, fs.modified(tx, id)
+	})
+	if err != nil {
+		return &os.PathError{Op: "restore", Path: path, Err: err}
+	}
+	return nil
+}
+
+// versionedFile resolves path of a regular file
+func (fs FoundationDbFs) versionedFile(r KvReadTransaction, path string) (int64, error) {
+	res, err := fs.resolve(r, fs.split(path), true)
+	switch {
+	case err != nil:
+		return noNode, err
+	case res.id == noNode:
+		return noNode, os.ErrNotExist
+	case res.mode.IsDir():
+		return noNode, syscall.EISDIR
+	}
+	return res.id, nil
+}
+
+func (fs FoundationDbFs) listVersions(r KvReadTransaction, id int64) ([]FileVersion, error) {
+	kvs, err := r.GetRange(fs.fileVersions(id), fdb.RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]FileVersion, len(kvs))
+	for i, kv := range kvs {
+		key, err := fs.fileVersions(id).Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 1 {
+			return nil, fmt.Errorf("malformed_file_version %v", key)
+		}
+		version, ok := key[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("malformed_file_version %v", key)
+		}
+		if versions[i], err = unpackFileVersion(uint64(version), kv.Value); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func (fs FoundationDbFs) fileVersion(r KvReadTransaction, id int64, version uint64) (FileVersion, error) {
+	value, err := r.Get(fs.fileVersions(id).Pack(tuple.Tuple{int64(version)})).Get()
+	if err != nil {
+		return FileVersion{}, err
+	}
+	if value == nil {
+		return FileVersion{}, os.ErrNotExist
+	}
+	return unpackFileVersion(version, value)
+}
+
+// versionContent reads version of a file into memory
+func (fs FoundationDbFs) versionContent(r KvReadTransaction, id int64, version uint64) ([]byte, error) {
+	info, err := fs.fileVersion(r, id, version)
+	if err != nil {
+		return nil, err
+	}
+	data := fs.versionData(id, info)
+	buckets, err := r.GetRange(data, fdb.RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, info.Size)
+	for _, kv := range buckets {
+		key, err := data.Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 1 {
+			return nil, fmt.Errorf("malformed_bucket %v", key)
+		}
+		bucket, ok := key[0].(int64)
+		if !ok || bucket < 0 || bucket*rEADSIZE > info.Size {
+			return nil, fmt.Errorf("malformed_bucket %v", key)
+		}
+		copy(content[bucket*rEADSIZE:], kv.Value)
+	}
+	return content, nil
+}
+
+// keepVersion keeps current content of a file as a version before content past size is dropped or overwritten.
+// It keeps nothing when there is no content past size or versions are disabled. Content larger than a write
+// batch has to be copied ahead by stageVersion, it fails with *unstagedVersion until it is. It reports whether
+// a version was kept.
+func (fs FoundationDbFs) keepVersion(w KvTransaction, id int64, size int64) (bool, error) {
+	if !fs.versioning.enabled {
+		return false, nil
+	}
+	current, err := fs.size(w, id)
+	if err != nil || current <= size {
+		return false, err
+	}
+	meta, err := fs.stat(w, id, "")
+	if err != nil {
+		return false, err
+	}
+	version := meta.Sys().(*NodeStat).Version
+
+	buckets, err := w.GetRange(fs.data(id), fdb.RangeOptions{Limit: fs.batch() + 1})
+	if err != nil {
+		return false, err
+	}
+	if len(buckets) > fs.batch() {
+		staged := fs.stagedVersions(id).Pack(tuple.Tuple{int64(version)})
+		value, err := w.Get(staged).Get()
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(value, stagedComplete) {
+			return false, &unstagedVersion{id: id, version: version}
+		}
+		w.Clear(staged)
+	} else {
+		for _, kv := range buckets {
+			bucket, err := fs.bucket(id, kv.Key)
+			if err != nil {
+				return false, err
+			}
+			w.Set(fs.fileVersionData(id, version).Pack(tuple.Tuple{bucket}), kv.Value)
+		}
+	}
+
+	err = fs.addVersions(w, id, FileVersion{Version: version, Size: current, ModTime: meta.ModTime(), Kept: time.Now()})
+	return err == nil, err
+}
+
+// addVersions records versions of a file and drops the oldest ones past the bound of WithFileVersions
+func (fs FoundationDbFs) addVersions(w KvTransaction, id int64, versions ...FileVersion) error {
+	for _, v := range versions {
+		w.Set(fs.fileVersions(id).Pack(tuple.Tuple{int64(v.Version)}), packFileVersion(v))
+		w.Set(fs.versionIndex().Pack(tuple.Tuple{v.Kept.UnixNano(), id, int64(v.Version)}), []byte{})
+	}
+
+	if fs.versioning.keep <= 0 {
+		return nil
+	}
+	all, err := fs.listVersions(w, id)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(all)-fs.versioning.keep; i++ {
+		fs.dropVersion(w, id, all[i])
+	}
+	return nil
+}
+
+func (fs FoundationDbFs) dropVersion(w KvTransaction, id int64, v FileVersion) {
+	w.ClearRange(fs.versionData(id, v))
+	w.Clear(fs.fileVersions(id).Pack(tuple.Tuple{int64(v.Version)}))
+	w.Clear(fs.versionIndex().Pack(tuple.Tuple{v.Kept.UnixNano(), id, int64(v.Version)}))
+}
+
+// dropVersions drops all versions of a node being removed, data of inherited ones is held by other nodes
+func (fs FoundationDbFs) dropVersions(w KvTransaction, id int64) error {
+	versions, err := fs.listVersions(w, id)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		fs.dropVersion(w, id, v)
+	}
+	return nil
+}
+
+// inheritVersions passes content and versions of file replaced by rename to file id replacing it. They are
+// numbered after the content version of id, which moves past them. Replaced node is left holding only their data.
+func (fs FoundationDbFs) inheritVersions(w KvTransaction, id int64, replaced int64) error {
+	if _, err := fs.keepVersion(w, replaced, 0); err != nil {
+		return err
+	}
+	versions, err := fs.listVersions(w, replaced)
+	if err != nil {
+		return err
+	}
+	meta, err := fs.stat(w, id, "")
+	if err != nil {
+		return err
+	}
+
+	next := meta.Sys().(*NodeStat).Version
+	inherited := make([]FileVersion, len(versions))
+	for i, v := range versions {
+		w.Clear(fs.fileVersions(replaced).Pack(tuple.Tuple{int64(v.Version)}))
+		w.Clear(fs.versionIndex().Pack(tuple.Tuple{v.Kept.UnixNano(), replaced, int64(v.Version)}))
+		if v.holder == 0 {
+			v.holder, v.held = replaced, v.Version
+		}
+		next++
+		v.Version = next
+		inherited[i] = v
+	}
+	version := make([]byte, 8)
+	binary.LittleEndian.PutUint64(version, next+1)
+	w.Max(fs.node(id).Pack(versionKey), version)
+
+	if err = fs.clearRanges(w, replaced); err != nil {
+		return err
+	}
+	begin, end := fs.node(replaced).FDBRangeKeys()
+	heldBegin, heldEnd := fs.node(replaced).Sub(0xFB, 0x01).FDBRangeKeys()
+	w.ClearRange(fdb.KeyRange{Begin: begin, End: heldBegin})
+	w.ClearRange(fdb.KeyRange{Begin: heldEnd, End: end})
+	return fs.addVersions(w, id, inherited...)
+}
+
+// stagedComplete marks a copy ahead that is complete
+var stagedComplete = []byte{1}
+
+// staging runs transaction run again after copying ahead content it failed to keep as a version
+func (fs FoundationDbFs) staging(run func() (interface{}, error)) (interface{}, error) {
+	for {
+		ret, err := run()
+		var unstaged *unstagedVersion
+		if !errors.As(err, &unstaged) {
+			return ret, err
+		}
+		if err = fs.stageVersion(unstaged.id, unstaged.version); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// stageVersion copies current content of a file as data of version, a write batch per transaction. Copy is
+// abandoned once content changes, a transaction dropping the new content asks for a copy of it. Copies of other
+// versions are left by abandoned copies, they are dropped.
+func (fs FoundationDbFs) stageVersion(id int64, version uint64) error {
+	for {
+		done, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			return fs.cow(tx, func(tx KvTransaction) (interface{}, error) {
+				if err := fs.lockable(tx, id); err != nil {
+					return true, nil
+				}
+				meta, err := fs.stat(tx, id, "")
+				if err != nil || meta.Sys().(*NodeStat).Version != version {
+					return true, err
+				}
+
+				staged, err := tx.GetRange(fs.stagedVersions(id), fdb.RangeOptions{})
+				if err != nil {
+					return false, err
+				}
+				for _, kv := range staged {
+					key, err := fs.stagedVersions(id).Unpack(kv.Key)
+					if err != nil {
+						return false, err
+					}
+					if len(key) != 1 {
+						return false, fmt.Errorf("malformed_staged_version %v", key)
+					}
+					other, ok := key[0].(int64)
+					if !ok {
+						return false, fmt.Errorf("malformed_staged_version %v", key)
+					}
+					if uint64(other) != version {
+						tx.ClearRange(fs.fileVersionData(id, uint64(other)))
+						tx.Clear(kv.Key)
+					}
+				}
+
+				// copy resumes after the last bucket copied
+				begin, end := fs.data(id).FDBRangeKeys()
+				last, err := tx.GetRange(fs.fileVersionData(id, version), fdb.RangeOptions{Limit: 1, Reverse: true})
+				if err != nil {
+					return false, err
+				}
+				if len(last) > 0 {
+					bucket, err := fs.fileVersionData(id, version).Unpack(last[0].Key)
+					if err != nil {
+						return false, err
+					}
+					begin = append(fs.data(id).Pack(bucket), 0x00)
+				}
+				buckets, err := tx.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: fs.batch()})
+				if err != nil {
+					return false, err
+				}
+				for _, kv := range buckets {
+					bucket, err := fs.bucket(id, kv.Key)
+					if err != nil {
+						return false, err
+					}
+					tx.Set(fs.fileVersionData(id, version).Pack(tuple.Tuple{bucket}), kv.Value)
+				}
+
+				complete := len(buckets) < fs.batch()
+				value := []byte{0}
+				if complete {
+					value = stagedComplete
+				}
+				tx.Set(fs.stagedVersions(id).Pack(tuple.Tuple{int64(version)}), value)
+				return complete, nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if done.(bool) {
+			return nil
+		}
+	}
+}
+
+func packFileVersion(v FileVersion) []byte {
+	t := tuple.Tuple{v.Size, v.ModTime.UnixNano(), v.Kept.UnixNano()}
+	if v.holder != 0 {
+		t = append(t, v.holder, int64(v.held))
+	}
+	return t.Pack()
+}
+
+func unpackFileVersion(version uint64, value []byte) (FileVersion, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	if len(t) != 3 && len(t) != 5 {
+		return FileVersion{}, fmt.Errorf("malformed_file_version %v", t)
+	}
+	size, ok := t[0].(int64)
+	mtime, ok2 := t[1].(int64)
+	kept, ok3 := t[2].(int64)
+	if !ok || !ok2 || !ok3 {
+		return FileVersion{}, fmt.Errorf("malformed_file_version %v", t)
+	}
+	v := FileVersion{Version: version, Size: size, ModTime: time.Unix(0, mtime), Kept: time.Unix(0, kept)}
+	if len(t) == 5 {
+		holder, ok := t[3].(int64)
+		held, ok2 := t[4].(int64)
+		if !ok || !ok2 {
+			return FileVersion{}, fmt.Errorf("malformed_file_version %v", t)
+		}
+		v.holder, v.held = holder, uint64(held)
+	}
+	return v, nil
+}
+
+// PruneFileVersions drops versions kept longer than the retention of WithFileVersions and returns how many were
+// dropped
+func (fs FoundationDbFs) PruneFileVersions() (int, error) {
+	if !fs.versioning.enabled || fs.versioning.retention <= 0 {
+		return 0, nil
+	}
+
+	pruned := 0
+	expired := time.Now().Add(-fs.versioning.retention).UnixNano()
+	for {
+		n, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+			begin, _ := fs.versionIndex().FDBRangeKeys()
+			end := fs.versionIndex().Pack(tuple.Tuple{expired})
+			kvs, err := tx.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: gcBatch})
+			if err != nil {
+				return 0, err
+			}
+
+			for _, kv := range kvs {
+				key, err := fs.versionIndex().Unpack(kv.Key)
+				if err != nil {
+					return 0, err
+				}
+				if len(key) != 3 {
+					return 0, fmt.Errorf("malformed_version_index %v", key)
+				}
+				kept, ok := key[0].(int64)
+				id, ok2 := key[1].(int64)
+				version, ok3 := key[2].(int64)
+				if !ok || !ok2 || !ok3 {
+					return 0, fmt.Errorf("malformed_version_index %v", key)
+				}
+				v, err := fs.fileVersion(tx, id, uint64(version))
+				if os.IsNotExist(err) {
+					tx.Clear(kv.Key)
+					continue
+				}
+				if err != nil {
+					return 0, err
+				}
+				v.Kept = time.Unix(0, kept)
+				fs.dropVersion(tx, id, v)
+			}
+			return len(kvs), nil
+		})
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n.(int)
+		if n.(int) < gcBatch {
+			return pruned, nil
+		}
+	}
+}
+
+// FileVersionPruner runs PruneFileVersions in the background
+type FileVersionPruner struct {
+	*job
+}
+
+// StartFileVersionPruner prunes expired file versions every interval until the pruner is closed
+func (fs FoundationDbFs) StartFileVersionPruner(interval time.Duration) *FileVersionPruner {
+	return &FileVersionPruner{startJob(interval, func() error {
+		_, err := fs.PruneFileVersions()
+		return err
+	})}
+}
+
+// versionFile is a read-only file over content of a version
+type versionFile struct {
+	*bytes.Reader
+	name string
+}
+
+var _ billy.File = &versionFile{}
+
+func (f *versionFile) Name() string {
+	return f.name
+}
+
+func (f *versionFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *versionFile) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *versionFile) Close() error {
+	return nil
+}
+
+func (f *versionFile) Lock() error {
+	return nil
+}
+
+func (f *versionFile) Unlock() error {
+	return nil
+}
@@ -0,0 +1,201 @@
+package billyfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestFileVersions() {
+	fs := s.subFs("history", WithFileVersions(2, 0))
+
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		s.Require().NoError(util.WriteFile(fs, "/config", []byte(content), 0644))
+	}
+
+	versions, err := fs.FileVersions("/config")
+	s.Require().NoError(err)
+	s.Require().Len(versions, 2, "Only the latest versions are kept")
+	s.EqualValues(2, versions[0].Size)
+	s.Less(versions[0].Version, versions[1].Version)
+	s.Equal([]string{"v2", "v3"}, s.versionContents(fs, "/config", versions))
+
+	f, err := fs.OpenFileVersion("/config", versions[0].Version)
+	s.Require().NoError(err)
+	_, err = f.Write([]byte("x"))
+	s.Error(err, "Versions are read-only")
+	s.Require().NoError(f.Close())
+
+	s.Require().NoError(fs.RestoreFileVersion("/config", versions[0].Version))
+	content, err := util.ReadFile(fs, "/config")
+	s.Require().NoError(err)
+	s.Equal("v2", string(content))
+	versions, err = fs.FileVersions("/config")
+	s.Require().NoError(err)
+	s.Equal([]string{"v3", "v4"}, s.versionContents(fs, "/config", versions), "Restored content is kept as well")
+
+	f, err = fs.OpenFile("/config", os.O_RDWR, 0)
+	s.Require().NoError(err)
+	s.Require().NoError(f.Truncate(10))
+	s.Require().NoError(f.Truncate(1))
+	s.Require().NoError(f.Close())
+	versions, err = fs.FileVersions("/config")
+	s.Require().NoError(err)
+	s.Require().Len(versions, 2)
+	s.EqualValues(10, versions[1].Size, "Only truncation dropping content keeps a version")
+
+	_, err = fs.OpenFileVersion("/config", 1000)
+	s.True(os.IsNotExist(err))
+	_, err = fs.FileVersions("/missing")
+	s.True(os.IsNotExist(err))
+
+	unversioned := s.subFs("history")
+	s.Require().NoError(util.WriteFile(unversioned, "/plain", []byte("1"), 0644))
+	s.Require().NoError(util.WriteFile(unversioned, "/plain", []byte("2"), 0644))
+	versions, err = unversioned.FileVersions("/plain")
+	s.Require().NoError(err)
+	s.Empty(versions)
+}
+
+func (s *FsTestSuite) TestPruneFileVersions() {
+	fs := s.subFs("pruned", WithFileVersions(0, time.Hour))
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		s.Require().NoError(util.WriteFile(fs, "/f", []byte(content), 0644))
+	}
+	pruned, err := fs.PruneFileVersions()
+	s.Require().NoError(err)
+	s.Zero(pruned, "Versions are retained")
+
+	fs = s.subFs("pruned", WithFileVersions(0, time.Nanosecond))
+	pruner := fs.StartFileVersionPruner(10 * time.Millisecond)
+	defer pruner.Close()
+	s.Eventually(func() bool {
+		versions, err := fs.FileVersions("/f")
+		return err == nil && len(versions) == 0
+	}, 5*time.Second, 10*time.Millisecond, "Pruner drops expired versions")
+	s.NoError(pruner.Err())
+	s.Zero(s.keys(fs.versionIndex()))
+}
+
+func (s *FsTestSuite) TestVersionsOfOverwrites() {
+	fs := s.subFs("overwrites", WithFileVersions(0, 0))
+	s.Require().NoError(util.WriteFile(fs, "/log", []byte("abcdef"), 0644))
+
+	file, err := fs.OpenFile("/log", os.O_RDWR, 0)
+	s.Require().NoError(err)
+	f := file.(*FoundationDbFile)
+	_, err = f.WriteAt([]byte("XY"), 6)
+	s.Require().NoError(err)
+	versions, err := fs.FileVersions("/log")
+	s.Require().NoError(err)
+	s.Empty(versions, "Appending overwrites nothing")
+
+	_, err = f.WriteAt([]byte("12"), 2)
+	s.Require().NoError(err)
+	_, err = f.WriteAt([]byte("34"), 0)
+	s.Require().NoError(err)
+	s.Require().NoError(f.Close())
+	versions, err = fs.FileVersions("/log")
+	s.Require().NoError(err)
+	s.Equal([]string{"abcdefXY"}, s.versionContents(fs, "/log", versions), "Open file keeps a version once")
+
+	content, err := util.ReadFile(fs, "/log")
+	s.Require().NoError(err)
+	s.Equal("3412efXY", string(content))
+}
+
+func (s *FsTestSuite) TestVersionsOfReplacedFile() {
+	fs := s.subFs("replaced", WithFileVersions(3, 0))
+	s.Require().NoError(util.WriteFile(fs, "/app", []byte("v1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/app", []byte("v2"), 0644))
+	info, err := fs.Stat("/app")
+	s.Require().NoError(err)
+	replaced := info.Sys().(*NodeStat).Node
+
+	s.Require().NoError(util.WriteFile(fs, "/app.new", []byte("v3"), 0644))
+	s.Require().NoError(fs.Rename("/app.new", "/app"))
+	versions, err := fs.FileVersions("/app")
+	s.Require().NoError(err)
+	s.Equal([]string{"v1", "v2"}, s.versionContents(fs, "/app", versions), "Replaced file and its versions are kept")
+
+	info, err = fs.Stat("/app")
+	s.Require().NoError(err)
+	s.Greater(info.Sys().(*NodeStat).Version, versions[1].Version, "Content version moves past inherited versions")
+
+	s.Require().NoError(util.WriteFile(fs, "/app", []byte("v4"), 0644))
+	versions, err = fs.FileVersions("/app")
+	s.Require().NoError(err)
+	s.Equal([]string{"v1", "v2", "v3"}, s.versionContents(fs, "/app", versions))
+
+	s.Require().NoError(util.WriteFile(fs, "/app.new", []byte("v5"), 0644))
+	s.Require().NoError(fs.Rename("/app.new", "/app"))
+	versions, err = fs.FileVersions("/app")
+	s.Require().NoError(err)
+	s.Equal([]string{"v2", "v3", "v4"}, s.versionContents(fs, "/app", versions), "Inherited versions are bounded")
+
+	s.Require().NoError(fs.RestoreFileVersion("/app", versions[0].Version))
+	content, err := util.ReadFile(fs, "/app")
+	s.Require().NoError(err)
+	s.Equal("v2", string(content))
+
+	s.Require().NoError(fs.Remove("/app"))
+	s.Zero(s.keys(fs.node(replaced)), "Removing the file drops data of inherited versions")
+	s.Zero(s.keys(fs.versionIndex()))
+
+	s.Require().NoError(util.WriteFile(fs, "/dir/app", []byte("v1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/dir/app.new", []byte("v2"), 0644))
+	info, err = fs.Stat("/dir/app")
+	s.Require().NoError(err)
+	replaced = info.Sys().(*NodeStat).Node
+	s.Require().NoError(fs.Rename("/dir/app.new", "/dir/app"))
+	s.Require().NoError(fs.RemoveAll("/dir"))
+	s.Zero(s.keys(fs.node(replaced)), "Removing the tree drops data of inherited versions")
+	s.Zero(s.keys(fs.versionIndex()))
+}
+
+func (s *FsTestSuite) TestVersionsOfLargeFiles() {
+	fs := s.subFs("large", WithFileVersions(0, 0), WithWriteBatch(2*int(rEADSIZE)))
+	large := make([]byte, 5*rEADSIZE+1)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	s.Require().NoError(util.WriteFile(fs, "/blob", large, 0644))
+
+	file, err := fs.OpenFile("/blob", os.O_RDWR, 0)
+	s.Require().NoError(err)
+	_, err = file.(*FoundationDbFile).WriteAt([]byte("x"), rEADSIZE)
+	s.Require().NoError(err)
+	s.Require().NoError(file.Close())
+	s.Require().NoError(util.WriteFile(fs, "/blob", []byte("small"), 0644))
+
+	versions, err := fs.FileVersions("/blob")
+	s.Require().NoError(err)
+	s.Require().Len(versions, 2, "Files larger than a write batch are versioned")
+	contents := s.versionContents(fs, "/blob", versions)
+	s.Equal(string(large), contents[0])
+	large[rEADSIZE] = 'x'
+	s.Equal(string(large), contents[1])
+	info, err := fs.Stat("/blob")
+	s.Require().NoError(err)
+	s.Zero(s.keys(fs.stagedVersions(info.Sys().(*NodeStat).Node)), "Staged copies are done with once kept")
+
+	err = fs.RestoreFileVersion("/blob", versions[0].Version)
+	s.True(errors.Is(err, syscall.EFBIG), "Restore larger than a write batch fails, got %v", err)
+}
+
+func (s *FsTestSuite) versionContents(fs FoundationDbFs, path string, versions []FileVersion) []string {
+	contents := make([]string, len(versions))
+	for i, v := range versions {
+		f, err := fs.OpenFileVersion(path, v.Version)
+		s.Require().NoError(err)
+		content, err := ioutil.ReadAll(f)
+		s.Require().NoError(err)
+		contents[i] = string(content)
+	}
+	return contents
+}
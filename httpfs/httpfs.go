@@ -0,0 +1,92 @@
+// Package httpfs serves FoundationDbFs over plain HTTP.
+package httpfs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// FileSystem adapts FoundationDbFs to http.FileSystem, so that http.FileServer can serve it
+type FileSystem struct {
+	fs billyfs.FoundationDbFs
+}
+
+var _ http.FileSystem = FileSystem{}
+
+// New creates http filesystem over fs
+func New(fs billyfs.FoundationDbFs) FileSystem {
+	return FileSystem{fs}
+}
+
+// Open opens name read-only
+func (h FileSystem) Open(name string) (http.File, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.(*billyfs.FoundationDbFile), nil
+}
+
+// Handler serves files of fs with strong ETags derived from node and content version, so that they change with
+// every write but not with renames or Chtimes. Range and conditional requests are answered by http.ServeContent
+// against persisted modification times. Directories are served by http.FileServer.
+type Handler struct {
+	fs billyfs.FoundationDbFs
+}
+
+// NewHandler creates handler serving fs, every request is bound to its context
+func NewHandler(fs billyfs.FoundationDbFs) *Handler {
+	return &Handler{fs}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs := h.fs.WithContext(r.Context())
+
+	f, err := New(fs).Open(path.Clean("/" + r.URL.Path))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if info.IsDir() {
+		http.FileServer(New(fs)).ServeHTTP(w, r)
+		return
+	}
+
+	if etag, ok := etag(info); ok {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// etag is quoted node and content version of file
+func etag(info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*billyfs.NodeStat)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(`"%x-%x"`, stat.Node, stat.Version), true
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	}
+}
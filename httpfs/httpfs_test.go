@@ -0,0 +1,118 @@
+package httpfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, server *httptest.Server, path string, headers ...string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	require.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestFileServer(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	require.NoError(t, util.WriteFile(fs, "/dir/a", []byte("first"), 0644))
+	require.NoError(t, util.WriteFile(fs, "/dir/b", []byte("second"), 0644))
+	server := httptest.NewServer(http.FileServer(New(fs)))
+	defer server.Close()
+
+	resp, body := get(t, server, "/dir/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `href="a"`)
+	assert.Contains(t, body, `href="b"`)
+
+	resp, body = get(t, server, "/dir/b")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "second", body)
+
+	resp, _ = get(t, server, "/dir/missing")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandlerETag(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	require.NoError(t, util.WriteFile(fs, "/file", []byte("content"), 0644))
+	server := httptest.NewServer(NewHandler(fs))
+	defer server.Close()
+
+	resp, body := get(t, server, "/file")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "content", body)
+	tag := resp.Header.Get("ETag")
+	require.NotEmpty(t, tag)
+
+	resp, _ = get(t, server, "/file", "If-None-Match", tag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	require.NoError(t, fs.Rename("/file", "/moved"))
+	resp, _ = get(t, server, "/moved", "If-None-Match", tag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "Rename keeps content")
+
+	require.NoError(t, util.WriteFile(fs, "/moved", []byte("changed"), 0644))
+	resp, body = get(t, server, "/moved", "If-None-Match", tag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "changed", body)
+	assert.NotEqual(t, tag, resp.Header.Get("ETag"), "Write changes ETag")
+}
+
+func TestHandlerRange(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	content := make([]byte, 3000)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	require.NoError(t, util.WriteFile(fs, "/file", content, 0644))
+	server := httptest.NewServer(NewHandler(fs))
+	defer server.Close()
+
+	resp, body := get(t, server, "/file", "Range", "bytes=1020-1029")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, string(content[1020:1030]), body)
+	assert.Equal(t, "bytes 1020-1029/3000", resp.Header.Get("Content-Range"))
+
+	resp, _ = get(t, server, "/file", "Range", "bytes=1020-1029", "If-Range", `"stale"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Mismatching If-Range serves whole file")
+}
+
+func TestHandlerModifiedSince(t *testing.T) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	require.NoError(t, util.WriteFile(fs, "/file", []byte("content"), 0644))
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, fs.Chtimes("/file", at, at))
+	server := httptest.NewServer(NewHandler(fs))
+	defer server.Close()
+
+	resp, _ := get(t, server, "/file")
+	assert.Equal(t, at.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+
+	resp, _ = get(t, server, "/file", "If-Modified-Since", at.Format(http.TimeFormat))
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = get(t, server, "/file", "If-Modified-Since", at.Add(-time.Hour).Format(http.TimeFormat))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(t, server, "/")
+	modified := resp.Header.Get("Last-Modified")
+	require.NotEmpty(t, modified)
+	time.Sleep(time.Second)
+	resp, _ = get(t, server, "/", "If-Modified-Since", modified)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "Root has persisted modification time")
+}
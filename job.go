@@ -0,0 +1,51 @@
+package billyfs
+
+import (
+	"sync"
+	"time"
+)
+
+// job runs a round of background work every interval until it is closed
+type job struct {
+	stop chan struct{}
+	done chan struct{}
+	mu   sync.Mutex
+	err  error
+}
+
+func startJob(interval time.Duration, round func() error) *job {
+	j := &job{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+			}
+
+			err := round()
+			j.mu.Lock()
+			j.err = err
+			j.mu.Unlock()
+		}
+	}()
+	return j
+}
+
+// Close stops the job, a round in progress is finished first
+func (j *job) Close() error {
+	close(j.stop)
+	<-j.done
+	return nil
+}
+
+// Err returns the error the last round failed with, nil when it succeeded
+func (j *job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
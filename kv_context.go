@@ -0,0 +1,92 @@
+package billyfs
+
+import (
+	"context"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// contextStore binds a store without native context support to ctx. It is checked before f is run and before
+// writes are committed, so a transaction that outlived its context leaves no writes behind.
+type contextStore struct {
+	store KvStore
+	ctx   context.Context
+}
+
+var _ ContextStore = contextStore{}
+var _ WatchStore = contextStore{}
+var _ VersionStore = contextStore{}
+
+// storeWithContext binds store to ctx, natively when store is a ContextStore
+func storeWithContext(store KvStore, ctx context.Context) KvStore {
+	if s, ok := store.(ContextStore); ok {
+		return s.WithContext(ctx)
+	}
+	return contextStore{store, ctx}
+}
+
+func (s contextStore) WithContext(ctx context.Context) KvStore {
+	return storeWithContext(s.store, ctx)
+}
+
+func (s contextStore) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		return s.check(f(tx))
+	})
+}
+
+func (s contextStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return s.check(f(r))
+	})
+}
+
+func (s contextStore) check(ret interface{}, err error) (interface{}, error) {
+	if err == nil {
+		err = s.ctx.Err()
+	}
+	return ret, err
+}
+
+// Watch waits until value of key changes, ctx or the context of store is done
+func (s contextStore) Watch(ctx context.Context, key fdb.KeyConvertible, value []byte) error {
+	ctx, cancel := mergeContext(ctx, s.ctx)
+	defer cancel()
+	return watch(s.store, ctx, key, value)
+}
+
+// ReadVersion returns the current version of the underlying store
+func (s contextStore) ReadVersion() (int64, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return readVersion(s.store)
+}
+
+// AtVersion pins reads of the underlying store to version v
+func (s contextStore) AtVersion(v int64) KvStore {
+	return contextStore{storeAtVersion(s.store, v), s.ctx}
+}
+
+// mergeContext returns context of a that is cancelled when b is done as well
+func mergeContext(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	if b == nil || b.Done() == nil {
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-b.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
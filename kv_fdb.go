@@ -0,0 +1,284 @@
+package billyfs
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// FdbStore adapts fdb.Database to KvStore
+type FdbStore struct {
+	db fdb.Database
+	// ctx when set bounds every transaction, see WithContext
+	ctx context.Context
+	// retryLimit bounds retries of a transaction, negative is unlimited
+	retryLimit int64
+	// timeout bounds a transaction with all its retries, zero is unlimited
+	timeout time.Duration
+	// readVersion pins reads of transactions to a version, zero reads the latest one
+	readVersion int64
+}
+
+var _ ContextStore = FdbStore{}
+var _ WatchStore = FdbStore{}
+var _ VersionStore = FdbStore{}
+
+// fdb error codes the store handles itself
+const (
+	errCodeTransactionTooOld    = 1007
+	errCodeTransactionCancelled = 1025
+	errCodeTransactionTimedOut  = 1031
+)
+
+// NewFdbStore wraps an opened database
+func NewFdbStore(db fdb.Database) FdbStore {
+	return FdbStore{db: db, retryLimit: -1}
+}
+
+// WithContext returns store whose transactions time out at ctx deadline and are cancelled with ctx
+func (s FdbStore) WithContext(ctx context.Context) KvStore {
+	s.ctx = ctx
+	return s
+}
+
+// WithRetryLimit returns store whose transactions fail after limit retries, negative limit is unlimited
+func (s FdbStore) WithRetryLimit(limit int64) FdbStore {
+	s.retryLimit = limit
+	return s
+}
+
+// WithTimeout returns store whose transactions fail with context.DeadlineExceeded after timeout, zero is unlimited
+func (s FdbStore) WithTimeout(timeout time.Duration) FdbStore {
+	s.timeout = timeout
+	return s
+}
+
+// AtVersion returns store whose transactions read at version v
+func (s FdbStore) AtVersion(v int64) KvStore {
+	s.readVersion = v
+	return s
+}
+
+// ReadVersion returns read version of a new transaction, the pinned one when reads are pinned
+func (s FdbStore) ReadVersion() (int64, error) {
+	v, err := s.run(func(tx fdb.Transaction) (interface{}, error) {
+		return tx.GetReadVersion().Get()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+// Transact runs f in fdb retry loop
+func (s FdbStore) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	return s.run(func(tx fdb.Transaction) (interface{}, error) {
+		return f(fdbTransaction{fdbReadTransaction{tx}, tx})
+	})
+}
+
+// ReadTransact runs f in fdb read-only retry loop
+func (s FdbStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	// commit of a transaction without writes is a no-op, same as in fdb.Database.ReadTransact
+	return s.run(func(tx fdb.Transaction) (interface{}, error) {
+		return f(fdbReadTransaction{tx})
+	})
+}
+
+// Watch sets fdb watch on key unless its value differs already and waits for it. Watches are not bound to the
+// timeout of the store, only to ctx and the context of the store.
+func (s FdbStore) Watch(ctx context.Context, key fdb.KeyConvertible, value []byte) error {
+	ctx, cancel := mergeContext(ctx, s.ctx)
+	defer cancel()
+
+	// deadline of ctx would become a transaction timeout failing the watch, select below enforces it instead
+	cancelled, stop := mergeContext(context.Background(), ctx)
+	defer stop()
+
+	w, err := s.WithContext(cancelled).(FdbStore).WithTimeout(0).run(func(tx fdb.Transaction) (interface{}, error) {
+		current, err := tx.Get(key).Get()
+		if err != nil || !bytes.Equal(current, value) {
+			return nil, err
+		}
+		return tx.Watch(key), nil
+	})
+	if err != nil || w == nil {
+		return err
+	}
+
+	future := w.(fdb.FutureNil)
+	done := make(chan error, 1)
+	go func() {
+		done <- future.Get()
+	}()
+	select {
+	case err = <-done:
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	case <-ctx.Done():
+		future.Cancel()
+		return ctx.Err()
+	}
+}
+
+// run is the retry loop of fdb.Database.Transact, bound to context and options of the store
+func (s FdbStore) run(f func(fdb.Transaction) (interface{}, error)) (interface{}, error) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if s.timeout > 0 && (!hasDeadline || time.Now().Add(s.timeout).Before(deadline)) {
+		deadline, hasDeadline = time.Now().Add(s.timeout), true
+	}
+
+	// cancelling transaction fails its in-flight futures, including commit
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				tx.Cancel()
+			case <-done:
+			}
+		}()
+	}
+
+	for {
+		var ret interface{}
+		err = s.options(tx, deadline, hasDeadline)
+		if err == nil {
+			ret, err = attempt(tx, f)
+		}
+		if err == nil {
+			return ret, nil
+		}
+
+		// retry of a pinned transaction would read at the same version, which is only getting older
+		if e, ok := err.(fdb.Error); ok && (s.readVersion == 0 || e.Code != errCodeTransactionTooOld) {
+			err = tx.OnError(e).Get()
+		}
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+	}
+}
+
+// options sets retry limit, pinned read version and the time left till deadline as transaction timeout
+func (s FdbStore) options(tx fdb.Transaction, deadline time.Time, hasDeadline bool) error {
+	if s.readVersion != 0 {
+		tx.SetReadVersion(s.readVersion)
+	}
+	if s.retryLimit >= 0 {
+		if err := tx.Options().SetRetryLimit(s.retryLimit); err != nil {
+			return err
+		}
+	}
+	if hasDeadline {
+		left := time.Until(deadline).Milliseconds()
+		if left <= 0 {
+			return context.DeadlineExceeded
+		}
+		return tx.Options().SetTimeout(left)
+	}
+
+	return nil
+}
+
+// attempt runs f once and commits
+func attempt(tx fdb.Transaction, f func(fdb.Transaction) (interface{}, error)) (ret interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(fdb.Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	if ret, err = f(tx); err == nil {
+		err = tx.Commit().Get()
+	}
+
+	return ret, err
+}
+
+// contextError replaces fdb cancel and timeout errors with the error of context that caused them
+func contextError(ctx context.Context, err error) error {
+	e, ok := err.(fdb.Error)
+	if !ok || (e.Code != errCodeTransactionCancelled && e.Code != errCodeTransactionTimedOut) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if e.Code == errCodeTransactionTimedOut {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+type fdbReadTransaction struct {
+	r fdb.ReadTransaction
+}
+
+func (r fdbReadTransaction) Get(key fdb.KeyConvertible) FutureGetter {
+	return r.r.Get(key)
+}
+
+func (r fdbReadTransaction) GetRange(rng fdb.ExactRange, options fdb.RangeOptions) ([]fdb.KeyValue, error) {
+	return r.r.GetRange(rng, options).GetSliceWithError()
+}
+
+func (r fdbReadTransaction) Snapshot() KvReadTransaction {
+	return fdbReadTransaction{r.r.Snapshot()}
+}
+
+type fdbTransaction struct {
+	fdbReadTransaction
+	tx fdb.Transaction
+}
+
+func (t fdbTransaction) Set(key fdb.KeyConvertible, value []byte) {
+	t.tx.Set(key, value)
+}
+
+func (t fdbTransaction) Clear(key fdb.KeyConvertible) {
+	t.tx.Clear(key)
+}
+
+func (t fdbTransaction) ClearRange(r fdb.ExactRange) {
+	t.tx.ClearRange(r)
+}
+
+func (t fdbTransaction) Add(key fdb.KeyConvertible, param []byte) {
+	t.tx.Add(key, param)
+}
+
+func (t fdbTransaction) Max(key fdb.KeyConvertible, param []byte) {
+	t.tx.Max(key, param)
+}
+
+func (t fdbTransaction) Min(key fdb.KeyConvertible, param []byte) {
+	t.tx.Min(key, param)
+}
+
+func (t fdbTransaction) SetVersionstampedKey(key fdb.KeyConvertible, value []byte) {
+	t.tx.SetVersionstampedKey(key, value)
+}
@@ -0,0 +1,588 @@
+package billyfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/google/btree"
+)
+
+// MemoryStore is an in-process multi-version KvStore. Every transaction reads the version it has started at,
+// writes are buffered and committed optimistically, conflicting commits are retried the same way fdb does it.
+type MemoryStore struct {
+	mu      sync.Mutex
+	version int64
+	// keys orders every key that has (or had) a value
+	keys    *btree.BTree
+	values  map[string][]memoryValue
+	commits []memoryCommit
+	// oldest is the oldest read version conflicts can still be checked for
+	oldest int64
+	// watches are closed once value of their key changes
+	watches map[string][]chan struct{}
+}
+
+type memoryValue struct {
+	version int64
+	// value is nil when key was cleared at version
+	value []byte
+}
+
+type memoryCommit struct {
+	version int64
+	at      time.Time
+	writes  []memoryRange
+}
+
+type memoryKey string
+
+func (k memoryKey) Less(than btree.Item) bool {
+	return k < than.(memoryKey)
+}
+
+type memoryRange struct {
+	begin, end string
+}
+
+func singleKey(key string) memoryRange {
+	return memoryRange{key, key + "\x00"}
+}
+
+func (r memoryRange) contains(key string) bool {
+	return r.begin <= key && key < r.end
+}
+
+func (r memoryRange) intersects(o memoryRange) bool {
+	return r.begin < o.end && o.begin < r.end
+}
+
+// memoryHistory mirrors fdb MVCC window, transactions older than that fail with transaction_too_old
+const memoryHistory = 5 * time.Second
+
+var (
+	errMemoryTooOld       = fdb.Error{Code: 1007}
+	errMemoryFuture       = fdb.Error{Code: 1009}
+	errMemoryNotCommitted = fdb.Error{Code: 1020}
+	// errMemoryInvalidOperation is fdb client_invalid_operation
+	errMemoryInvalidOperation = fdb.Error{Code: 2000}
+)
+
+var _ WatchStore = &MemoryStore{}
+var _ VersionStore = &MemoryStore{}
+
+// NewMemoryStore creates empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: btree.New(32), values: map[string][]memoryValue{}}
+}
+
+// Transact runs f in retry loop and commits its writes
+func (s *MemoryStore) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	for {
+		tx := s.begin()
+		ret, err := memoryRun(func() (interface{}, error) {
+			return f(tx)
+		})
+		if err == nil {
+			err = s.commit(tx)
+		}
+		if !memoryRetryable(err) {
+			return ret, err
+		}
+	}
+}
+
+// ReadTransact runs f in retry loop
+func (s *MemoryStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	for {
+		tx := s.begin()
+		ret, err := memoryRun(func() (interface{}, error) {
+			return f(tx)
+		})
+		if !memoryRetryable(err) {
+			return ret, err
+		}
+	}
+}
+
+// ReadVersion returns the latest committed version
+func (s *MemoryStore) ReadVersion() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version, nil
+}
+
+// AtVersion returns view of store whose transactions read at version v
+func (s *MemoryStore) AtVersion(v int64) KvStore {
+	return memoryAtVersion{store: s, version: v}
+}
+
+// memoryAtVersion runs transactions of store at a pinned version, failed ones are not retried
+type memoryAtVersion struct {
+	store   *MemoryStore
+	version int64
+}
+
+func (s memoryAtVersion) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	tx, err := s.store.beginAt(s.version)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := memoryRun(func() (interface{}, error) {
+		return f(tx)
+	})
+	if err == nil {
+		err = s.store.commit(tx)
+	}
+	return ret, err
+}
+
+func (s memoryAtVersion) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	tx, err := s.store.beginAt(s.version)
+	if err != nil {
+		return nil, err
+	}
+	return memoryRun(func() (interface{}, error) {
+		return f(tx)
+	})
+}
+
+// memoryRun recovers fdb.Error panics the same way fdb.Database.Transact does
+func memoryRun(f func() (interface{}, error)) (ret interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(fdb.Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	return f()
+}
+
+func memoryRetryable(err error) bool {
+	e, ok := err.(fdb.Error)
+	return ok && (e.Code == errMemoryTooOld.Code || e.Code == errMemoryNotCommitted.Code)
+}
+
+func (s *MemoryStore) begin() *memoryTransaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &memoryTransaction{store: s, version: s.version}
+}
+
+// beginAt starts transaction reading at version, same as fdb it can not be newer than the latest commit
+func (s *MemoryStore) beginAt(version int64) (*memoryTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case version > s.version:
+		return nil, errMemoryFuture
+	case version < s.oldest:
+		return nil, errMemoryTooOld
+	}
+	return &memoryTransaction{store: s, version: version}, nil
+}
+
+func (s *MemoryStore) commit(tx *memoryTransaction) error {
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tx.version < s.oldest {
+		return errMemoryTooOld
+	}
+	newer := sort.Search(len(s.commits), func(i int) bool {
+		return s.commits[i].version > tx.version
+	})
+	for _, c := range s.commits[newer:] {
+		for _, w := range c.writes {
+			for _, r := range tx.reads {
+				if w.intersects(r) {
+					return errMemoryNotCommitted
+				}
+			}
+		}
+	}
+
+	s.version++
+	for _, op := range tx.ops {
+		if op.ranged {
+			for _, key := range s.keysIn(op.r) {
+				if s.valueAt(key, s.version) != nil {
+					s.put(key, nil)
+				}
+			}
+			continue
+		}
+		if op.stamped != nil {
+			s.put(stampKey(op.stamped, s.version), op.apply(nil))
+			continue
+		}
+		s.put(op.r.begin, op.apply(s.valueAt(op.r.begin, s.version)))
+	}
+
+	s.notify()
+
+	now := time.Now()
+	s.commits = append(s.commits, memoryCommit{s.version, now, tx.writes})
+	for len(s.commits) > 0 && now.Sub(s.commits[0].at) > memoryHistory {
+		s.oldest = s.commits[0].version
+		s.commits = s.commits[1:]
+	}
+
+	return nil
+}
+
+// Watch waits until value of key is no longer value or ctx is done
+func (s *MemoryStore) Watch(ctx context.Context, key fdb.KeyConvertible, value []byte) error {
+	k := string(key.FDBKey())
+
+	s.mu.Lock()
+	if !bytes.Equal(s.valueAt(k, s.version), value) {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.watches == nil {
+		s.watches = map[string][]chan struct{}{}
+	}
+	ch := make(chan struct{})
+	s.watches[k] = append(s.watches[k], ch)
+	s.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		s.unwatch(k, ch)
+		return ctx.Err()
+	}
+}
+
+func (s *MemoryStore) unwatch(key string, ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watches := s.watches[key]
+	for i := range watches {
+		if watches[i] == ch {
+			s.watches[key] = append(watches[:i:i], watches[i+1:]...)
+			break
+		}
+	}
+	if len(s.watches[key]) == 0 {
+		delete(s.watches, key)
+	}
+}
+
+// notify wakes watches of keys the current version changed, caller holds the lock
+func (s *MemoryStore) notify() {
+	for key, watches := range s.watches {
+		if bytes.Equal(s.valueAt(key, s.version), s.valueAt(key, s.version-1)) {
+			continue
+		}
+		for _, ch := range watches {
+			close(ch)
+		}
+		delete(s.watches, key)
+	}
+}
+
+// keysIn lists known keys of range in order, caller holds the lock
+func (s *MemoryStore) keysIn(r memoryRange) []string {
+	var keys []string
+	s.keys.AscendRange(memoryKey(r.begin), memoryKey(r.end), func(i btree.Item) bool {
+		keys = append(keys, string(i.(memoryKey)))
+		return true
+	})
+	return keys
+}
+
+// valueAt returns value of key as of version, caller holds the lock
+func (s *MemoryStore) valueAt(key string, version int64) []byte {
+	values := s.values[key]
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i].version <= version {
+			return values[i].value
+		}
+	}
+	return nil
+}
+
+// put records value of key at current version and drops history no reader can ask for, caller holds the lock
+func (s *MemoryStore) put(key string, value []byte) {
+	values, ok := s.values[key]
+	if !ok {
+		s.keys.ReplaceOrInsert(memoryKey(key))
+	}
+
+	if n := len(values); n > 0 && values[n-1].version == s.version {
+		values[n-1].value = value
+	} else {
+		values = append(values, memoryValue{s.version, value})
+	}
+
+	for len(values) > 1 && values[1].version <= s.oldest {
+		values = values[1:]
+	}
+	s.values[key] = values
+}
+
+type memoryOp struct {
+	r      memoryRange
+	ranged bool
+	// apply computes new value of a single key from old one, nil clears key
+	apply func(old []byte) []byte
+	// stamped is a key with versionstamp placeholder, it is known only once committed
+	stamped []byte
+}
+
+type memoryTransaction struct {
+	store   *MemoryStore
+	version int64
+	ops     []memoryOp
+	reads   []memoryRange
+	writes  []memoryRange
+}
+
+type memorySnapshot struct {
+	tx *memoryTransaction
+}
+
+type memoryFuture struct {
+	value []byte
+	err   error
+}
+
+func (f memoryFuture) Get() ([]byte, error) {
+	return f.value, f.err
+}
+
+func (tx *memoryTransaction) Get(key fdb.KeyConvertible) FutureGetter {
+	return tx.get(string(key.FDBKey()), false)
+}
+
+func (tx *memoryTransaction) GetRange(r fdb.ExactRange, options fdb.RangeOptions) ([]fdb.KeyValue, error) {
+	return tx.getRange(r, options, false)
+}
+
+func (tx *memoryTransaction) Snapshot() KvReadTransaction {
+	return memorySnapshot{tx}
+}
+
+func (s memorySnapshot) Get(key fdb.KeyConvertible) FutureGetter {
+	return s.tx.get(string(key.FDBKey()), true)
+}
+
+func (s memorySnapshot) GetRange(r fdb.ExactRange, options fdb.RangeOptions) ([]fdb.KeyValue, error) {
+	return s.tx.getRange(r, options, true)
+}
+
+func (s memorySnapshot) Snapshot() KvReadTransaction {
+	return s
+}
+
+func (tx *memoryTransaction) get(key string, snapshot bool) FutureGetter {
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+
+	if tx.version < tx.store.oldest {
+		return memoryFuture{nil, errMemoryTooOld}
+	}
+	if !snapshot {
+		tx.reads = append(tx.reads, singleKey(key))
+	}
+
+	return memoryFuture{copyBytes(tx.valueOf(key)), nil}
+}
+
+func (tx *memoryTransaction) getRange(r fdb.ExactRange, options fdb.RangeOptions, snapshot bool) ([]fdb.KeyValue, error) {
+	begin, end := r.FDBRangeKeys()
+	rng := memoryRange{string(begin.FDBKey()), string(end.FDBKey())}
+
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+
+	if tx.version < tx.store.oldest {
+		return nil, errMemoryTooOld
+	}
+
+	keys := tx.store.keysIn(rng)
+	for _, op := range tx.ops {
+		if !op.ranged && op.stamped == nil && rng.contains(op.r.begin) {
+			keys = append(keys, op.r.begin)
+		}
+	}
+	sort.Strings(keys)
+	if options.Reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+
+	var result []fdb.KeyValue
+	read := rng
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		value := tx.valueOf(key)
+		if value == nil {
+			continue
+		}
+		result = append(result, fdb.KeyValue{Key: fdb.Key(key), Value: copyBytes(value)})
+		if options.Limit > 0 && len(result) == options.Limit {
+			if options.Reverse {
+				read.begin = key
+			} else {
+				read.end = key + "\x00"
+			}
+			break
+		}
+	}
+
+	if !snapshot {
+		tx.reads = append(tx.reads, read)
+	}
+
+	return result, nil
+}
+
+// valueOf applies own writes of a transaction over stored value, caller holds the store lock
+func (tx *memoryTransaction) valueOf(key string) []byte {
+	value := tx.store.valueAt(key, tx.version)
+	for _, op := range tx.ops {
+		if op.r.contains(key) {
+			value = op.apply(value)
+		}
+	}
+	return value
+}
+
+func (tx *memoryTransaction) write(key fdb.KeyConvertible, apply func(old []byte) []byte) {
+	r := singleKey(string(key.FDBKey()))
+	tx.ops = append(tx.ops, memoryOp{r: r, apply: apply})
+	tx.writes = append(tx.writes, r)
+}
+
+func (tx *memoryTransaction) Set(key fdb.KeyConvertible, value []byte) {
+	value = copyBytes(value)
+	if value == nil {
+		value = []byte{}
+	}
+	tx.write(key, func([]byte) []byte {
+		return value
+	})
+}
+
+func (tx *memoryTransaction) Clear(key fdb.KeyConvertible) {
+	tx.write(key, func([]byte) []byte {
+		return nil
+	})
+}
+
+func (tx *memoryTransaction) ClearRange(r fdb.ExactRange) {
+	begin, end := r.FDBRangeKeys()
+	rng := memoryRange{string(begin.FDBKey()), string(end.FDBKey())}
+	tx.ops = append(tx.ops, memoryOp{r: rng, ranged: true, apply: func([]byte) []byte {
+		return nil
+	}})
+	tx.writes = append(tx.writes, rng)
+}
+
+func (tx *memoryTransaction) Add(key fdb.KeyConvertible, param []byte) {
+	param = copyBytes(param)
+	tx.write(key, func(old []byte) []byte {
+		result := make([]byte, len(param))
+		carry := 0
+		for i := range param {
+			sum := int(param[i]) + carry
+			if i < len(old) {
+				sum += int(old[i])
+			}
+			result[i] = byte(sum)
+			carry = sum >> 8
+		}
+		return result
+	})
+}
+
+func (tx *memoryTransaction) SetVersionstampedKey(key fdb.KeyConvertible, value []byte) {
+	value = copyBytes(value)
+	if value == nil {
+		value = []byte{}
+	}
+	stamped := key.FDBKey()
+	if len(stamped) < 4 || int(binary.LittleEndian.Uint32(stamped[len(stamped)-4:]))+10 > len(stamped)-4 {
+		panic(errMemoryInvalidOperation)
+	}
+	tx.ops = append(tx.ops, memoryOp{stamped: copyBytes(stamped), apply: func([]byte) []byte {
+		return value
+	}})
+}
+
+// stampKey fills placeholder of versionstamped key with commit version, batch order is always zero
+func stampKey(key []byte, version int64) string {
+	pos := int(binary.LittleEndian.Uint32(key[len(key)-4:]))
+	key = copyBytes(key[:len(key)-4])
+	binary.BigEndian.PutUint64(key[pos:], uint64(version))
+	binary.BigEndian.PutUint16(key[pos+8:], 0)
+	return string(key)
+}
+
+func (tx *memoryTransaction) Max(key fdb.KeyConvertible, param []byte) {
+	param = copyBytes(param)
+	tx.write(key, func(old []byte) []byte {
+		if old != nil && compareLittleEndian(old, param) > 0 {
+			return resize(old, len(param))
+		}
+		return param
+	})
+}
+
+func (tx *memoryTransaction) Min(key fdb.KeyConvertible, param []byte) {
+	param = copyBytes(param)
+	tx.write(key, func(old []byte) []byte {
+		if old != nil && compareLittleEndian(old, param) < 0 {
+			return resize(old, len(param))
+		}
+		return param
+	})
+}
+
+// compareLittleEndian compares a and b as unsigned little-endian integers of len(b) bytes
+func compareLittleEndian(a, b []byte) int {
+	a = resize(a, len(b))
+	for i := len(b) - 1; i >= 0; i-- {
+		switch {
+		case a[i] > b[i]:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		}
+	}
+	return 0
+}
+
+func resize(b []byte, n int) []byte {
+	result := make([]byte, n)
+	copy(result, b)
+	return result
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,234 @@
+package billyfs
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryStoreTestSuite struct {
+	suite.Suite
+	store *MemoryStore
+}
+
+func TestMemoryStore(t *testing.T) {
+	suite.Run(t, new(MemoryStoreTestSuite))
+}
+
+func (s *MemoryStoreTestSuite) SetupTest() {
+	s.store = NewMemoryStore()
+}
+
+func (s *MemoryStoreTestSuite) set(key string, value string) {
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fdb.Key(key), []byte(value))
+		return nil, nil
+	})
+	s.Require().NoError(err)
+}
+
+func (s *MemoryStoreTestSuite) get(key string) []byte {
+	value, err := s.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return r.Get(fdb.Key(key)).Get()
+	})
+	s.Require().NoError(err)
+	return value.([]byte)
+}
+
+func (s *MemoryStoreTestSuite) TestReadsOwnWrites() {
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fdb.Key("a"), []byte("1"))
+		value, err := tx.Get(fdb.Key("a")).Get()
+		s.Equal([]byte("1"), value)
+		tx.Clear(fdb.Key("a"))
+		value, err = tx.Get(fdb.Key("a")).Get()
+		s.Nil(value)
+		tx.Set(fdb.Key("a"), []byte("2"))
+		return nil, err
+	})
+	s.Require().NoError(err)
+	s.Equal([]byte("2"), s.get("a"))
+	s.Nil(s.get("b"), "Missing key is nil")
+}
+
+func (s *MemoryStoreTestSuite) TestRangeLimitAndReverse() {
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.set(k, k)
+	}
+
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.ClearRange(fdb.KeyRange{Begin: fdb.Key("b"), End: fdb.Key("c")})
+		tx.Set(fdb.Key("bb"), []byte("bb"))
+
+		all, err := tx.GetRange(fdb.KeyRange{Begin: fdb.Key("a"), End: fdb.Key("z")}, fdb.RangeOptions{})
+		s.Require().NoError(err)
+		s.Equal([]string{"a", "bb", "c", "d"}, keysOf(all))
+
+		last, err := tx.GetRange(fdb.KeyRange{Begin: fdb.Key("a"), End: fdb.Key("z")}, fdb.RangeOptions{Limit: 2, Reverse: true})
+		s.Require().NoError(err)
+		s.Equal([]string{"d", "c"}, keysOf(last))
+		return nil, nil
+	})
+	s.Require().NoError(err)
+}
+
+func (s *MemoryStoreTestSuite) TestConflictingCommitIsRetried() {
+	s.set("counter", "0")
+	attempts := 0
+
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		attempts++
+		value, err := tx.Get(fdb.Key("counter")).Get()
+		if attempts == 1 {
+			// concurrent writer commits after our read
+			s.set("counter", "1")
+		}
+		tx.Set(fdb.Key("counter"), append(value, '+'))
+		return nil, err
+	})
+
+	s.Require().NoError(err)
+	s.Equal(2, attempts, "First attempt conflicts")
+	s.Equal([]byte("1+"), s.get("counter"))
+}
+
+func (s *MemoryStoreTestSuite) TestSnapshotReadsDoNotConflict() {
+	s.set("k", "0")
+	attempts := 0
+
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		attempts++
+		_, err := tx.Snapshot().Get(fdb.Key("k")).Get()
+		if attempts == 1 {
+			s.set("k", "1")
+		}
+		tx.Set(fdb.Key("other"), []byte{})
+		return nil, err
+	})
+
+	s.Require().NoError(err)
+	s.Equal(1, attempts)
+}
+
+func (s *MemoryStoreTestSuite) TestAtomicOps() {
+	param := make([]byte, 8)
+	binary.LittleEndian.PutUint64(param, 5)
+
+	_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Add(fdb.Key("sum"), param)
+		tx.Add(fdb.Key("sum"), param)
+		tx.Max(fdb.Key("max"), param)
+		tx.Min(fdb.Key("min"), param)
+		return nil, nil
+	})
+	s.Require().NoError(err)
+
+	small := make([]byte, 8)
+	binary.LittleEndian.PutUint64(small, 3)
+	_, err = s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Max(fdb.Key("max"), small)
+		tx.Min(fdb.Key("min"), small)
+		return nil, nil
+	})
+	s.Require().NoError(err)
+
+	s.Equal(uint64(10), binary.LittleEndian.Uint64(s.get("sum")))
+	s.Equal(uint64(5), binary.LittleEndian.Uint64(s.get("max")))
+	s.Equal(uint64(3), binary.LittleEndian.Uint64(s.get("min")))
+}
+
+func keysOf(kvs []fdb.KeyValue) []string {
+	keys := make([]string, len(kvs))
+	for i := range kvs {
+		keys[i] = string(kvs[i].Key)
+	}
+	return keys
+}
+
+func (s *MemoryStoreTestSuite) TestWatch() {
+	s.set("watched", "before")
+
+	changed := make(chan error, 1)
+	go func() {
+		changed <- s.store.Watch(context.Background(), fdb.Key("watched"), []byte("before"))
+	}()
+	s.set("other", "value")
+	select {
+	case err := <-changed:
+		s.FailNow("Watch ended without a change", "%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.set("watched", "after")
+	select {
+	case err := <-changed:
+		s.NoError(err)
+	case <-time.After(5 * time.Second):
+		s.FailNow("Watch missed a change")
+	}
+
+	s.NoError(s.store.Watch(context.Background(), fdb.Key("watched"), []byte("before")),
+		"Value differing already ends watch at once")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.Equal(context.DeadlineExceeded, s.store.Watch(ctx, fdb.Key("watched"), []byte("after")))
+	s.Empty(s.store.watches, "Ended watches are dropped")
+}
+
+func (s *MemoryStoreTestSuite) TestVersionstampedKey() {
+	stamped := func(prefix string) fdb.Key {
+		key := append([]byte(prefix), make([]byte, 10)...)
+		pos := make([]byte, 4)
+		binary.LittleEndian.PutUint32(pos, uint32(len(prefix)))
+		return append(key, pos...)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := s.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			tx.SetVersionstampedKey(stamped("log"), []byte{byte(i)})
+			kvs, err := tx.GetRange(fdb.KeyRange{Begin: fdb.Key("log"), End: fdb.Key("loh")}, fdb.RangeOptions{})
+			s.Len(kvs, i, "Versionstamped key is not visible to its transaction")
+			return nil, err
+		})
+		s.Require().NoError(err)
+	}
+
+	kvs, err := s.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return r.GetRange(fdb.KeyRange{Begin: fdb.Key("log"), End: fdb.Key("loh")}, fdb.RangeOptions{})
+	})
+	s.Require().NoError(err)
+	s.Require().Len(kvs, 2)
+	s.Less(string(kvs.([]fdb.KeyValue)[0].Key), string(kvs.([]fdb.KeyValue)[1].Key), "Later commits sort later")
+	s.Equal([]byte{1}, kvs.([]fdb.KeyValue)[1].Value)
+	s.Len(kvs.([]fdb.KeyValue)[0].Key, 13)
+}
+
+func (s *MemoryStoreTestSuite) TestAtVersion() {
+	s.set("a", "1")
+	v, err := s.store.ReadVersion()
+	s.Require().NoError(err)
+	s.set("a", "2")
+
+	value, err := s.store.AtVersion(v).ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return r.Get(fdb.Key("a")).Get()
+	})
+	s.Require().NoError(err)
+	s.Equal([]byte("1"), value)
+	s.Equal([]byte("2"), s.get("a"))
+
+	_, err = s.store.AtVersion(v + 2).ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return nil, nil
+	})
+	s.Equal(errMemoryFuture, err, "Versions not committed yet can not be read")
+
+	s.store.oldest = v + 1
+	_, err = s.store.AtVersion(v).ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return nil, nil
+	})
+	s.Equal(errMemoryTooOld, err, "Versions out of the window fail instead of being retried")
+}
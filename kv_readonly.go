@@ -0,0 +1,62 @@
+package billyfs
+
+import (
+	"syscall"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// readOnlyStore runs transactions of a store without committing them, transactions that write fail with
+// syscall.EROFS. Read-only operations of the filesystem go through Transact as well, so they keep working.
+type readOnlyStore struct {
+	store KvStore
+}
+
+func (s readOnlyStore) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	return s.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		tx := &readOnlyTransaction{KvReadTransaction: r}
+		ret, err := f(tx)
+		if err == nil && tx.written {
+			return nil, syscall.EROFS
+		}
+		return ret, err
+	})
+}
+
+func (s readOnlyStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	return s.store.ReadTransact(f)
+}
+
+// readOnlyTransaction drops writes and remembers there were some
+type readOnlyTransaction struct {
+	KvReadTransaction
+	written bool
+}
+
+func (tx *readOnlyTransaction) Set(fdb.KeyConvertible, []byte) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) Clear(fdb.KeyConvertible) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) ClearRange(fdb.ExactRange) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) Add(fdb.KeyConvertible, []byte) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) Max(fdb.KeyConvertible, []byte) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) Min(fdb.KeyConvertible, []byte) {
+	tx.written = true
+}
+
+func (tx *readOnlyTransaction) SetVersionstampedKey(fdb.KeyConvertible, []byte) {
+	tx.written = true
+}
@@ -0,0 +1,191 @@
+package billyfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Versions before node ids kept every file and directory as a directory of the fdb directory layer root named by
+// its path, with mode at (0xFC, 0x00) and data buckets at (0xFD, 0x00, bucket) of its subspace. Mode was the
+// permission given on creation, so directories are told by os.ModeDir or by having children. Migration copies
+// such a tree into the filesystem, ("m") marks a migration in progress, so an interrupted one runs again.
+
+// ErrLegacyLayout is returned by NewFoundationDbFsWithOptions when the filesystem is empty while the fdb directory
+// layer holds a tree written by versions that kept every file as a directory of the directory layer
+var ErrLegacyLayout = errors.New("directory layer holds a tree of the legacy layout, see WithLegacyMigration")
+
+// legacyNode is a file or directory of the legacy layout
+type legacyNode struct {
+	name  string
+	mode  os.FileMode
+	isDir bool
+	sp    subspace.Subspace
+}
+
+func (fs FoundationDbFs) migrating() fdb.Key {
+	return fs.root.Pack(tuple.Tuple{"m"})
+}
+
+// openLegacy fails with ErrLegacyLayout when filesystem is empty and db holds a legacy tree, with migrate the tree
+// is copied into the filesystem instead. The legacy tree is left in place.
+func (fs FoundationDbFs) openLegacy(db fdb.Database, migrate bool) error {
+	fresh, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		marker, err := r.Get(fs.migrating()).Get()
+		if err != nil || marker != nil {
+			return marker != nil, err
+		}
+		kvs, err := r.GetRange(fs.root, fdb.RangeOptions{Limit: 1})
+		return len(kvs) == 0, err
+	})
+	if err != nil || !fresh.(bool) {
+		return err
+	}
+
+	nodes, err := legacyNodes(db, nil)
+	if err != nil || len(nodes) == 0 {
+		return err
+	}
+	if !migrate {
+		return ErrLegacyLayout
+	}
+
+	if _, err = fs.transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fs.migrating(), []byte{})
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+	if err = fs.migrateLegacy(db, nil, nodes); err != nil {
+		return err
+	}
+	_, err = fs.transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Clear(fs.migrating())
+		return nil, nil
+	})
+	return err
+}
+
+// legacyNodes lists children of legacy directory at fsPath, directories of other layers carry no mode and are
+// skipped
+func legacyNodes(db fdb.Database, fsPath []string) ([]legacyNode, error) {
+	nodes, err := db.ReadTransact(func(rt fdb.ReadTransaction) (interface{}, error) {
+		names, err := directory.List(rt, fsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var nodes []legacyNode
+		for _, name := range names {
+			p := append(append([]string{}, fsPath...), name)
+			dir, err := directory.Open(rt, p, nil)
+			if err != nil {
+				return nil, err
+			}
+			mode, err := rt.Get(dir.Pack(modeKey)).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(mode) < 4 {
+				continue
+			}
+			children, err := directory.List(rt, p)
+			if err != nil {
+				return nil, err
+			}
+
+			node := legacyNode{name: name, mode: os.FileMode(binary.LittleEndian.Uint32(mode)), sp: dir}
+			node.isDir = node.mode.IsDir() || len(children) > 0
+			nodes = append(nodes, node)
+		}
+		return nodes, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.([]legacyNode), nil
+}
+
+// migrateLegacy copies legacy nodes of directory at fsPath with their descendants
+func (fs FoundationDbFs) migrateLegacy(db fdb.Database, fsPath []string, nodes []legacyNode) error {
+	for _, node := range nodes {
+		p := append(append([]string{}, fsPath...), node.name)
+		if !node.isDir {
+			if err := fs.migrateLegacyFile(db, p, node); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fs.MkdirAll(joinPath(p), os.ModeDir|node.mode.Perm()); err != nil {
+			return err
+		}
+		children, err := legacyNodes(db, p)
+		if err != nil {
+			return err
+		}
+		if err = fs.migrateLegacy(db, p, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyFile copies data buckets of legacy file a write batch at a time
+func (fs FoundationDbFs) migrateLegacyFile(db fdb.Database, fsPath []string, node legacyNode) error {
+	f, err := fs.OpenFile(joinPath(fsPath), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, node.mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data := node.sp.Sub(0xFD, 0x00)
+	begin, end := data.FDBRangeKeys()
+	for {
+		batch, err := db.ReadTransact(func(rt fdb.ReadTransaction) (interface{}, error) {
+			return rt.GetRange(fdb.KeyRange{Begin: begin, End: end},
+				fdb.RangeOptions{Limit: fs.batch()}).GetSliceWithError()
+		})
+		if err != nil {
+			return err
+		}
+		kvs := batch.([]fdb.KeyValue)
+
+		// runs of adjacent full buckets are written at once
+		var run []byte
+		var at int64
+		for _, kv := range kvs {
+			key, err := data.Unpack(kv.Key)
+			if err != nil {
+				return err
+			}
+			if len(key) != 1 {
+				return fmt.Errorf("malformed_legacy_bucket %v", key)
+			}
+			bucket, ok := key[0].(int64)
+			if !ok || bucket < 0 {
+				return fmt.Errorf("malformed_legacy_bucket %v", key)
+			}
+			if bucket*rEADSIZE != at+int64(len(run)) || int64(len(run))%rEADSIZE != 0 {
+				if _, err = f.(*FoundationDbFile).WriteAt(run, at); err != nil {
+					return err
+				}
+				run, at = nil, bucket*rEADSIZE
+			}
+			run = append(run, kv.Value...)
+		}
+		if _, err = f.(*FoundationDbFile).WriteAt(run, at); err != nil {
+			return err
+		}
+		if len(kvs) < fs.batch() {
+			return nil
+		}
+		begin = append(kvs[len(kvs)-1].Key, 0x00)
+	}
+}
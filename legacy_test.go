@@ -0,0 +1,74 @@
+package billyfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestLegacyMigration() {
+	if s.memory {
+		s.T().Skip("legacy layout lives in the fdb directory layer")
+	}
+	db := s.fdbfs.Store().(FdbStore).db
+
+	// tree as written by versions keeping every file as a directory of the directory layer
+	content := make([]byte, 2*rEADSIZE+3)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	_, err := db.Transact(func(tx fdb.Transaction) (interface{}, error) {
+		for _, node := range []struct {
+			path []string
+			mode os.FileMode
+		}{{[]string{"legacy"}, 0755}, {[]string{"legacy", "empty"}, os.ModeDir | 0700},
+			{[]string{"legacy", "data"}, 0640}} {
+			dir, err := directory.CreateOrOpen(tx, node.path, nil)
+			if err != nil {
+				return nil, err
+			}
+			mode := make([]byte, 4)
+			binary.LittleEndian.PutUint32(mode, uint32(node.mode))
+			tx.Set(dir.Pack(modeKey), mode)
+		}
+		data, err := directory.Open(tx, []string{"legacy", "data"}, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := int64(0); i*rEADSIZE < int64(len(content)); i++ {
+			end := (i + 1) * rEADSIZE
+			if end > int64(len(content)) {
+				end = int64(len(content))
+			}
+			tx.Set(data.Pack(tuple.Tuple{0xFD, 0x00, i}), content[i*rEADSIZE:end])
+		}
+		return nil, nil
+	})
+	s.Require().NoError(err)
+	defer directory.Root().Remove(db, []string{"legacy"})
+
+	_, err = NewFoundationDbFsWithOptions(WithDatabase(db), WithSubspace(subspace.Sub("legacyfresh")))
+	s.True(errors.Is(err, ErrLegacyLayout), "Empty filesystem over a legacy tree fails, got %v", err)
+
+	fs, err := NewFoundationDbFsWithOptions(WithDatabase(db), WithSubspace(subspace.Sub("legacyfresh")),
+		WithLegacyMigration())
+	s.Require().NoError(err)
+	migrated, err := util.ReadFile(fs, "/legacy/data")
+	s.Require().NoError(err)
+	s.Equal(content, migrated)
+	info, err := fs.Stat("/legacy/data")
+	s.Require().NoError(err)
+	s.Equal(os.FileMode(0640), info.Mode())
+	info, err = fs.Stat("/legacy/empty")
+	s.Require().NoError(err)
+	s.True(info.IsDir())
+
+	_, err = NewFoundationDbFsWithOptions(WithDatabase(db), WithSubspace(subspace.Sub("legacyfresh")))
+	s.NoError(err, "Migrated filesystem opens")
+}
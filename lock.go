@@ -0,0 +1,270 @@
+package billyfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// defaultLockLease is how long a lock outlives a holder that stopped renewing it
+const defaultLockLease = 10 * time.Second
+
+// Locks are advisory and whole-file. Lock of a node is its lockKey holding tuple of owner and expiry in unix
+// nanoseconds. Holder renews expiry in the background, so only locks of crashed or partitioned holders expire and
+// any waiter reclaims them then. Expiry is compared with local clocks, which are assumed to be synchronized well
+// within the lease. Like the rest of node, lockKey is copied for snapshots before it changes.
+type fileLock struct {
+	owner string
+	// stop ends heartbeat, done is closed once it ended
+	stop chan struct{}
+	done chan struct{}
+}
+
+// lockAttempt is the outcome of tryLock, value and expiry of the lock are set when it is held by someone else
+type lockAttempt struct {
+	acquired bool
+	value    []byte
+	expiry   time.Time
+}
+
+func (fs FoundationDbFs) lease() time.Duration {
+	if fs.lockLease > 0 {
+		return fs.lockLease
+	}
+	return defaultLockLease
+}
+
+// Lock blocks until lock of the file is acquired
+func (f *FoundationDbFile) Lock() error {
+	return f.LockContext(context.Background())
+}
+
+// LockContext blocks until lock of the file is acquired or ctx is done. Waiters watch the lock, so they wake up
+// as soon as it is released or its lease expires.
+func (f *FoundationDbFile) LockContext(ctx context.Context) error {
+	for {
+		attempt, err := f.tryLock()
+		if err != nil {
+			return &os.PathError{Op: "lock", Path: f.name, Err: err}
+		}
+		if attempt.acquired {
+			return nil
+		}
+
+		wait, cancel := context.WithDeadline(ctx, attempt.expiry)
+		err = watch(f.fs.store, wait, f.fs.node(f.id).Pack(lockKey), attempt.value)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return &os.PathError{Op: "lock", Path: f.name, Err: ctx.Err()}
+		case err != nil && err != context.DeadlineExceeded:
+			return &os.PathError{Op: "lock", Path: f.name, Err: err}
+		}
+	}
+}
+
+// TryLock acquires lock of the file unless someone else holds it
+func (f *FoundationDbFile) TryLock() (bool, error) {
+	attempt, err := f.tryLock()
+	if err != nil {
+		return false, &os.PathError{Op: "lock", Path: f.name, Err: err}
+	}
+	return attempt.acquired, nil
+}
+
+// Unlock releases lock of the file, unlocking a file that holds no lock does nothing
+func (f *FoundationDbFile) Unlock() error {
+	if f.data.closed {
+		return os.ErrClosed
+	}
+	if err := f.unlock(); err != nil {
+		return &os.PathError{Op: "unlock", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *FoundationDbFile) tryLock() (lockAttempt, error) {
+	if f.data.closed {
+		return lockAttempt{}, os.ErrClosed
+	}
+	if f.data.lock != nil {
+		held, err := f.held()
+		if err != nil || held {
+			return lockAttempt{acquired: held}, err
+		}
+		f.dropLock()
+	}
+
+	owner, err := lockOwner()
+	if err != nil {
+		return lockAttempt{}, err
+	}
+
+	attempt, err := f.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		if err := f.fs.lockable(tx, f.id); err != nil {
+			return nil, err
+		}
+
+		key := f.fs.node(f.id).Pack(lockKey)
+		value, err := tx.Get(key).Get()
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			_, expiry, err := unpackLock(value)
+			if err != nil {
+				return nil, err
+			}
+			if time.Now().Before(expiry) {
+				return lockAttempt{value: value, expiry: expiry}, nil
+			}
+		}
+
+		tx.Set(key, packLock(owner, time.Now().Add(f.fs.lease())))
+		return lockAttempt{acquired: true}, nil
+	})
+	if err != nil {
+		return lockAttempt{}, err
+	}
+
+	if attempt.(lockAttempt).acquired {
+		f.data.lock = &fileLock{owner: owner, stop: make(chan struct{}), done: make(chan struct{})}
+		go f.fs.heartbeat(f.id, f.data.lock)
+	}
+	return attempt.(lockAttempt), nil
+}
+
+func (f *FoundationDbFile) unlock() error {
+	if f.data.lock == nil {
+		return nil
+	}
+	l := f.dropLock()
+
+	_, err := f.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		key := f.fs.node(f.id).Pack(lockKey)
+		value, err := tx.Get(key).Get()
+		if err != nil || value == nil {
+			return nil, err
+		}
+		if owner, _, err := unpackLock(value); err != nil || owner != l.owner {
+			return nil, err
+		}
+		tx.Clear(key)
+		return nil, nil
+	})
+	return err
+}
+
+// held tells whether the lock of the file is still held. Heartbeat ends once it finds the lock lost, a lock
+// lost since its last beat is told by the stored owner.
+func (f *FoundationDbFile) held() (bool, error) {
+	l := f.data.lock
+	select {
+	case <-l.done:
+		return false, nil
+	default:
+	}
+
+	held, err := f.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		value, err := r.Get(f.fs.node(f.id).Pack(lockKey)).Get()
+		if err != nil || value == nil {
+			return false, err
+		}
+		owner, expiry, err := unpackLock(value)
+		if err != nil {
+			return false, err
+		}
+		return owner == l.owner && time.Now().Before(expiry), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return held.(bool), nil
+}
+
+// dropLock forgets lock of the file and waits for its heartbeat to end
+func (f *FoundationDbFile) dropLock() *fileLock {
+	l := f.data.lock
+	f.data.lock = nil
+	close(l.stop)
+	<-l.done
+	return l
+}
+
+// lockable fails with os.ErrNotExist for removed nodes, their locks would outlive them
+func (fs FoundationDbFs) lockable(r KvReadTransaction, id int64) error {
+	if id == rootNode {
+		return nil
+	}
+	mode, err := r.Get(fs.node(id).Pack(modeKey)).Get()
+	if err == nil && mode == nil {
+		err = os.ErrNotExist
+	}
+	return err
+}
+
+// heartbeat renews lock until it is stopped or lost, failed renewals are retried on the next beat. Lost lock is
+// still set on the file until it is dropped, see held.
+func (fs FoundationDbFs) heartbeat(id int64, l *fileLock) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(fs.lease() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		held, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+			key := fs.node(id).Pack(lockKey)
+			value, err := tx.Get(key).Get()
+			if err != nil || value == nil {
+				return false, err
+			}
+			if owner, _, err := unpackLock(value); err != nil || owner != l.owner {
+				return false, err
+			}
+			tx.Set(key, packLock(l.owner, time.Now().Add(fs.lease())))
+			return true, nil
+		})
+		if err == nil && !held.(bool) {
+			return
+		}
+	}
+}
+
+// lockOwner returns a random id of a lock holder
+func lockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func packLock(owner string, expiry time.Time) []byte {
+	return tuple.Tuple{owner, expiry.UnixNano()}.Pack()
+}
+
+func unpackLock(value []byte) (string, time.Time, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(t) != 2 {
+		return "", time.Time{}, fmt.Errorf("malformed_lock %v", t)
+	}
+	owner, ok := t[0].(string)
+	expiry, ok2 := t[1].(int64)
+	if !ok || !ok2 {
+		return "", time.Time{}, fmt.Errorf("malformed_lock %v", t)
+	}
+	return owner, time.Unix(0, expiry), nil
+}
@@ -0,0 +1,139 @@
+package billyfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+func (s *FsTestSuite) openLocked(path string) (billy.File, billy.File) {
+	first, err := s.fdbfs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	s.Require().NoError(err)
+	second, err := s.fdbfs.Open(path)
+	s.Require().NoError(err)
+	s.Require().NoError(first.Lock())
+	return first, second
+}
+
+func (s *FsTestSuite) TestTryLock() {
+	first, second := s.openLocked("/trylock")
+	defer second.Close()
+
+	acquired, err := second.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.False(acquired, "Lock held by another file is not acquired")
+	acquired, err = first.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.True(acquired, "Holder acquires its lock again")
+
+	s.Require().NoError(first.Close())
+	acquired, err = second.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.True(acquired, "Close releases lock")
+	s.NoError(second.Unlock())
+	s.NoError(second.Unlock(), "Unlocking a released lock does nothing")
+}
+
+func (s *FsTestSuite) TestLockWaitsForUnlock() {
+	first, second := s.openLocked("/lockwait")
+	defer first.Close()
+	defer second.Close()
+
+	locked := make(chan error, 1)
+	go func() {
+		locked <- second.Lock()
+	}()
+
+	select {
+	case err := <-locked:
+		s.FailNow("Lock did not wait", "%v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.Require().NoError(first.Unlock())
+	select {
+	case err := <-locked:
+		s.NoError(err)
+	case <-time.After(5 * time.Second):
+		s.FailNow("Lock was not acquired after unlock")
+	}
+}
+
+func (s *FsTestSuite) TestLockContext() {
+	first, second := s.openLocked("/lockctx")
+	defer first.Close()
+	defer second.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := second.(*FoundationDbFile).LockContext(ctx)
+	s.True(errors.Is(err, context.DeadlineExceeded), "Waiting ends with context, got %v", err)
+}
+
+func (s *FsTestSuite) TestStaleLockReclaimed() {
+	fs := *s.fdbfs
+	fs.lockLease = 300 * time.Millisecond
+	file, err := fs.Create("/stalelock")
+	s.Require().NoError(err)
+	defer file.Close()
+	id := file.(*FoundationDbFile).id
+
+	// a holder that crashed leaves its lock behind without renewing it
+	_, err = fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fs.node(id).Pack(lockKey), packLock("crashed", time.Now().Add(fs.lease())))
+		return nil, nil
+	})
+	s.Require().NoError(err)
+
+	acquired, err := file.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.False(acquired, "Lock is held until its lease expires")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Require().NoError(file.(*FoundationDbFile).LockContext(ctx))
+
+	// heartbeat keeps the reclaimed lock beyond its lease
+	time.Sleep(2 * fs.lease())
+	other, err := fs.Open("/stalelock")
+	s.Require().NoError(err)
+	defer other.Close()
+	acquired, err = other.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.False(acquired, "Renewed lock is not reclaimed")
+}
+
+func (s *FsTestSuite) TestLostLock() {
+	first, second := s.openLocked("/lostlock")
+	defer first.Close()
+	defer second.Close()
+	id := first.(*FoundationDbFile).id
+
+	// lease of a partitioned holder expired and someone else reclaimed the lock
+	_, err := s.fdbfs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(s.fdbfs.node(id).Pack(lockKey), packLock("reclaimed", time.Now().Add(time.Minute)))
+		return nil, nil
+	})
+	s.Require().NoError(err)
+
+	acquired, err := first.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.False(acquired, "Lost lock is not reported as held")
+	s.NoError(first.Unlock())
+	acquired, err = second.(*FoundationDbFile).TryLock()
+	s.Require().NoError(err)
+	s.False(acquired, "Unlocking a lost lock leaves the new holder alone")
+}
+
+func (s *FsTestSuite) TestLockRemovedFile() {
+	file, err := s.fdbfs.Create("/lockremoved")
+	s.Require().NoError(err)
+	defer file.Close()
+	s.Require().NoError(s.fdbfs.Remove("/lockremoved"))
+
+	err = file.Lock()
+	s.True(os.IsNotExist(err), "Removed file can not be locked, got %v", err)
+}
@@ -1,7 +1,11 @@
 package billyfs
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"time"
+
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 )
 
@@ -30,6 +34,97 @@ type FutureGetter interface {
 	Get() ([]byte, error)
 }
 
+// KvStore is a narrow transactional key-value store FoundationDbFs runs on. fdb.Database satisfies it through
+// NewFdbStore, MemoryStore satisfies it in-process.
+type KvStore interface {
+	// Transact runs f in a retry loop and commits its writes
+	Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error)
+	// ReadTransact runs f in a retry loop without committing
+	ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error)
+}
+
+// ContextStore is a KvStore that can bind its transactions to a context. Transactions of returned store fail
+// with ctx.Err() once ctx is done.
+type ContextStore interface {
+	KvStore
+	WithContext(ctx context.Context) KvStore
+}
+
+// WatchStore is a KvStore that can wait for a change of a key, the way fdb watches do
+type WatchStore interface {
+	KvStore
+	// Watch blocks until value of key is no longer value or ctx is done. Changes that were undone before the
+	// store noticed them may be missed.
+	Watch(ctx context.Context, key fdb.KeyConvertible, value []byte) error
+}
+
+// VersionStore is a KvStore that can read at a past version, within the MVCC window of the store
+type VersionStore interface {
+	KvStore
+	// ReadVersion returns the version a transaction started now reads at
+	ReadVersion() (int64, error)
+	// AtVersion returns a store whose transactions read at version v. They fail with fdb transaction_too_old
+	// once v falls out of the MVCC window, instead of being retried at a newer version.
+	AtVersion(v int64) KvStore
+}
+
+// watchPoll is how often stores without watches are polled
+const watchPoll = 100 * time.Millisecond
+
+// watch waits for a change of key with store watches, stores without them are polled
+func watch(store KvStore, ctx context.Context, key fdb.KeyConvertible, value []byte) error {
+	if w, ok := store.(WatchStore); ok {
+		return w.Watch(ctx, key, value)
+	}
+
+	ticker := time.NewTicker(watchPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current, err := store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+			return r.Get(key).Get()
+		})
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current.([]byte), value) {
+			return nil
+		}
+	}
+}
+
+// KvReadTransaction is a read view of a store at a single version
+type KvReadTransaction interface {
+	NarrowGetter
+	// GetRange reads key-values of a range, honouring limit and reverse of options
+	GetRange(r fdb.ExactRange, options fdb.RangeOptions) ([]fdb.KeyValue, error)
+	// Snapshot returns a view whose reads do not add conflict ranges
+	Snapshot() KvReadTransaction
+}
+
+// KvTransaction is a read-write view of a store, writes are visible to its own reads
+type KvTransaction interface {
+	KvReadTransaction
+	TxSetter
+	Clear(key fdb.KeyConvertible)
+	ClearRange(r fdb.ExactRange)
+	// Add adds little-endian integer param to the value of key
+	Add(key fdb.KeyConvertible, param []byte)
+	// Max stores the larger of little-endian integers param and value of key
+	Max(key fdb.KeyConvertible, param []byte)
+	// Min stores the smaller of little-endian integers param and value of key
+	Min(key fdb.KeyConvertible, param []byte)
+	// SetVersionstampedKey sets key with its 10 byte placeholder replaced by the versionstamp of the commit. Key
+	// ends with little-endian uint32 position of the placeholder, the way fdb API 520 and newer expect it. Such
+	// keys are not visible to reads of the transaction that sets them.
+	SetVersionstampedKey(key fdb.KeyConvertible, value []byte)
+}
+
 func WriteBlock(setter TxSetter, getter NarrowGetter, key fdb.Key, op writeOp) (ret int, err error) {
 	partial := len(op.what) != op.pageSize
 	if len(op.what)+op.offset > op.pageSize {
@@ -43,21 +138,18 @@ func WriteBlock(setter TxSetter, getter NarrowGetter, key fdb.Key, op writeOp) (
 
 		var data []byte
 		data, err = getter.Get(key).Get()
+		if err != nil {
+			return 0, err
+		}
 
-		var buff []byte
-
-		switch {
-		//trim C
-		case op.offset == 0:
-			buff = op.what
-			ret = len(op.what)
-			break
-		default: // A,B,D,E
-			buff = make([]byte, len(op.what)+op.offset)
-			copy(buff, data[0:op.offset])
-			ret = copy(buff[op.offset:], op.what)
-			break
+		// bytes of a bucket outside of op are preserved, bucket is zero-extended when op ends past it
+		size := len(data)
+		if size < len(op.what)+op.offset {
+			size = len(op.what) + op.offset
 		}
+		buff := make([]byte, size)
+		copy(buff, data)
+		ret = copy(buff[op.offset:], op.what)
 
 		setter.Set(key, buff)
 	}
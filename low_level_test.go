@@ -77,12 +77,12 @@ func ExampleWriteBlock() {
 	// C
 	// ----- <- pageSize
 	// ----- <- data
-	// --    <- op.what --> trim?
+	// --    <- op.what --> overlay, tail of data stays
 
 	// D
 	// ----- <- pageSize
 	// ----- <- data
-	//  --   <- op.what --> trim 2 & combine
+	//  --   <- op.what --> overlay, head & tail of data stay
 
 	// E
 	// ----- <- pageSize
@@ -105,9 +105,9 @@ func ExampleWriteBlock() {
 
 	// Output:
 	// 3,<nil>,R:false,<nil>,W:&{[222 173] [3 4 5]}
-	// 2,<nil>,R:true,\xde\xad,W:&{[222 173] [4 5]}
+	// 2,<nil>,R:true,\xde\xad,W:&{[222 173] [4 5 2]}
 	// 2,<nil>,R:true,\xde\xad,W:&{[222 173] [0 4 5]}
-	// 1,<nil>,R:true,\xde\xad,W:&{[222 173] [0 5]}
+	// 1,<nil>,R:true,\xde\xad,W:&{[222 173] [0 5 2]}
 	// 0,error_wrong_write_size Size:3 Want:4,R:false,<nil>,W:&{<nil> []}
 
 }
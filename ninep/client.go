@@ -0,0 +1,309 @@
+package ninep
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// DefaultMsize is the message size client asks for
+const DefaultMsize = 1 << 20
+
+// ErrClosed is returned for requests of a closed client
+var ErrClosed = errors.New("9p client closed")
+
+// Client sends requests over a connection, requests may be sent concurrently. Fids are chosen by callers.
+type Client struct {
+	conn  net.Conn
+	msize uint32
+
+	// writeMu serializes writes of requests
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan Message
+	nextTag uint16
+	err     error
+}
+
+// NewClient negotiates version and message size with server on conn
+func NewClient(conn net.Conn, msize uint32) (*Client, error) {
+	if err := WriteMessage(conn, NoTag, &Tversion{Msize: msize, Version: Version}); err != nil {
+		return nil, err
+	}
+	_, m, err := ReadMessage(conn, msize)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := m.(*Rversion)
+	if !ok || r.Version != Version {
+		return nil, fmt.Errorf("error_unsupported_version %v", m)
+	}
+	if r.Msize < msize {
+		msize = r.Msize
+	}
+
+	c := &Client{conn: conn, msize: msize, pending: map[uint16]chan Message{}}
+	go c.read()
+	return c, nil
+}
+
+// Msize is the negotiated message size
+func (c *Client) Msize() uint32 {
+	return c.msize
+}
+
+// Close closes connection and fails pending requests
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// read dispatches replies to requests by tag until connection fails
+func (c *Client) read() {
+	for {
+		tag, m, err := ReadMessage(c.conn, c.msize)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch := c.pending[tag]
+		delete(c.pending, tag)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+}
+
+func (c *Client) fail(err error) {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		err = ErrClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	for tag, ch := range c.pending {
+		close(ch)
+		delete(c.pending, tag)
+	}
+}
+
+// RPC sends request and waits for its reply, Rlerror is returned as syscall.Errno
+func (c *Client) RPC(request Message) (Message, error) {
+	ch := make(chan Message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	tag := c.nextTag
+	for _, used := c.pending[tag]; used || tag == NoTag; _, used = c.pending[tag] {
+		tag++
+	}
+	c.nextTag = tag + 1
+	c.pending[tag] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err := WriteMessage(c.conn, tag, request)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, tag)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	reply, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	if e, ok := reply.(*Rlerror); ok {
+		return nil, syscall.Errno(e.Ecode)
+	}
+	if reply.typ() != request.typ()+1 {
+		return nil, fmt.Errorf("error_unexpected_reply %T to %T", reply, request)
+	}
+	return reply, nil
+}
+
+// Attach binds fid to the root of tree aname as user uname
+func (c *Client) Attach(fid uint32, uname string, aname string) (Qid, error) {
+	r, err := c.RPC(&Tattach{Fid: fid, Afid: NoFid, Uname: uname, Aname: aname, NUname: NoFid})
+	if err != nil {
+		return Qid{}, err
+	}
+	return r.(*Rattach).Qid, nil
+}
+
+// Walk walks names from fid to newfid, it fails unless all names are walked
+func (c *Client) Walk(fid uint32, newfid uint32, names ...string) ([]Qid, error) {
+	r, err := c.RPC(&Twalk{Fid: fid, NewFid: newfid, Names: names})
+	if err != nil {
+		return nil, err
+	}
+	qids := r.(*Rwalk).Qids
+	if len(qids) != len(names) {
+		return qids, syscall.ENOENT
+	}
+	return qids, nil
+}
+
+// Open opens fid with Linux open flags and returns its iounit
+func (c *Client) Open(fid uint32, flags uint32) (Qid, uint32, error) {
+	r, err := c.RPC(&Tlopen{Fid: fid, Flags: flags})
+	if err != nil {
+		return Qid{}, 0, err
+	}
+	return r.(*Rlopen).Qid, r.(*Rlopen).Iounit, nil
+}
+
+// Create creates and opens file name in directory fid, fid becomes the file
+func (c *Client) Create(fid uint32, name string, flags uint32, mode uint32) (Qid, uint32, error) {
+	r, err := c.RPC(&Tlcreate{Fid: fid, Name: name, Flags: flags, Mode: mode})
+	if err != nil {
+		return Qid{}, 0, err
+	}
+	return r.(*Rlcreate).Qid, r.(*Rlcreate).Iounit, nil
+}
+
+// Mkdir creates directory name in directory fid
+func (c *Client) Mkdir(fid uint32, name string, mode uint32) (Qid, error) {
+	r, err := c.RPC(&Tmkdir{DirFid: fid, Name: name, Mode: mode})
+	if err != nil {
+		return Qid{}, err
+	}
+	return r.(*Rmkdir).Qid, nil
+}
+
+// Symlink creates symlink name to target in directory fid
+func (c *Client) Symlink(fid uint32, name string, target string) (Qid, error) {
+	r, err := c.RPC(&Tsymlink{Fid: fid, Name: name, Target: target})
+	if err != nil {
+		return Qid{}, err
+	}
+	return r.(*Rsymlink).Qid, nil
+}
+
+// Readlink reads target of symlink fid
+func (c *Client) Readlink(fid uint32) (string, error) {
+	r, err := c.RPC(&Treadlink{Fid: fid})
+	if err != nil {
+		return "", err
+	}
+	return r.(*Rreadlink).Target, nil
+}
+
+// ReadAt reads into p at off, reads larger than iounit are split
+func (c *Client) ReadAt(fid uint32, p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		count := len(p) - n
+		if max := int(c.msize - IOHeaderSize); count > max {
+			count = max
+		}
+		r, err := c.RPC(&Tread{Fid: fid, Offset: uint64(off) + uint64(n), Count: uint32(count)})
+		if err != nil {
+			return n, err
+		}
+		data := r.(*Rread).Data
+		if len(data) == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data)
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off, writes larger than iounit are split
+func (c *Client) WriteAt(fid uint32, p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		count := len(p) - n
+		if max := int(c.msize - IOHeaderSize); count > max {
+			count = max
+		}
+		r, err := c.RPC(&Twrite{Fid: fid, Offset: uint64(off) + uint64(n), Data: p[n : n+count]})
+		if err != nil {
+			return n, err
+		}
+		written := int(r.(*Rwrite).Count)
+		if written == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += written
+	}
+	return n, nil
+}
+
+// Readdir reads all entries of opened directory fid
+func (c *Client) Readdir(fid uint32) ([]Dirent, error) {
+	var entries []Dirent
+	offset := uint64(0)
+	for {
+		r, err := c.RPC(&Treaddir{Fid: fid, Offset: offset, Count: c.msize - IOHeaderSize})
+		if err != nil {
+			return entries, err
+		}
+		page := r.(*Rreaddir).Entries
+		if len(page) == 0 {
+			return entries, nil
+		}
+		entries = append(entries, page...)
+		offset = page[len(page)-1].Offset
+	}
+}
+
+// Getattr reads attributes of mask
+func (c *Client) Getattr(fid uint32, mask uint64) (*Rgetattr, error) {
+	r, err := c.RPC(&Tgetattr{Fid: fid, RequestMask: mask})
+	if err != nil {
+		return nil, err
+	}
+	return r.(*Rgetattr), nil
+}
+
+// Setattr changes attributes selected by Valid of attr, Fid of attr is set to fid
+func (c *Client) Setattr(fid uint32, attr Tsetattr) error {
+	attr.Fid = fid
+	_, err := c.RPC(&attr)
+	return err
+}
+
+// Renameat moves oldname of directory olddir to newname of directory newdir
+func (c *Client) Renameat(olddir uint32, oldname string, newdir uint32, newname string) error {
+	_, err := c.RPC(&Trenameat{OldDirFid: olddir, OldName: oldname, NewDirFid: newdir, NewName: newname})
+	return err
+}
+
+// Unlinkat removes name of directory dir, flags may be AtRemoveDir
+func (c *Client) Unlinkat(dir uint32, name string, flags uint32) error {
+	_, err := c.RPC(&Tunlinkat{DirFid: dir, Name: name, Flags: flags})
+	return err
+}
+
+// Fsync flushes fid
+func (c *Client) Fsync(fid uint32) error {
+	_, err := c.RPC(&Tfsync{Fid: fid})
+	return err
+}
+
+// Remove removes file of fid and clunks it
+func (c *Client) Remove(fid uint32) error {
+	_, err := c.RPC(&Tremove{Fid: fid})
+	return err
+}
+
+// Clunk forgets fid
+func (c *Client) Clunk(fid uint32) error {
+	_, err := c.RPC(&Tclunk{Fid: fid})
+	return err
+}
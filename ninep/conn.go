@@ -0,0 +1,52 @@
+package ninep
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ReadMessage reads a message of at most msize bytes. Fields past the end of known ones are ignored, newer
+// protocol revisions append them. Messages of unknown types are skipped and reported with UnknownMessageError, the
+// stream stays usable.
+func ReadMessage(r io.Reader, msize uint32) (uint16, Message, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size < headerSize || size > msize {
+		return 0, nil, fmt.Errorf("error_message_size Size:%v Msize:%v", size, msize)
+	}
+	typ, tag := header[4], binary.LittleEndian.Uint16(header[5:])
+
+	body := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	create, ok := messages[typ]
+	if !ok {
+		return tag, nil, &UnknownMessageError{Type: typ, Tag: tag}
+	}
+	m := create()
+	d := decoder{b: body}
+	m.decode(&d)
+	if d.err != nil {
+		return tag, nil, d.err
+	}
+
+	return tag, m, nil
+}
+
+// WriteMessage writes m in a single write
+func WriteMessage(w io.Writer, tag uint16, m Message) error {
+	e := encoder{b: make([]byte, headerSize, 64)}
+	m.encode(&e)
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	e.b[4] = m.typ()
+	binary.LittleEndian.PutUint16(e.b[5:], tag)
+
+	_, err := w.Write(e.b)
+	return err
+}
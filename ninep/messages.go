@@ -0,0 +1,863 @@
+package ninep
+
+import "fmt"
+
+// Message types
+const (
+	msgRlerror   = 7
+	msgTstatfs   = 8
+	msgRstatfs   = 9
+	msgTlopen    = 12
+	msgRlopen    = 13
+	msgTlcreate  = 14
+	msgRlcreate  = 15
+	msgTsymlink  = 16
+	msgRsymlink  = 17
+	msgTrename   = 20
+	msgRrename   = 21
+	msgTreadlink = 22
+	msgRreadlink = 23
+	msgTgetattr  = 24
+	msgRgetattr  = 25
+	msgTsetattr  = 26
+	msgRsetattr  = 27
+	msgTreaddir  = 40
+	msgRreaddir  = 41
+	msgTfsync    = 50
+	msgRfsync    = 51
+	msgTmkdir    = 72
+	msgRmkdir    = 73
+	msgTrenameat = 74
+	msgRrenameat = 75
+	msgTunlinkat = 76
+	msgRunlinkat = 77
+	msgTversion  = 100
+	msgRversion  = 101
+	msgTattach   = 104
+	msgRattach   = 105
+	msgTflush    = 108
+	msgRflush    = 109
+	msgTwalk     = 110
+	msgRwalk     = 111
+	msgTread     = 116
+	msgRread     = 117
+	msgTwrite    = 118
+	msgRwrite    = 119
+	msgTclunk    = 120
+	msgRclunk    = 121
+	msgTremove   = 122
+	msgRremove   = 123
+)
+
+// Message is a 9P2000.L message
+type Message interface {
+	typ() uint8
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+// messages creates empty messages by type, types missing here are answered with ENOSYS by servers
+var messages = map[uint8]func() Message{
+	msgRlerror:   func() Message { return &Rlerror{} },
+	msgTstatfs:   func() Message { return &Tstatfs{} },
+	msgRstatfs:   func() Message { return &Rstatfs{} },
+	msgTlopen:    func() Message { return &Tlopen{} },
+	msgRlopen:    func() Message { return &Rlopen{} },
+	msgTlcreate:  func() Message { return &Tlcreate{} },
+	msgRlcreate:  func() Message { return &Rlcreate{} },
+	msgTsymlink:  func() Message { return &Tsymlink{} },
+	msgRsymlink:  func() Message { return &Rsymlink{} },
+	msgTrename:   func() Message { return &Trename{} },
+	msgRrename:   func() Message { return &Rrename{} },
+	msgTreadlink: func() Message { return &Treadlink{} },
+	msgRreadlink: func() Message { return &Rreadlink{} },
+	msgTgetattr:  func() Message { return &Tgetattr{} },
+	msgRgetattr:  func() Message { return &Rgetattr{} },
+	msgTsetattr:  func() Message { return &Tsetattr{} },
+	msgRsetattr:  func() Message { return &Rsetattr{} },
+	msgTreaddir:  func() Message { return &Treaddir{} },
+	msgRreaddir:  func() Message { return &Rreaddir{} },
+	msgTfsync:    func() Message { return &Tfsync{} },
+	msgRfsync:    func() Message { return &Rfsync{} },
+	msgTmkdir:    func() Message { return &Tmkdir{} },
+	msgRmkdir:    func() Message { return &Rmkdir{} },
+	msgTrenameat: func() Message { return &Trenameat{} },
+	msgRrenameat: func() Message { return &Rrenameat{} },
+	msgTunlinkat: func() Message { return &Tunlinkat{} },
+	msgRunlinkat: func() Message { return &Runlinkat{} },
+	msgTversion:  func() Message { return &Tversion{} },
+	msgRversion:  func() Message { return &Rversion{} },
+	msgTattach:   func() Message { return &Tattach{} },
+	msgRattach:   func() Message { return &Rattach{} },
+	msgTflush:    func() Message { return &Tflush{} },
+	msgRflush:    func() Message { return &Rflush{} },
+	msgTwalk:     func() Message { return &Twalk{} },
+	msgRwalk:     func() Message { return &Rwalk{} },
+	msgTread:     func() Message { return &Tread{} },
+	msgRread:     func() Message { return &Rread{} },
+	msgTwrite:    func() Message { return &Twrite{} },
+	msgRwrite:    func() Message { return &Rwrite{} },
+	msgTclunk:    func() Message { return &Tclunk{} },
+	msgRclunk:    func() Message { return &Rclunk{} },
+	msgTremove:   func() Message { return &Tremove{} },
+	msgRremove:   func() Message { return &Rremove{} },
+}
+
+// UnknownMessageError is returned for messages of types this package does not implement
+type UnknownMessageError struct {
+	Type uint8
+	Tag  uint16
+}
+
+func (e *UnknownMessageError) Error() string {
+	return fmt.Sprintf("unknown 9p message type %d", e.Type)
+}
+
+// Rlerror reports failure of a request with Linux errno
+type Rlerror struct {
+	Ecode uint32
+}
+
+func (*Rlerror) typ() uint8          { return msgRlerror }
+func (m *Rlerror) encode(e *encoder) { e.u32(m.Ecode) }
+func (m *Rlerror) decode(d *decoder) { m.Ecode = d.u32() }
+
+// Tversion negotiates message size and version
+type Tversion struct {
+	Msize   uint32
+	Version string
+}
+
+func (*Tversion) typ() uint8 { return msgTversion }
+
+func (m *Tversion) encode(e *encoder) {
+	e.u32(m.Msize)
+	e.str(m.Version)
+}
+
+func (m *Tversion) decode(d *decoder) {
+	m.Msize = d.u32()
+	m.Version = d.str()
+}
+
+// Rversion is the reply to Tversion, Version is "unknown" when server does not speak requested one
+type Rversion struct {
+	Msize   uint32
+	Version string
+}
+
+func (*Rversion) typ() uint8 { return msgRversion }
+
+func (m *Rversion) encode(e *encoder) {
+	e.u32(m.Msize)
+	e.str(m.Version)
+}
+
+func (m *Rversion) decode(d *decoder) {
+	m.Msize = d.u32()
+	m.Version = d.str()
+}
+
+// Tattach binds fid to the root of tree Aname
+type Tattach struct {
+	Fid    uint32
+	Afid   uint32
+	Uname  string
+	Aname  string
+	NUname uint32
+}
+
+func (*Tattach) typ() uint8 { return msgTattach }
+
+func (m *Tattach) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u32(m.Afid)
+	e.str(m.Uname)
+	e.str(m.Aname)
+	e.u32(m.NUname)
+}
+
+func (m *Tattach) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Afid = d.u32()
+	m.Uname = d.str()
+	m.Aname = d.str()
+	m.NUname = d.u32()
+}
+
+// Rattach returns qid of the root
+type Rattach struct {
+	Qid Qid
+}
+
+func (*Rattach) typ() uint8          { return msgRattach }
+func (m *Rattach) encode(e *encoder) { e.qid(m.Qid) }
+func (m *Rattach) decode(d *decoder) { m.Qid = d.qid() }
+
+// Tflush aborts request with OldTag
+type Tflush struct {
+	OldTag uint16
+}
+
+func (*Tflush) typ() uint8          { return msgTflush }
+func (m *Tflush) encode(e *encoder) { e.u16(m.OldTag) }
+func (m *Tflush) decode(d *decoder) { m.OldTag = d.u16() }
+
+// Rflush is sent once the flushed request is done
+type Rflush struct{}
+
+func (*Rflush) typ() uint8        { return msgRflush }
+func (*Rflush) encode(e *encoder) {}
+func (*Rflush) decode(d *decoder) {}
+
+// Twalk walks Names from Fid and binds the result to NewFid
+type Twalk struct {
+	Fid    uint32
+	NewFid uint32
+	Names  []string
+}
+
+func (*Twalk) typ() uint8 { return msgTwalk }
+
+func (m *Twalk) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u32(m.NewFid)
+	e.u16(uint16(len(m.Names)))
+	for _, name := range m.Names {
+		e.str(name)
+	}
+}
+
+func (m *Twalk) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.NewFid = d.u32()
+	n := d.u16()
+	m.Names = nil
+	for i := uint16(0); i < n && d.err == nil; i++ {
+		m.Names = append(m.Names, d.str())
+	}
+}
+
+// Rwalk returns qids of walked names, fewer of them than names means the walk stopped and NewFid is not bound
+type Rwalk struct {
+	Qids []Qid
+}
+
+func (*Rwalk) typ() uint8 { return msgRwalk }
+
+func (m *Rwalk) encode(e *encoder) {
+	e.u16(uint16(len(m.Qids)))
+	for _, q := range m.Qids {
+		e.qid(q)
+	}
+}
+
+func (m *Rwalk) decode(d *decoder) {
+	n := d.u16()
+	m.Qids = nil
+	for i := uint16(0); i < n && d.err == nil; i++ {
+		m.Qids = append(m.Qids, d.qid())
+	}
+}
+
+// Tlopen opens fid with Linux open flags
+type Tlopen struct {
+	Fid   uint32
+	Flags uint32
+}
+
+func (*Tlopen) typ() uint8 { return msgTlopen }
+
+func (m *Tlopen) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u32(m.Flags)
+}
+
+func (m *Tlopen) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Flags = d.u32()
+}
+
+// Rlopen returns qid and the largest read or write done in a single message
+type Rlopen struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (*Rlopen) typ() uint8 { return msgRlopen }
+
+func (m *Rlopen) encode(e *encoder) {
+	e.qid(m.Qid)
+	e.u32(m.Iounit)
+}
+
+func (m *Rlopen) decode(d *decoder) {
+	m.Qid = d.qid()
+	m.Iounit = d.u32()
+}
+
+// Tlcreate creates file Name in directory Fid, Fid becomes the opened file
+type Tlcreate struct {
+	Fid   uint32
+	Name  string
+	Flags uint32
+	Mode  uint32
+	Gid   uint32
+}
+
+func (*Tlcreate) typ() uint8 { return msgTlcreate }
+
+func (m *Tlcreate) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.str(m.Name)
+	e.u32(m.Flags)
+	e.u32(m.Mode)
+	e.u32(m.Gid)
+}
+
+func (m *Tlcreate) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Name = d.str()
+	m.Flags = d.u32()
+	m.Mode = d.u32()
+	m.Gid = d.u32()
+}
+
+// Rlcreate is Rlopen of the created file
+type Rlcreate struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (*Rlcreate) typ() uint8 { return msgRlcreate }
+
+func (m *Rlcreate) encode(e *encoder) {
+	e.qid(m.Qid)
+	e.u32(m.Iounit)
+}
+
+func (m *Rlcreate) decode(d *decoder) {
+	m.Qid = d.qid()
+	m.Iounit = d.u32()
+}
+
+// Tsymlink creates symlink Name to Target in directory Fid
+type Tsymlink struct {
+	Fid    uint32
+	Name   string
+	Target string
+	Gid    uint32
+}
+
+func (*Tsymlink) typ() uint8 { return msgTsymlink }
+
+func (m *Tsymlink) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.str(m.Name)
+	e.str(m.Target)
+	e.u32(m.Gid)
+}
+
+func (m *Tsymlink) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Name = d.str()
+	m.Target = d.str()
+	m.Gid = d.u32()
+}
+
+// Rsymlink returns qid of the symlink
+type Rsymlink struct {
+	Qid Qid
+}
+
+func (*Rsymlink) typ() uint8          { return msgRsymlink }
+func (m *Rsymlink) encode(e *encoder) { e.qid(m.Qid) }
+func (m *Rsymlink) decode(d *decoder) { m.Qid = d.qid() }
+
+// Trename moves file Fid to Name in directory DirFid
+type Trename struct {
+	Fid    uint32
+	DirFid uint32
+	Name   string
+}
+
+func (*Trename) typ() uint8 { return msgTrename }
+
+func (m *Trename) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u32(m.DirFid)
+	e.str(m.Name)
+}
+
+func (m *Trename) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.DirFid = d.u32()
+	m.Name = d.str()
+}
+
+// Rrename is the reply to Trename
+type Rrename struct{}
+
+func (*Rrename) typ() uint8        { return msgRrename }
+func (*Rrename) encode(e *encoder) {}
+func (*Rrename) decode(d *decoder) {}
+
+// Treadlink reads target of symlink Fid
+type Treadlink struct {
+	Fid uint32
+}
+
+func (*Treadlink) typ() uint8          { return msgTreadlink }
+func (m *Treadlink) encode(e *encoder) { e.u32(m.Fid) }
+func (m *Treadlink) decode(d *decoder) { m.Fid = d.u32() }
+
+// Rreadlink returns target of symlink
+type Rreadlink struct {
+	Target string
+}
+
+func (*Rreadlink) typ() uint8          { return msgRreadlink }
+func (m *Rreadlink) encode(e *encoder) { e.str(m.Target) }
+func (m *Rreadlink) decode(d *decoder) { m.Target = d.str() }
+
+// Tgetattr asks for attributes of RequestMask
+type Tgetattr struct {
+	Fid         uint32
+	RequestMask uint64
+}
+
+func (*Tgetattr) typ() uint8 { return msgTgetattr }
+
+func (m *Tgetattr) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u64(m.RequestMask)
+}
+
+func (m *Tgetattr) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.RequestMask = d.u64()
+}
+
+// Rgetattr returns attributes, Valid tells which of them are set
+type Rgetattr struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	UID         uint32
+	GID         uint32
+	Nlink       uint64
+	Rdev        uint64
+	Size        uint64
+	BlockSize   uint64
+	Blocks      uint64
+	AtimeSec    uint64
+	AtimeNsec   uint64
+	MtimeSec    uint64
+	MtimeNsec   uint64
+	CtimeSec    uint64
+	CtimeNsec   uint64
+	BtimeSec    uint64
+	BtimeNsec   uint64
+	Gen         uint64
+	DataVersion uint64
+}
+
+func (*Rgetattr) typ() uint8 { return msgRgetattr }
+
+func (m *Rgetattr) encode(e *encoder) {
+	e.u64(m.Valid)
+	e.qid(m.Qid)
+	e.u32(m.Mode)
+	e.u32(m.UID)
+	e.u32(m.GID)
+	for _, v := range []uint64{m.Nlink, m.Rdev, m.Size, m.BlockSize, m.Blocks, m.AtimeSec, m.AtimeNsec,
+		m.MtimeSec, m.MtimeNsec, m.CtimeSec, m.CtimeNsec, m.BtimeSec, m.BtimeNsec, m.Gen, m.DataVersion} {
+		e.u64(v)
+	}
+}
+
+func (m *Rgetattr) decode(d *decoder) {
+	m.Valid = d.u64()
+	m.Qid = d.qid()
+	m.Mode = d.u32()
+	m.UID = d.u32()
+	m.GID = d.u32()
+	for _, v := range []*uint64{&m.Nlink, &m.Rdev, &m.Size, &m.BlockSize, &m.Blocks, &m.AtimeSec, &m.AtimeNsec,
+		&m.MtimeSec, &m.MtimeNsec, &m.CtimeSec, &m.CtimeNsec, &m.BtimeSec, &m.BtimeNsec, &m.Gen, &m.DataVersion} {
+		*v = d.u64()
+	}
+}
+
+// Tsetattr changes attributes, Valid tells which of them to change
+type Tsetattr struct {
+	Fid       uint32
+	Valid     uint32
+	Mode      uint32
+	UID       uint32
+	GID       uint32
+	Size      uint64
+	AtimeSec  uint64
+	AtimeNsec uint64
+	MtimeSec  uint64
+	MtimeNsec uint64
+}
+
+func (*Tsetattr) typ() uint8 { return msgTsetattr }
+
+func (m *Tsetattr) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u32(m.Valid)
+	e.u32(m.Mode)
+	e.u32(m.UID)
+	e.u32(m.GID)
+	e.u64(m.Size)
+	e.u64(m.AtimeSec)
+	e.u64(m.AtimeNsec)
+	e.u64(m.MtimeSec)
+	e.u64(m.MtimeNsec)
+}
+
+func (m *Tsetattr) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Valid = d.u32()
+	m.Mode = d.u32()
+	m.UID = d.u32()
+	m.GID = d.u32()
+	m.Size = d.u64()
+	m.AtimeSec = d.u64()
+	m.AtimeNsec = d.u64()
+	m.MtimeSec = d.u64()
+	m.MtimeNsec = d.u64()
+}
+
+// Rsetattr is the reply to Tsetattr
+type Rsetattr struct{}
+
+func (*Rsetattr) typ() uint8        { return msgRsetattr }
+func (*Rsetattr) encode(e *encoder) {}
+func (*Rsetattr) decode(d *decoder) {}
+
+// Treaddir reads entries of opened directory starting at Offset, which is 0 or offset of a returned entry
+type Treaddir struct {
+	Fid    uint32
+	Offset uint64
+	Count  uint32
+}
+
+func (*Treaddir) typ() uint8 { return msgTreaddir }
+
+func (m *Treaddir) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u64(m.Offset)
+	e.u32(m.Count)
+}
+
+func (m *Treaddir) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Offset = d.u64()
+	m.Count = d.u32()
+}
+
+// Rreaddir returns entries fitting into Count of the request, no entries means the end of directory
+type Rreaddir struct {
+	Entries []Dirent
+}
+
+func (*Rreaddir) typ() uint8 { return msgRreaddir }
+
+func (m *Rreaddir) encode(e *encoder) {
+	var data encoder
+	for _, entry := range m.Entries {
+		entry.encode(&data)
+	}
+	e.u32(uint32(len(data.b)))
+	e.b = append(e.b, data.b...)
+}
+
+func (m *Rreaddir) decode(d *decoder) {
+	data := decoder{b: d.bytes(d.u32())}
+	m.Entries = nil
+	for len(data.b) > 0 && data.err == nil {
+		var entry Dirent
+		entry.decode(&data)
+		m.Entries = append(m.Entries, entry)
+	}
+	if d.err == nil {
+		d.err = data.err
+	}
+}
+
+// Size is the encoded size of entry
+func (entry Dirent) Size() int {
+	return 13 + 8 + 1 + 2 + len(entry.Name)
+}
+
+func (entry Dirent) encode(e *encoder) {
+	e.qid(entry.Qid)
+	e.u64(entry.Offset)
+	e.u8(entry.Type)
+	e.str(entry.Name)
+}
+
+func (entry *Dirent) decode(d *decoder) {
+	entry.Qid = d.qid()
+	entry.Offset = d.u64()
+	entry.Type = d.u8()
+	entry.Name = d.str()
+}
+
+// Tfsync flushes file to stable storage
+type Tfsync struct {
+	Fid uint32
+}
+
+func (*Tfsync) typ() uint8          { return msgTfsync }
+func (m *Tfsync) encode(e *encoder) { e.u32(m.Fid) }
+func (m *Tfsync) decode(d *decoder) { m.Fid = d.u32() }
+
+// Rfsync is the reply to Tfsync
+type Rfsync struct{}
+
+func (*Rfsync) typ() uint8        { return msgRfsync }
+func (*Rfsync) encode(e *encoder) {}
+func (*Rfsync) decode(d *decoder) {}
+
+// Tmkdir creates directory Name in directory DirFid
+type Tmkdir struct {
+	DirFid uint32
+	Name   string
+	Mode   uint32
+	Gid    uint32
+}
+
+func (*Tmkdir) typ() uint8 { return msgTmkdir }
+
+func (m *Tmkdir) encode(e *encoder) {
+	e.u32(m.DirFid)
+	e.str(m.Name)
+	e.u32(m.Mode)
+	e.u32(m.Gid)
+}
+
+func (m *Tmkdir) decode(d *decoder) {
+	m.DirFid = d.u32()
+	m.Name = d.str()
+	m.Mode = d.u32()
+	m.Gid = d.u32()
+}
+
+// Rmkdir returns qid of the directory
+type Rmkdir struct {
+	Qid Qid
+}
+
+func (*Rmkdir) typ() uint8          { return msgRmkdir }
+func (m *Rmkdir) encode(e *encoder) { e.qid(m.Qid) }
+func (m *Rmkdir) decode(d *decoder) { m.Qid = d.qid() }
+
+// Trenameat moves OldName of OldDirFid to NewName of NewDirFid
+type Trenameat struct {
+	OldDirFid uint32
+	OldName   string
+	NewDirFid uint32
+	NewName   string
+}
+
+func (*Trenameat) typ() uint8 { return msgTrenameat }
+
+func (m *Trenameat) encode(e *encoder) {
+	e.u32(m.OldDirFid)
+	e.str(m.OldName)
+	e.u32(m.NewDirFid)
+	e.str(m.NewName)
+}
+
+func (m *Trenameat) decode(d *decoder) {
+	m.OldDirFid = d.u32()
+	m.OldName = d.str()
+	m.NewDirFid = d.u32()
+	m.NewName = d.str()
+}
+
+// Rrenameat is the reply to Trenameat
+type Rrenameat struct{}
+
+func (*Rrenameat) typ() uint8        { return msgRrenameat }
+func (*Rrenameat) encode(e *encoder) {}
+func (*Rrenameat) decode(d *decoder) {}
+
+// Tunlinkat removes Name of DirFid, AtRemoveDir flag removes an empty directory
+type Tunlinkat struct {
+	DirFid uint32
+	Name   string
+	Flags  uint32
+}
+
+func (*Tunlinkat) typ() uint8 { return msgTunlinkat }
+
+func (m *Tunlinkat) encode(e *encoder) {
+	e.u32(m.DirFid)
+	e.str(m.Name)
+	e.u32(m.Flags)
+}
+
+func (m *Tunlinkat) decode(d *decoder) {
+	m.DirFid = d.u32()
+	m.Name = d.str()
+	m.Flags = d.u32()
+}
+
+// Runlinkat is the reply to Tunlinkat
+type Runlinkat struct{}
+
+func (*Runlinkat) typ() uint8        { return msgRunlinkat }
+func (*Runlinkat) encode(e *encoder) {}
+func (*Runlinkat) decode(d *decoder) {}
+
+// Tstatfs asks for statistics of filesystem of Fid
+type Tstatfs struct {
+	Fid uint32
+}
+
+func (*Tstatfs) typ() uint8          { return msgTstatfs }
+func (m *Tstatfs) encode(e *encoder) { e.u32(m.Fid) }
+func (m *Tstatfs) decode(d *decoder) { m.Fid = d.u32() }
+
+// Rstatfs returns fields of statfs(2)
+type Rstatfs struct {
+	Type    uint32
+	BSize   uint32
+	Blocks  uint64
+	BFree   uint64
+	BAvail  uint64
+	Files   uint64
+	FFree   uint64
+	FsID    uint64
+	NameLen uint32
+}
+
+func (*Rstatfs) typ() uint8 { return msgRstatfs }
+
+func (m *Rstatfs) encode(e *encoder) {
+	e.u32(m.Type)
+	e.u32(m.BSize)
+	e.u64(m.Blocks)
+	e.u64(m.BFree)
+	e.u64(m.BAvail)
+	e.u64(m.Files)
+	e.u64(m.FFree)
+	e.u64(m.FsID)
+	e.u32(m.NameLen)
+}
+
+func (m *Rstatfs) decode(d *decoder) {
+	m.Type = d.u32()
+	m.BSize = d.u32()
+	m.Blocks = d.u64()
+	m.BFree = d.u64()
+	m.BAvail = d.u64()
+	m.Files = d.u64()
+	m.FFree = d.u64()
+	m.FsID = d.u64()
+	m.NameLen = d.u32()
+}
+
+// Tread reads up to Count bytes at Offset
+type Tread struct {
+	Fid    uint32
+	Offset uint64
+	Count  uint32
+}
+
+func (*Tread) typ() uint8 { return msgTread }
+
+func (m *Tread) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u64(m.Offset)
+	e.u32(m.Count)
+}
+
+func (m *Tread) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Offset = d.u64()
+	m.Count = d.u32()
+}
+
+// Rread returns read data, empty data is the end of file
+type Rread struct {
+	Data []byte
+}
+
+func (*Rread) typ() uint8 { return msgRread }
+
+func (m *Rread) encode(e *encoder) {
+	e.u32(uint32(len(m.Data)))
+	e.b = append(e.b, m.Data...)
+}
+
+func (m *Rread) decode(d *decoder) {
+	m.Data = d.bytes(d.u32())
+}
+
+// Twrite writes Data at Offset
+type Twrite struct {
+	Fid    uint32
+	Offset uint64
+	Data   []byte
+}
+
+func (*Twrite) typ() uint8 { return msgTwrite }
+
+func (m *Twrite) encode(e *encoder) {
+	e.u32(m.Fid)
+	e.u64(m.Offset)
+	e.u32(uint32(len(m.Data)))
+	e.b = append(e.b, m.Data...)
+}
+
+func (m *Twrite) decode(d *decoder) {
+	m.Fid = d.u32()
+	m.Offset = d.u64()
+	m.Data = d.bytes(d.u32())
+}
+
+// Rwrite returns number of written bytes
+type Rwrite struct {
+	Count uint32
+}
+
+func (*Rwrite) typ() uint8          { return msgRwrite }
+func (m *Rwrite) encode(e *encoder) { e.u32(m.Count) }
+func (m *Rwrite) decode(d *decoder) { m.Count = d.u32() }
+
+// Tclunk forgets fid
+type Tclunk struct {
+	Fid uint32
+}
+
+func (*Tclunk) typ() uint8          { return msgTclunk }
+func (m *Tclunk) encode(e *encoder) { e.u32(m.Fid) }
+func (m *Tclunk) decode(d *decoder) { m.Fid = d.u32() }
+
+// Rclunk is the reply to Tclunk
+type Rclunk struct{}
+
+func (*Rclunk) typ() uint8        { return msgRclunk }
+func (*Rclunk) encode(e *encoder) {}
+func (*Rclunk) decode(d *decoder) {}
+
+// Tremove removes file of fid and clunks the fid even when removal fails
+type Tremove struct {
+	Fid uint32
+}
+
+func (*Tremove) typ() uint8          { return msgTremove }
+func (m *Tremove) encode(e *encoder) { e.u32(m.Fid) }
+func (m *Tremove) decode(d *decoder) { m.Fid = d.u32() }
+
+// Rremove is the reply to Tremove
+type Rremove struct{}
+
+func (*Rremove) typ() uint8        { return msgRremove }
+func (*Rremove) encode(e *encoder) {}
+func (*Rremove) decode(d *decoder) {}
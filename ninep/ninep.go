@@ -0,0 +1,97 @@
+// Package ninep implements 9P2000.L messages, their wire encoding and a client.
+//
+// Messages are Go structs named after the protocol, T-messages are requests and R-messages are replies. Errors are
+// reported with Rlerror carrying a Linux errno, Client returns them as syscall.Errno.
+package ninep
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Version is the protocol version negotiated by Tversion
+const Version = "9P2000.L"
+
+// NoFid is the fid of no file, it is the afid of unauthenticated attach
+const NoFid = ^uint32(0)
+
+// NoTag is the tag of Tversion
+const NoTag = ^uint16(0)
+
+// headerSize is size[4] type[1] tag[2]
+const headerSize = 7
+
+// IOHeaderSize is the overhead of Rread and Twrite, iounit is msize less it
+const IOHeaderSize = 24
+
+// Qid types
+const (
+	QTDir     = 0x80
+	QTSymlink = 0x02
+	QTFile    = 0x00
+)
+
+// Qid identifies a file on a server
+type Qid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+func (q Qid) String() string {
+	return fmt.Sprintf("(%x %d %x)", q.Path, q.Version, q.Type)
+}
+
+// Getattr request mask and valid bits of Rgetattr
+const (
+	GetattrMode        = 0x00000001
+	GetattrNlink       = 0x00000002
+	GetattrUID         = 0x00000004
+	GetattrGID         = 0x00000008
+	GetattrRdev        = 0x00000010
+	GetattrAtime       = 0x00000020
+	GetattrMtime       = 0x00000040
+	GetattrCtime       = 0x00000080
+	GetattrIno         = 0x00000100
+	GetattrSize        = 0x00000200
+	GetattrBlocks      = 0x00000400
+	GetattrBasic       = 0x000007ff
+	GetattrBtime       = 0x00000800
+	GetattrGen         = 0x00001000
+	GetattrDataVersion = 0x00002000
+	GetattrAll         = 0x00003fff
+)
+
+// Setattr valid bits
+const (
+	SetattrMode     = 0x00000001
+	SetattrUID      = 0x00000002
+	SetattrGID      = 0x00000004
+	SetattrSize     = 0x00000008
+	SetattrAtime    = 0x00000010
+	SetattrMtime    = 0x00000020
+	SetattrCtime    = 0x00000040
+	SetattrAtimeSet = 0x00000080
+	SetattrMtimeSet = 0x00000100
+)
+
+// AtRemoveDir is the flag of Tunlinkat removing a directory
+const AtRemoveDir = 0x200
+
+// Dirent types of Rreaddir entries
+const (
+	DTDir  = 4
+	DTReg  = 8
+	DTLink = 10
+)
+
+// Dirent is an entry of Rreaddir, Offset is the offset to continue reading after it
+type Dirent struct {
+	Qid    Qid
+	Offset uint64
+	Type   uint8
+	Name   string
+}
+
+// ErrShortMessage is returned for messages ending before their fields
+var ErrShortMessage = errors.New("short 9p message")
@@ -0,0 +1,59 @@
+package ninep
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, m := range []Message{
+		&Tversion{Msize: 8192, Version: Version},
+		&Tattach{Fid: 1, Afid: NoFid, Uname: "user", Aname: "/export", NUname: 1000},
+		&Twalk{Fid: 1, NewFid: 2, Names: []string{"a", "b"}},
+		&Rwalk{Qids: []Qid{{Type: QTDir, Path: 1}, {Type: QTFile, Version: 2, Path: 3}}},
+		&Tlcreate{Fid: 2, Name: "file", Flags: 0x42, Mode: 0644, Gid: 100},
+		&Rgetattr{Valid: GetattrBasic, Qid: Qid{Path: 7}, Mode: 0100644, Size: 10, MtimeSec: 5, DataVersion: 9},
+		&Tsetattr{Fid: 2, Valid: SetattrSize | SetattrMtime, Size: 4, MtimeNsec: 7},
+		&Rreaddir{Entries: []Dirent{{Qid: Qid{Path: 1}, Offset: 1, Type: DTDir, Name: "dir"},
+			{Qid: Qid{Path: 2}, Offset: 2, Type: DTReg, Name: "file"}}},
+		&Twrite{Fid: 2, Offset: 3, Data: []byte("data")},
+		&Rread{Data: []byte("data")},
+		&Trenameat{OldDirFid: 1, OldName: "a", NewDirFid: 2, NewName: "b"},
+		&Rlerror{Ecode: 2},
+		&Rflush{},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, WriteMessage(&buf, 5, m))
+		tag, read, err := ReadMessage(&buf, 8192)
+		require.NoError(t, err, "%T", m)
+		assert.Equal(t, uint16(5), tag)
+		assert.Equal(t, m, read)
+		assert.Zero(t, buf.Len(), "%T is read whole", m)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteMessage(&buf, 1, &Twrite{Fid: 1, Data: make([]byte, 100)}))
+	_, _, err := ReadMessage(&buf, 64)
+	assert.Error(t, err, "Messages larger than msize are rejected")
+
+	buf.Reset()
+	buf.Write([]byte{9, 0, 0, 0, 200, 3, 0, 0, 0})
+	require.NoError(t, WriteMessage(&buf, 4, &Tclunk{Fid: 1}))
+	tag, _, err := ReadMessage(&buf, 64)
+	assert.Equal(t, &UnknownMessageError{Type: 200, Tag: 3}, err)
+	assert.Equal(t, uint16(3), tag)
+	tag, m, err := ReadMessage(&buf, 64)
+	require.NoError(t, err, "Unknown messages are skipped")
+	assert.Equal(t, uint16(4), tag)
+	assert.Equal(t, &Tclunk{Fid: 1}, m)
+
+	buf.Reset()
+	buf.Write([]byte{9, 0, 0, 0, msgTclunk, 1, 0, 0, 0})
+	_, _, err = ReadMessage(&buf, 64)
+	assert.Equal(t, ErrShortMessage, err)
+}
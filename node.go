@@ -0,0 +1,688 @@
+package billyfs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Every file or directory is a node with a numeric id that never changes. Node keys (meta and data buckets)
+// live in ("n", id) subspace, directory entries map ("e", parent id, name) to child id. Ids are random positive
+// integers, root directory is node 0 and exists implicitly.
+const (
+	rootNode int64 = 0
+	noNode   int64 = -1
+)
+
+// meta keys of a node
+var (
+	modeKey   = tuple.Tuple{0xFC, 0x00}
+	mtimeKey  = tuple.Tuple{0xFC, 0x01}
+	targetKey = tuple.Tuple{0xFC, 0x02}
+	ownerKey  = tuple.Tuple{0xFC, 0x03}
+	// parentKey points back to the entry node is linked by, as (parent id, name)
+	parentKey = tuple.Tuple{0xFC, 0x04}
+	// versionKey counts content changes of a file, it is a little endian counter bumped atomically
+	versionKey = tuple.Tuple{0xFC, 0x05}
+	// lockKey holds advisory lock of a node, see fileLock
+	lockKey = tuple.Tuple{0xFC, 0x06}
+	// bornKey holds epoch of the latest snapshot when node was allocated, it is missing when there was none
+	bornKey = tuple.Tuple{0xFC, 0x09}
+	// generationKey holds random generation of a node telling it apart from removed nodes of the same id, it is a
+	// little endian uint64, nodes allocated before generations have none and are of generation zero
+	generationKey = tuple.Tuple{0xFC, 0x0A}
+)
+
+// maxSymlinks bounds symlink resolution, same as linux MAXSYMLINKS
+const maxSymlinks = 40
+
+func (fs FoundationDbFs) node(id int64) subspace.Subspace {
+	return fs.root.Sub("n", id)
+}
+
+func (fs FoundationDbFs) entries(parent int64) subspace.Subspace {
+	return fs.root.Sub("e", parent)
+}
+
+// data is the subspace of node data buckets, see findPosition
+func (fs FoundationDbFs) data(id int64) subspace.Subspace {
+	return fs.node(id).Sub(0xFD, 0x00)
+}
+
+// child returns id of named entry in parent or noNode
+func (fs FoundationDbFs) child(r KvReadTransaction, parent int64, name string) (int64, error) {
+	value, err := r.Get(fs.entries(parent).Pack(tuple.Tuple{name})).Get()
+	if err != nil || value == nil {
+		return noNode, err
+	}
+
+	return unpackId(value)
+}
+
+// resolved is an outcome of path resolution. When last path element does not exist id is noNode, parent and
+// name tell where it would be linked.
+type resolved struct {
+	parent int64
+	name   string
+	id     int64
+	mode   os.FileMode
+	// path is the path of node with all symlinks resolved
+	path []string
+}
+
+// resolve walks path elements following symlinks, the last element is followed only if followLast is set.
+// Missing intermediate directories are reported with os.ErrNotExist.
+func (fs FoundationDbFs) resolve(r KvReadTransaction, fsPath []string, followLast bool) (*resolved, error) {
+	hops := 0
+
+walk:
+	for {
+		res := &resolved{parent: noNode, id: rootNode, mode: os.ModeDir | os.ModePerm}
+		for i := range fsPath {
+			last := i == len(fsPath)-1
+			if !res.mode.IsDir() {
+				return nil, syscall.ENOTDIR
+			}
+
+			id, err := fs.child(r, res.id, fsPath[i])
+			if err != nil {
+				return nil, err
+			}
+			if id == noNode {
+				if !last {
+					return nil, os.ErrNotExist
+				}
+				return &resolved{parent: res.id, name: fsPath[i], id: noNode, path: fsPath}, nil
+			}
+
+			mode, err := fs.mode(r, id)
+			if err != nil {
+				return nil, err
+			}
+
+			if mode&os.ModeSymlink != 0 && (!last || followLast) {
+				if hops++; hops > maxSymlinks {
+					return nil, syscall.ELOOP
+				}
+				target, err := fs.target(r, id)
+				if err != nil {
+					return nil, err
+				}
+				if !path.IsAbs(target) {
+					target = path.Join("/", path.Join(fsPath[:i]...), target)
+				}
+				fsPath = fs.split(path.Join(target, path.Join(fsPath[i+1:]...)))
+				if !fs.confines(fsPath) {
+					return nil, os.ErrPermission
+				}
+				continue walk
+			}
+
+			res = &resolved{parent: res.id, name: fsPath[i], id: id, mode: mode}
+		}
+
+		res.path = fsPath
+		return res, nil
+	}
+}
+
+// lookup resolves path to node id following all symlinks
+func (fs FoundationDbFs) lookup(r KvReadTransaction, fsPath []string) (int64, error) {
+	res, err := fs.resolve(r, fsPath, true)
+	if err != nil {
+		return noNode, err
+	}
+	if res.id == noNode {
+		return noNode, os.ErrNotExist
+	}
+
+	return res.id, nil
+}
+
+// mkdirAll creates missing directories of path, visitor is called for every directory of a path
+func (fs FoundationDbFs) mkdirAll(w KvTransaction, fsPath []string, visitor SpaceVisitor) (*opResult, error) {
+	dang := &opResult{Subspace: fs.node(rootNode), id: rootNode}
+
+	for i := range fsPath {
+		res, err := fs.resolve(w, fsPath[0:i+1], true)
+		if err != nil {
+			return nil, err
+		}
+
+		created := res.id == noNode
+		if created {
+			if res.id, err = fs.allocate(w); err != nil {
+				return nil, err
+			}
+			fs.link(w, res.parent, res.name, res.id)
+			fs.changed(w, ChangeCreate, res.id, res.path, nil)
+		} else if !res.mode.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+
+		dang = &opResult{
+			Subspace:   fs.node(res.id),
+			id:         res.id,
+			wasCreated: created,
+		}
+
+		if visitor != nil {
+			visitor.visit(w, dang)
+		}
+	}
+
+	return dang, nil
+}
+
+// link adds entry of id into parent
+func (fs FoundationDbFs) link(w KvTransaction, parent int64, name string, id int64) {
+	w.Set(fs.entries(parent).Pack(tuple.Tuple{name}), tuple.Tuple{id}.Pack())
+	w.Set(fs.node(id).Pack(parentKey), tuple.Tuple{parent, name}.Pack())
+	fs.touch(w, parent, time.Now())
+}
+
+// unlink drops entry from parent, node itself is left intact. Back pointer of the node is left for link to
+// overwrite, nodePath checks it against the entry.
+func (fs FoundationDbFs) unlink(w KvTransaction, parent int64, name string) {
+	w.Clear(fs.entries(parent).Pack(tuple.Tuple{name}))
+	fs.touch(w, parent, time.Now())
+}
+
+// initNode writes meta of freshly allocated node
+func (fs FoundationDbFs) initNode(w KvTransaction, id int64, mode os.FileMode) {
+	fs.setMode(w, id, mode)
+	fs.touch(w, id, time.Now())
+}
+
+func (fs FoundationDbFs) setMode(w KvTransaction, id int64, mode os.FileMode) {
+	bytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bytes, uint32(mode))
+	w.Set(fs.node(id).Pack(modeKey), bytes)
+}
+
+// touch sets modification time of node
+func (fs FoundationDbFs) touch(w KvTransaction, id int64, at time.Time) {
+	bytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bytes, uint64(at.UnixNano()))
+	w.Set(fs.node(id).Pack(mtimeKey), bytes)
+}
+
+// modified records a change of file content, it touches node, bumps its content version and logs the write.
+// Writes of files that are no longer linked are not logged.
+func (fs FoundationDbFs) modified(w KvTransaction, id int64) error {
+	fs.touch(w, id, time.Now())
+
+	one := make([]byte, 8)
+	binary.LittleEndian.PutUint64(one, 1)
+	w.Add(fs.node(id).Pack(versionKey), one)
+
+	// path is read at snapshot, renames of parents do not conflict with writes
+	fsPath, err := fs.nodePath(w.Snapshot(), id)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	fs.changed(w, ChangeWrite, id, fsPath, nil)
+	return nil
+}
+
+func (fs FoundationDbFs) mode(r KvReadTransaction, id int64) (os.FileMode, error) {
+	if id == rootNode {
+		return os.ModeDir | os.ModePerm, nil
+	}
+
+	bytes, err := r.Get(fs.node(id).Pack(modeKey)).Get()
+	if err != nil {
+		return 0, err
+	}
+	if len(bytes) < 4 {
+		return 0, fmt.Errorf("no_file_mode %v", id)
+	}
+
+	return os.FileMode(binary.LittleEndian.Uint32(bytes)), nil
+}
+
+func (fs FoundationDbFs) target(r KvReadTransaction, id int64) (string, error) {
+	bytes, err := r.Get(fs.node(id).Pack(targetKey)).Get()
+	return string(bytes), err
+}
+
+// size of a file is position of the end of its last bucket
+func (fs FoundationDbFs) size(r KvReadTransaction, id int64) (int64, error) {
+	last, err := r.GetRange(fs.data(id), fdb.RangeOptions{Limit: 1, Reverse: true})
+	if err != nil || len(last) == 0 {
+		return 0, err
+	}
+
+	bucket, err := fs.bucket(id, last[0].Key)
+	if err != nil {
+		return 0, err
+	}
+
+	return bucket*rEADSIZE + int64(len(last[0].Value)), nil
+}
+
+// bucket decodes bucket number out of data key
+func (fs FoundationDbFs) bucket(id int64, key fdb.Key) (int64, error) {
+	t, err := fs.data(id).Unpack(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(t) != 1 {
+		return 0, fmt.Errorf("malformed_bucket %v", t)
+	}
+	bucket, ok := t[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("malformed_bucket %v", t)
+	}
+
+	return bucket, nil
+}
+
+// truncate drops buckets past size and cuts or zero-extends the last one, content it drops is kept as a version
+// when file versions are enabled
+func (fs FoundationDbFs) truncate(w KvTransaction, id int64, size int64) error {
+	if _, err := fs.keepVersion(w, id, size); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		w.ClearRange(fs.data(id))
+		return nil
+	}
+
+	last, upper, _ := findPosition(size-1, rEADSIZE)
+	key := fs.node(id).Pack(last)
+	w.ClearRange(fdb.KeyRange{Begin: append(key, 0x00), End: fs.node(id).Pack(upper)})
+
+	value, err := w.Get(key).Get()
+	if err != nil {
+		return err
+	}
+	w.Set(key, resize(value, int((size-1)%rEADSIZE)+1))
+
+	return nil
+}
+
+// stat collects file info of node, name is the name it was looked up by
+func (fs FoundationDbFs) stat(r KvReadTransaction, id int64, n string) (os.FileInfo, error) {
+	mtime := r.Get(fs.node(id).Pack(mtimeKey))
+	if id == rootNode {
+		info := dirFileInfo{name: n, mode: os.ModeDir | os.ModePerm}
+		bytes, err := mtime.Get()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes) == 8 {
+			info.modTime = time.Unix(0, int64(binary.LittleEndian.Uint64(bytes)))
+		}
+		return info, nil
+	}
+
+	version := r.Get(fs.node(id).Pack(versionKey))
+	owner := r.Get(fs.node(id).Pack(ownerKey))
+	generation := r.Get(fs.node(id).Pack(generationKey))
+
+	mode, err := fs.mode(r, id)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &fileInfo{name: n, mode: mode, sys: &NodeStat{Node: id}}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := fs.target(r, id)
+		if err != nil {
+			return nil, err
+		}
+		info.size = int64(len(target))
+	case mode.IsRegular():
+		if info.size, err = fs.size(r, id); err != nil {
+			return nil, err
+		}
+	}
+
+	bytes, err := mtime.Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes) == 8 {
+		info.modTime = time.Unix(0, int64(binary.LittleEndian.Uint64(bytes)))
+	}
+
+	if bytes, err = owner.Get(); err != nil {
+		return nil, err
+	}
+	if len(bytes) == 8 {
+		info.sys.Uid = int(binary.LittleEndian.Uint32(bytes))
+		info.sys.Gid = int(binary.LittleEndian.Uint32(bytes[4:]))
+	}
+
+	if bytes, err = version.Get(); err != nil {
+		return nil, err
+	}
+	if len(bytes) == 8 {
+		info.sys.Version = binary.LittleEndian.Uint64(bytes)
+	}
+
+	if bytes, err = generation.Get(); err != nil {
+		return nil, err
+	}
+	if len(bytes) == 8 {
+		info.sys.Generation = binary.LittleEndian.Uint64(bytes)
+	}
+
+	return info, nil
+}
+
+// generation of node, see generationKey
+func (fs FoundationDbFs) generation(r KvReadTransaction, id int64) (uint64, error) {
+	if id == rootNode {
+		return 0, nil
+	}
+	bytes, err := r.Get(fs.node(id).Pack(generationKey)).Get()
+	if err != nil || len(bytes) != 8 {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(bytes), nil
+}
+
+// entry decodes directory entry of parent into name and child id
+func (fs FoundationDbFs) entry(parent int64, kv fdb.KeyValue) (string, int64, error) {
+	key, err := fs.entries(parent).Unpack(kv.Key)
+	if err != nil {
+		return "", noNode, err
+	}
+	name, ok := key[0].(string)
+	if !ok {
+		return "", noNode, fmt.Errorf("malformed_entry %v", key)
+	}
+	id, err := unpackId(kv.Value)
+
+	return name, id, err
+}
+
+// allocate reserves new node id. Ids are random, so concurrent allocations only conflict when they pick the same
+// id, and a pick is retried when a node with the id exists already.
+func (fs FoundationDbFs) allocate(tx KvTransaction) (int64, error) {
+	for {
+		id, err := randomID()
+		if err != nil {
+			return noNode, err
+		}
+		existing, err := tx.GetRange(fs.node(id), fdb.RangeOptions{Limit: 1})
+		if err != nil {
+			return noNode, err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+
+		if cow, ok := tx.(*cowTransaction); ok {
+			if err = cow.born(id); err != nil {
+				return noNode, err
+			}
+		}
+		generation, err := randomID()
+		if err != nil {
+			return noNode, err
+		}
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(generation))
+		tx.Set(fs.node(id).Pack(generationKey), value)
+		return id, nil
+	}
+}
+
+// randomID picks a positive node id
+func randomID() (int64, error) {
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return noNode, err
+		}
+		if id := int64(binary.BigEndian.Uint64(b) >> 1); id != rootNode {
+			return id, nil
+		}
+	}
+}
+
+// removeTree clears node with all its descendants. Entry of the node in its parent is left to the caller. Nodes
+// a snapshot reaches are retained instead.
+func (fs FoundationDbFs) removeTree(tx KvTransaction, id int64) error {
+	if cow, ok := tx.(*cowTransaction); ok {
+		if retained, err := cow.retain(id); err != nil || retained {
+			return err
+		}
+	}
+
+	entries, err := tx.GetRange(fs.entries(id), fdb.RangeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		_, child, err := fs.entry(id, entries[i])
+		if err != nil {
+			return err
+		}
+		if err = fs.removeTree(tx, child); err != nil {
+			return err
+		}
+	}
+
+	return fs.clearNode(tx, id)
+}
+
+// clearNode clears keys of a node being removed along with its entries in the indexes of versions and range
+// locks, children are removed already
+func (fs FoundationDbFs) clearNode(tx KvTransaction, id int64) error {
+	if err := fs.dropVersions(tx, id); err != nil {
+		return err
+	}
+	if err := fs.clearRanges(tx, id); err != nil {
+		return err
+	}
+	tx.ClearRange(fs.entries(id))
+	tx.ClearRange(fs.node(id))
+	return nil
+}
+
+// removeBatch bounds nodes removed by a single transaction of RemoveAll
+const removeBatch = 1000
+
+// removals holds nodes unlinked by RemoveAll whose trees are not removed yet, ("u", id) each
+func (fs FoundationDbFs) removals() subspace.Subspace {
+	return fs.root.Sub("u")
+}
+
+// ResumeRemovals finishes removal of trees RemoveAll was interrupted in
+func (fs FoundationDbFs) ResumeRemovals() error {
+	pending, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return r.GetRange(fs.removals(), fdb.RangeOptions{})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range pending.([]fdb.KeyValue) {
+		key, err := fs.removals().Unpack(kv.Key)
+		if err != nil {
+			return err
+		}
+		if len(key) != 1 {
+			return fmt.Errorf("malformed_removal %v", key)
+		}
+		id, ok := key[0].(int64)
+		if !ok {
+			return fmt.Errorf("malformed_removal %v", key)
+		}
+		if err = fs.drain(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain removes tree of node pending in removals batch by batch, removal is finished by whoever gets there first
+func (fs FoundationDbFs) drain(id int64) error {
+	if id == noNode {
+		return nil
+	}
+
+	for {
+		done, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+			key := fs.removals().Pack(tuple.Tuple{id})
+			pending, err := tx.Get(key).Get()
+			if err != nil || pending == nil {
+				return true, err
+			}
+
+			budget := removeBatch
+			removed, err := fs.removeBottomUp(tx, id, &budget)
+			if err != nil || !removed {
+				return false, err
+			}
+			tx.Clear(key)
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		if done.(bool) {
+			return nil
+		}
+	}
+}
+
+// removeBottomUp removes descendants of node and then the node itself while budget of nodes lasts, it tells
+// whether the node is gone. Entries of removed children are cleared, so the next call picks up where it stopped.
+func (fs FoundationDbFs) removeBottomUp(tx KvTransaction, id int64, budget *int) (bool, error) {
+	if cow, ok := tx.(*cowTransaction); ok {
+		retained, err := cow.retain(id)
+		if err != nil {
+			return false, err
+		}
+		if retained {
+			*budget--
+			return true, nil
+		}
+	}
+
+	for *budget > 0 {
+		entries, err := tx.GetRange(fs.entries(id), fdb.RangeOptions{Limit: *budget})
+		if err != nil {
+			return false, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for i := range entries {
+			_, child, err := fs.entry(id, entries[i])
+			if err != nil {
+				return false, err
+			}
+			removed, err := fs.removeBottomUp(tx, child, budget)
+			if err != nil || !removed {
+				return false, err
+			}
+			tx.Clear(entries[i].Key)
+		}
+	}
+	if *budget <= 0 {
+		return false, nil
+	}
+
+	if err := fs.clearNode(tx, id); err != nil {
+		return false, err
+	}
+	*budget--
+	return true, nil
+}
+
+// isEmpty tells whether directory has no entries
+func (fs FoundationDbFs) isEmpty(r KvReadTransaction, id int64) (bool, error) {
+	entries, err := r.GetRange(fs.entries(id), fdb.RangeOptions{Limit: 1})
+	return len(entries) == 0, err
+}
+
+// NodePath returns the current path of node, os.ErrNotExist when node has been removed
+func (fs FoundationDbFs) NodePath(node int64) (string, error) {
+	p, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return fs.nodePath(r, node)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(append([]string{"/"}, p.([]string)...)...), nil
+}
+
+// nodePath follows back pointers of node up to the root
+func (fs FoundationDbFs) nodePath(r KvReadTransaction, id int64) ([]string, error) {
+	var elems []string
+
+	for id != rootNode {
+		value, err := r.Get(fs.node(id).Pack(parentKey)).Get()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, os.ErrNotExist
+		}
+		t, err := tuple.Unpack(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(t) != 2 {
+			return nil, fmt.Errorf("malformed_parent %v", t)
+		}
+		parent, ok := t[0].(int64)
+		name, ok2 := t[1].(string)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("malformed_parent %v", t)
+		}
+
+		// node that got unlinked without removal has a back pointer nobody points to
+		linked, err := fs.child(r, parent, name)
+		if err != nil {
+			return nil, err
+		}
+		if linked != id {
+			return nil, os.ErrNotExist
+		}
+
+		elems = append([]string{name}, elems...)
+		id = parent
+	}
+
+	return elems, nil
+}
+
+func unpackId(value []byte) (int64, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return noNode, err
+	}
+	if len(t) != 1 {
+		return noNode, fmt.Errorf("malformed_node_id %v", t)
+	}
+	id, ok := t[0].(int64)
+	if !ok {
+		return noNode, fmt.Errorf("malformed_node_id %v", t)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,274 @@
+package billyfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+)
+
+// DefaultAPIVersion is the fdb API version selected when database is opened from a cluster file
+const DefaultAPIVersion = 620
+
+// Option configures filesystem created by NewFoundationDbFsWithOptions
+type Option func(*options) error
+
+type options struct {
+	clusterFile string
+	db          *fdb.Database
+	store       KvStore
+	root        subspace.Subspace
+	directory   []string
+	// apiVersion is selected before opening cluster file, zero opts out of selection, negative selects
+	// DefaultAPIVersion unless host application selected one already
+	apiVersion int
+	retryLimit int64
+	timeout    time.Duration
+	writeBatch int
+	txLimit    int64
+	lockLease  time.Duration
+	versioning versioning
+	trashing   trashMode
+	snapshots  bool
+	// migrate copies a legacy tree of the directory layer into an empty filesystem, see WithLegacyMigration
+	migrate bool
+}
+
+// WithClusterFile opens database of cluster file, empty path is the default cluster file
+func WithClusterFile(clusterFile string) Option {
+	return func(o *options) error {
+		o.clusterFile = clusterFile
+		return nil
+	}
+}
+
+// WithDatabase uses database opened by the caller, API version is not selected then
+func WithDatabase(db fdb.Database) Option {
+	return func(o *options) error {
+		o.db = &db
+		return nil
+	}
+}
+
+// WithStore runs filesystem over any KvStore, e.g. MemoryStore
+func WithStore(store KvStore) Option {
+	return func(o *options) error {
+		o.store = store
+		return nil
+	}
+}
+
+// WithSubspace keeps all filesystem keys under sp instead of ("billyfs")
+func WithSubspace(sp subspace.Subspace) Option {
+	return func(o *options) error {
+		o.root = sp
+		return nil
+	}
+}
+
+// WithDirectory keeps all filesystem keys in fdb directory layer path, directory is created when missing
+func WithDirectory(path ...string) Option {
+	return func(o *options) error {
+		if len(path) == 0 {
+			return fmt.Errorf("empty_directory_path")
+		}
+		o.directory = path
+		return nil
+	}
+}
+
+// WithAPIVersion selects fdb API version before opening cluster file instead of DefaultAPIVersion
+func WithAPIVersion(version int) Option {
+	return func(o *options) error {
+		o.apiVersion = version
+		return nil
+	}
+}
+
+// WithoutAPIVersion leaves selection of fdb API version to the host application
+func WithoutAPIVersion() Option {
+	return func(o *options) error {
+		o.apiVersion = 0
+		return nil
+	}
+}
+
+// WithRetryLimit bounds retries of every transaction, negative limit is unlimited
+func WithRetryLimit(limit int64) Option {
+	return func(o *options) error {
+		o.retryLimit = limit
+		return nil
+	}
+}
+
+// WithTimeout bounds every transaction including its retries, operations fail with context.DeadlineExceeded
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return fmt.Errorf("negative_timeout %v", timeout)
+		}
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithWriteBatch bounds file data written in a single transaction to size bytes, it is rounded down to whole
+// buckets. Larger writes are split into several transactions. It does not change the bucket size files are
+// stored in.
+func WithWriteBatch(size int) Option {
+	return func(o *options) error {
+		if int64(size) < rEADSIZE {
+			return fmt.Errorf("write_batch_below_bucket_size %v < %v", size, rEADSIZE)
+		}
+		o.writeBatch = int(int64(size) / rEADSIZE)
+		return nil
+	}
+}
+
+// WithTransactLimit bounds bytes of keys and values written by a single Transact, default is 8MiB. Limits past the
+// 10MB fdb commit limit leave fdb to refuse the commit.
+func WithTransactLimit(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return fmt.Errorf("non_positive_transact_limit %v", size)
+		}
+		o.txLimit = int64(size)
+		return nil
+	}
+}
+
+// WithLockLease sets how long a file lock outlives a holder that stopped renewing it, default is 10s. Holders
+// renew their locks three times per lease.
+func WithLockLease(lease time.Duration) Option {
+	return func(o *options) error {
+		if lease <= 0 {
+			return fmt.Errorf("non_positive_lock_lease %v", lease)
+		}
+		o.lockLease = lease
+		return nil
+	}
+}
+
+// WithFileVersions keeps previous content of a file whenever it is overwritten, truncated or replaced by rename,
+// see FileVersions. An open file keeps a version once, before its first write overwriting content. At most keep
+// versions of a file are kept and pruning drops versions older than retention, zero bounds nothing. Copies of
+// files larger than a write batch (see WithWriteBatch) take transactions of their own.
+func WithFileVersions(keep int, retention time.Duration) Option {
+	return func(o *options) error {
+		if keep < 0 || retention < 0 {
+			return fmt.Errorf("negative_file_versions %v %v", keep, retention)
+		}
+		o.versioning = versioning{enabled: true, keep: keep, retention: retention}
+		return nil
+	}
+}
+
+// WithTrash keeps removed files and directories in trash instead of removing them, see Trash. Nodes kept longer
+// than retention are purged by ExpireTrash, zero keeps them until PurgeTrash.
+func WithTrash(retention time.Duration) Option {
+	return func(o *options) error {
+		if retention < 0 {
+			return fmt.Errorf("negative_trash_retention %v", retention)
+		}
+		o.trashing = trashMode{enabled: true, retention: retention}
+		return nil
+	}
+}
+
+// WithSnapshots enables Snapshot. Writes then read the epoch of the latest snapshot and copy what snapshots see
+// before overwriting it, without the option they read nothing extra. Every filesystem sharing a subspace must
+// enable it once any of them takes a snapshot, writes of the others would change what snapshots see.
+func WithSnapshots() Option {
+	return func(o *options) error {
+		o.snapshots = true
+		return nil
+	}
+}
+
+// WithLegacyMigration copies the tree written by versions that kept every file as a directory of the fdb
+// directory layer root into the filesystem when it is opened empty, see ErrLegacyLayout. Migration runs before
+// the filesystem is returned, an interrupted one runs again on the next open. The legacy tree is left in place,
+// it can be removed with the directory layer once the copy is checked.
+func WithLegacyMigration() Option {
+	return func(o *options) error {
+		o.migrate = true
+		return nil
+	}
+}
+
+// NewFoundationDbFsWithOptions creates FoundationDbFs. Unless WithDatabase or WithStore is given database is
+// opened from cluster file. Opening an empty filesystem over fdb while the directory layer holds a legacy tree
+// fails with ErrLegacyLayout unless WithLegacyMigration is given.
+func NewFoundationDbFsWithOptions(opts ...Option) (FoundationDbFs, error) {
+	o := &options{
+		root:       subspace.Sub("billyfs"),
+		apiVersion: -1,
+		retryLimit: -1,
+		writeBatch: wRITEBATCH,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return FoundationDbFs{}, err
+		}
+	}
+
+	store, err := o.open()
+	if err != nil {
+		return FoundationDbFs{}, err
+	}
+
+	fs := FoundationDbFs{store: store, root: o.root, writeBatch: o.writeBatch, transactLimit: o.txLimit,
+		lockLease: o.lockLease, versioning: o.versioning, trashing: o.trashing, snapshots: o.snapshots}
+	if o.db != nil {
+		if err = fs.openLegacy(*o.db, o.migrate); err != nil {
+			return FoundationDbFs{}, err
+		}
+	}
+	return fs, nil
+}
+
+func (o *options) open() (KvStore, error) {
+	if o.store != nil {
+		switch {
+		case o.db != nil:
+			return nil, fmt.Errorf("both_store_and_database_given")
+		case o.directory != nil:
+			return nil, fmt.Errorf("directory_needs_fdb_database")
+		case o.retryLimit >= 0 || o.timeout > 0:
+			return nil, fmt.Errorf("transaction_options_need_fdb_database")
+		}
+		return o.store, nil
+	}
+
+	var db fdb.Database
+	if o.db != nil {
+		db = *o.db
+	} else {
+		version := o.apiVersion
+		if version < 0 && !fdb.IsAPIVersionSelected() {
+			version = DefaultAPIVersion
+		}
+		if version > 0 {
+			if err := fdb.APIVersion(version); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if db, err = fdb.OpenDatabase(o.clusterFile); err != nil {
+			return nil, err
+		}
+		o.db = &db
+	}
+
+	if o.directory != nil {
+		dir, err := directory.CreateOrOpen(db, o.directory, nil)
+		if err != nil {
+			return nil, err
+		}
+		o.root = dir
+	}
+
+	return NewFdbStore(db).WithRetryLimit(o.retryLimit).WithTimeout(o.timeout), nil
+}
@@ -0,0 +1,73 @@
+package billyfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubspacesAreIsolated(t *testing.T) {
+	store := NewMemoryStore()
+	first, err := NewFoundationDbFsWithOptions(WithStore(store), WithSubspace(subspace.Sub("first")))
+	require.NoError(t, err)
+	second, err := NewFoundationDbFsWithOptions(WithStore(store), WithSubspace(subspace.Sub("second")))
+	require.NoError(t, err)
+
+	require.NoError(t, first.MkdirAll("/only/first", 0755))
+
+	_, err = second.Stat("/only")
+	assert.True(t, os.IsNotExist(err), "Second fs does not see dirs of first one")
+	_, err = first.Stat("/only/first")
+	assert.NoError(t, err)
+}
+
+func TestWriteBatchSplitsWrites(t *testing.T) {
+	fs, err := NewFoundationDbFsWithOptions(WithStore(NewMemoryStore()), WithWriteBatch(2*int(rEADSIZE)+1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, fs.writeBatch, "Write batch is rounded down to buckets")
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	file, err := fs.Create("/split")
+	require.NoError(t, err)
+	n, err := file.Write(content)
+	require.NoError(t, err)
+	assert.Equal(t, len(content), n)
+
+	file.Seek(0, 0)
+	read, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	memory := WithStore(NewMemoryStore())
+	for name, opts := range map[string][]Option{
+		"batch below bucket":       {memory, WithWriteBatch(int(rEADSIZE) - 1)},
+		"negative timeout":         {memory, WithTimeout(-time.Second)},
+		"empty directory":          {memory, WithDirectory()},
+		"directory over memory":    {memory, WithDirectory("billyfs")},
+		"retry limit over memory":  {memory, WithRetryLimit(3)},
+		"timeout over memory":      {memory, WithTimeout(time.Second)},
+		"negative file versions":   {memory, WithFileVersions(-1, 0)},
+		"negative trash retention": {memory, WithTrash(-time.Second)},
+		"zero transact limit":      {memory, WithTransactLimit(0)},
+	} {
+		_, err := NewFoundationDbFsWithOptions(opts...)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLockLease(t *testing.T) {
+	fs, err := NewFoundationDbFsWithOptions(WithStore(NewMemoryStore()), WithLockLease(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, fs.lease())
+
+	_, err = NewFoundationDbFsWithOptions(WithStore(NewMemoryStore()), WithLockLease(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,583 @@
+package billyfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Range locks follow fcntl semantics and are independent of whole-file locks, like fcntl and flock locks are. They
+// are held by a LockOwner, a lock of an owner replaces its own locks in the range, splitting and merging them,
+// while conflicting locks of other owners are refused. Keys of range locks:
+//
+//	("n", id, 0xFC, 0x07, start, owner) tuple of end and exclusive flag
+//	("n", id, 0xFC, 0x08) counter bumped by every change of range locks of node, waiters watch it
+//	("l", owner) lease expiry of owner in unix nanoseconds
+//	("r", owner, id, start) index of locks held by owner
+//
+// Locks of an owner whose lease expired are reclaimed by the first conflicting locker. Keys under the node are
+// written through transact, so snapshots see range locks as they were when taken.
+
+// RangeLock is a lock of bytes [Start, Start+Length) of a file, zero Length extends it to any file size
+type RangeLock struct {
+	Start     int64
+	Length    int64
+	Exclusive bool
+	// Owner is the id of LockOwner holding the lock
+	Owner string
+}
+
+// ConflictError reports a range lock held by another owner, it is syscall.EAGAIN, same as fcntl F_SETLK fails with
+type ConflictError struct {
+	Lock RangeLock
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("range_locked %v+%v by %v", e.Lock.Start, e.Lock.Length, e.Lock.Owner)
+}
+
+// Is makes errors.Is(err, syscall.EAGAIN) hold
+func (e *ConflictError) Is(target error) bool {
+	return target == syscall.EAGAIN
+}
+
+// LockOwner holds range locks of any number of files. Its lease is renewed in the background until Release, locks
+// of an owner that stopped renewing are reclaimed once the lease expires. Files own their range locks, front ends
+// like an NFS lock manager map their own lock owners to LockOwners instead.
+type LockOwner struct {
+	fs   FoundationDbFs
+	id   string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// rangeAttempt is the outcome of setRange, conflict is set when a lock of another owner was in the way
+type rangeAttempt struct {
+	conflict *RangeLock
+	// version of range locks of node and lease expiry of the conflicting owner, for waiting
+	version []byte
+	expiry  time.Time
+}
+
+type heldRange struct {
+	start, end int64
+	exclusive  bool
+	owner      string
+}
+
+func (r heldRange) lock() RangeLock {
+	l := RangeLock{Start: r.start, Exclusive: r.exclusive, Owner: r.owner}
+	if r.end != math.MaxInt64 {
+		l.Length = r.end - r.start
+	}
+	return l
+}
+
+func (fs FoundationDbFs) ranges(id int64) subspace.Subspace {
+	return fs.node(id).Sub(0xFC, 0x07)
+}
+
+func (fs FoundationDbFs) rangesVersion(id int64) fdb.Key {
+	return fs.node(id).Pack(tuple.Tuple{0xFC, 0x08})
+}
+
+func (fs FoundationDbFs) ownerLease(owner string) fdb.Key {
+	return fs.root.Pack(tuple.Tuple{"l", owner})
+}
+
+func (fs FoundationDbFs) ownerRanges(owner string) subspace.Subspace {
+	return fs.root.Sub("r", owner)
+}
+
+// NewLockOwner starts lease of owner id, empty id is a random one. Owner of an existing id takes over its locks,
+// e.g. after a restart of the front end that holds them.
+func (fs FoundationDbFs) NewLockOwner(id string) (*LockOwner, error) {
+	if id == "" {
+		var err error
+		if id, err = lockOwner(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		tx.Set(fs.ownerLease(id), packExpiry(time.Now().Add(fs.lease())))
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o := &LockOwner{fs: fs, id: id, stop: make(chan struct{}), done: make(chan struct{})}
+	go o.heartbeat()
+	return o, nil
+}
+
+// ID returns id of owner, locks report it as their Owner
+func (o *LockOwner) ID() string {
+	return o.id
+}
+
+// Release releases all locks of owner and ends its lease, releasing owner again does nothing
+func (o *LockOwner) Release() error {
+	select {
+	case <-o.stop:
+		return nil
+	default:
+	}
+	close(o.stop)
+	<-o.done
+
+	_, err := o.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		return nil, o.fs.reclaim(tx, o.id)
+	})
+	return err
+}
+
+// LockRange locks range of node of h unless a conflicting lock of another owner is held, that fails with
+// ConflictError
+func (o *LockOwner) LockRange(h Handle, start, length int64, exclusive bool) error {
+	return o.handleRange("lock", h, func(id int64) error {
+		return o.lockRange(id, start, length, exclusive)
+	})
+}
+
+// LockRangeContext blocks until range of node of h is locked or ctx is done
+func (o *LockOwner) LockRangeContext(ctx context.Context, h Handle, start, length int64, exclusive bool) error {
+	return o.handleRange("lock", h, func(id int64) error {
+		return o.lockRangeContext(ctx, id, start, length, exclusive)
+	})
+}
+
+// UnlockRange releases locks of owner in range of node of h, locks crossing the range are split
+func (o *LockOwner) UnlockRange(h Handle, start, length int64) error {
+	return o.handleRange("unlock", h, func(id int64) error {
+		return o.unlockRange(id, start, length)
+	})
+}
+
+// TestRange returns a lock of another owner that conflicts with the range, nil when it could be locked
+func (o *LockOwner) TestRange(h Handle, start, length int64, exclusive bool) (*RangeLock, error) {
+	var conflict *RangeLock
+	err := o.handleRange("lock", h, func(id int64) (err error) {
+		conflict, err = o.testRange(id, start, length, exclusive)
+		return err
+	})
+	return conflict, err
+}
+
+// RangeLocks lists range locks of node of h by start
+func (fs FoundationDbFs) RangeLocks(h Handle) ([]RangeLock, error) {
+	locks, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		id, _, err := fs.handleNode(r, h)
+		if err != nil {
+			return nil, err
+		}
+		held, err := fs.heldRanges(r, id)
+		if err != nil {
+			return nil, err
+		}
+		locks := make([]RangeLock, len(held))
+		for i := range held {
+			locks[i] = held[i].lock()
+		}
+		return locks, nil
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "lock", Path: h.String(), Err: err}
+	}
+	return locks.([]RangeLock), nil
+}
+
+func (o *LockOwner) handleRange(op string, h Handle, f func(id int64) error) error {
+	id, err := o.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		id, _, err := o.fs.handleNode(r, h)
+		return id, err
+	})
+	if err == nil {
+		err = f(id.(int64))
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: h.String(), Err: err}
+	}
+	return nil
+}
+
+func (o *LockOwner) lockRange(id int64, start, length int64, exclusive bool) error {
+	attempt, err := o.setRange(id, start, length, &exclusive)
+	if err == nil && attempt.conflict != nil {
+		err = &ConflictError{Lock: *attempt.conflict}
+	}
+	return err
+}
+
+func (o *LockOwner) lockRangeContext(ctx context.Context, id int64, start, length int64, exclusive bool) error {
+	for {
+		attempt, err := o.setRange(id, start, length, &exclusive)
+		if err != nil || attempt.conflict == nil {
+			return err
+		}
+
+		wait, cancel := context.WithDeadline(ctx, attempt.expiry)
+		err = watch(o.fs.store, wait, o.fs.rangesVersion(id), attempt.version)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil && err != context.DeadlineExceeded:
+			return err
+		}
+	}
+}
+
+func (o *LockOwner) unlockRange(id int64, start, length int64) error {
+	_, err := o.setRange(id, start, length, nil)
+	return err
+}
+
+func (o *LockOwner) testRange(id int64, start, length int64, exclusive bool) (*RangeLock, error) {
+	end, err := rangeEnd(start, length)
+	if err != nil {
+		return nil, err
+	}
+
+	conflict, err := o.fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		held, err := o.fs.heldRanges(r, id)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, h := range held {
+			if h.owner == o.id || !h.conflicts(start, end, exclusive) {
+				continue
+			}
+			expiry, err := o.fs.leaseExpiry(r, h.owner)
+			if err != nil {
+				return nil, err
+			}
+			if now.Before(expiry) {
+				l := h.lock()
+				return &l, nil
+			}
+		}
+		return (*RangeLock)(nil), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflict.(*RangeLock), nil
+}
+
+// setRange sets locks of owner in range to exclusive, shared or none when exclusive is nil. Conflicting locks of
+// owners with expired leases are reclaimed, other conflicts leave locks as they were.
+func (o *LockOwner) setRange(id int64, start, length int64, exclusive *bool) (rangeAttempt, error) {
+	end, err := rangeEnd(start, length)
+	if err != nil {
+		return rangeAttempt{}, err
+	}
+
+	attempt, err := o.fs.transact(func(tx KvTransaction) (interface{}, error) {
+		now := time.Now()
+		if exclusive != nil {
+			if err := o.fs.lockable(tx, id); err != nil {
+				return nil, err
+			}
+			expiry, err := o.fs.leaseExpiry(tx, o.id)
+			if err != nil {
+				return nil, err
+			}
+			if !now.Before(expiry) {
+				return nil, syscall.ENOLCK
+			}
+		}
+
+		held, err := o.fs.heldRanges(tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		var own []heldRange
+		reclaimed := map[string]bool{}
+		for _, h := range held {
+			switch {
+			case h.owner == o.id:
+				own = append(own, h)
+			case reclaimed[h.owner] || exclusive == nil || !h.conflicts(start, end, *exclusive):
+			default:
+				expiry, err := o.fs.leaseExpiry(tx, h.owner)
+				if err != nil {
+					return nil, err
+				}
+				if now.Before(expiry) {
+					version, err := tx.Get(o.fs.rangesVersion(id)).Get()
+					if err != nil {
+						return nil, err
+					}
+					l := h.lock()
+					return rangeAttempt{conflict: &l, version: version, expiry: expiry}, nil
+				}
+				if err := o.fs.reclaim(tx, h.owner); err != nil {
+					return nil, err
+				}
+				reclaimed[h.owner] = true
+			}
+		}
+
+		if len(own) == 0 && exclusive == nil {
+			return rangeAttempt{}, nil
+		}
+		for _, h := range own {
+			tx.Clear(o.fs.ranges(id).Pack(tuple.Tuple{h.start, o.id}))
+			tx.Clear(o.fs.ownerRanges(o.id).Pack(tuple.Tuple{id, h.start}))
+		}
+		for _, h := range replaceRange(own, start, end, exclusive) {
+			tx.Set(o.fs.ranges(id).Pack(tuple.Tuple{h.start, o.id}), tuple.Tuple{h.end, h.exclusive}.Pack())
+			tx.Set(o.fs.ownerRanges(o.id).Pack(tuple.Tuple{id, h.start}), nil)
+		}
+		return rangeAttempt{}, o.fs.rangesChanged(tx, id)
+	})
+	if err != nil {
+		return rangeAttempt{}, err
+	}
+	return attempt.(rangeAttempt), nil
+}
+
+// heartbeat renews lease of owner until it is released or the lease is lost
+func (o *LockOwner) heartbeat() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.fs.lease() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+		}
+
+		held, err := o.fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			value, err := tx.Get(o.fs.ownerLease(o.id)).Get()
+			if err != nil || value == nil {
+				return false, err
+			}
+			tx.Set(o.fs.ownerLease(o.id), packExpiry(time.Now().Add(o.fs.lease())))
+			return true, nil
+		})
+		if err == nil && !held.(bool) {
+			return
+		}
+	}
+}
+
+// replaceRange returns locks of an owner after setting [start, end) of own to exclusive, shared or none when
+// exclusive is nil. Locks are split at range bounds, adjacent locks of the same kind are merged.
+func replaceRange(own []heldRange, start, end int64, exclusive *bool) []heldRange {
+	var result []heldRange
+	for _, h := range own {
+		if h.end <= start || h.start >= end {
+			result = append(result, h)
+			continue
+		}
+		if h.start < start {
+			result = append(result, heldRange{start: h.start, end: start, exclusive: h.exclusive, owner: h.owner})
+		}
+		if h.end > end {
+			result = append(result, heldRange{start: end, end: h.end, exclusive: h.exclusive, owner: h.owner})
+		}
+	}
+	if exclusive != nil {
+		result = append(result, heldRange{start: start, end: end, exclusive: *exclusive})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].start < result[j].start
+	})
+
+	merged := result[:0]
+	for _, h := range result {
+		if n := len(merged); n > 0 && merged[n-1].end == h.start && merged[n-1].exclusive == h.exclusive {
+			merged[n-1].end = h.end
+			continue
+		}
+		merged = append(merged, h)
+	}
+	return merged
+}
+
+func (h heldRange) conflicts(start, end int64, exclusive bool) bool {
+	return h.start < end && start < h.end && (exclusive || h.exclusive)
+}
+
+// rangeEnd validates range and returns its end, zero length extends to any size
+func rangeEnd(start, length int64) (int64, error) {
+	switch {
+	case start < 0 || length < 0:
+		return 0, syscall.EINVAL
+	case length == 0:
+		return math.MaxInt64, nil
+	case start > math.MaxInt64-length:
+		return 0, syscall.EOVERFLOW
+	}
+	return start + length, nil
+}
+
+func (fs FoundationDbFs) heldRanges(r KvReadTransaction, id int64) ([]heldRange, error) {
+	kvs, err := r.GetRange(fs.ranges(id), fdb.RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]heldRange, len(kvs))
+	for i, kv := range kvs {
+		key, err := fs.ranges(id).Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := tuple.Unpack(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 2 || len(value) != 2 {
+			return nil, fmt.Errorf("malformed_range_lock %v %v", key, value)
+		}
+		start, ok := key[0].(int64)
+		owner, ok2 := key[1].(string)
+		end, ok3 := value[0].(int64)
+		exclusive, ok4 := value[1].(bool)
+		if !ok || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("malformed_range_lock %v %v", key, value)
+		}
+		held[i] = heldRange{start: start, end: end, exclusive: exclusive, owner: owner}
+	}
+	return held, nil
+}
+
+// leaseExpiry reads lease of owner, zero time when there is none
+func (fs FoundationDbFs) leaseExpiry(r KvReadTransaction, owner string) (time.Time, error) {
+	value, err := r.Get(fs.ownerLease(owner)).Get()
+	if err != nil || len(value) != 8 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), nil
+}
+
+// reclaim clears all locks and the lease of owner
+func (fs FoundationDbFs) reclaim(tx KvTransaction, owner string) error {
+	kvs, err := tx.GetRange(fs.ownerRanges(owner), fdb.RangeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range kvs {
+		key, err := fs.ownerRanges(owner).Unpack(kv.Key)
+		if err != nil {
+			return err
+		}
+		if len(key) != 2 {
+			return fmt.Errorf("malformed_range_lock_index %v", key)
+		}
+		id, ok := key[0].(int64)
+		start, ok2 := key[1].(int64)
+		if !ok || !ok2 {
+			return fmt.Errorf("malformed_range_lock_index %v", key)
+		}
+		tx.Clear(fs.ranges(id).Pack(tuple.Tuple{start, owner}))
+		if err := fs.rangesChanged(tx, id); err != nil {
+			return err
+		}
+	}
+	tx.ClearRange(fs.ownerRanges(owner))
+	tx.Clear(fs.ownerLease(owner))
+	return nil
+}
+
+// rangesChanged wakes waiters for range locks of node, removed nodes have none and are left as they are
+func (fs FoundationDbFs) rangesChanged(tx KvTransaction, id int64) error {
+	if err := fs.lockable(tx, id); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	one := make([]byte, 8)
+	binary.LittleEndian.PutUint64(one, 1)
+	tx.Add(fs.rangesVersion(id), one)
+	return nil
+}
+
+// clearRanges drops range locks of a node being removed from the index of their owners, the locks themselves
+// and the version key go with the node
+func (fs FoundationDbFs) clearRanges(tx KvTransaction, id int64) error {
+	held, err := fs.heldRanges(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, h := range held {
+		tx.Clear(fs.ownerRanges(h.owner).Pack(tuple.Tuple{id, h.start}))
+	}
+	return nil
+}
+
+func packExpiry(expiry time.Time) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(expiry.UnixNano()))
+	return value
+}
+
+// LockRange locks range of the file unless another owner holds a conflicting lock, that fails with
+// ConflictError. Range locks of the file are released when it is closed.
+func (f *FoundationDbFile) LockRange(start, length int64, exclusive bool) error {
+	return f.fileRange("lock", func(o *LockOwner) error {
+		return o.lockRange(f.id, start, length, exclusive)
+	})
+}
+
+// LockRangeContext blocks until range of the file is locked or ctx is done
+func (f *FoundationDbFile) LockRangeContext(ctx context.Context, start, length int64, exclusive bool) error {
+	return f.fileRange("lock", func(o *LockOwner) error {
+		return o.lockRangeContext(ctx, f.id, start, length, exclusive)
+	})
+}
+
+// UnlockRange releases range locks of the file in range
+func (f *FoundationDbFile) UnlockRange(start, length int64) error {
+	return f.fileRange("unlock", func(o *LockOwner) error {
+		return o.unlockRange(f.id, start, length)
+	})
+}
+
+// TestRange returns a lock of another owner that conflicts with the range, nil when it could be locked
+func (f *FoundationDbFile) TestRange(start, length int64, exclusive bool) (*RangeLock, error) {
+	var conflict *RangeLock
+	err := f.fileRange("lock", func(o *LockOwner) (err error) {
+		conflict, err = o.testRange(f.id, start, length, exclusive)
+		return err
+	})
+	return conflict, err
+}
+
+// fileRange runs f with lock owner of the file, it is started by the first range lock
+func (f *FoundationDbFile) fileRange(op string, fn func(o *LockOwner) error) error {
+	if f.data.closed {
+		return os.ErrClosed
+	}
+
+	var err error
+	if f.data.owner == nil {
+		f.data.owner, err = f.fs.NewLockOwner("")
+	}
+	if err == nil {
+		err = fn(f.data.owner)
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: f.name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package billyfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceRange(t *testing.T) {
+	shared, exclusive := false, true
+	own := []heldRange{{start: 0, end: 10}, {start: 20, end: 30, exclusive: true}}
+
+	assert.Equal(t, []heldRange{{start: 0, end: 4}, {start: 4, end: 6, exclusive: true}, {start: 6, end: 10},
+		{start: 20, end: 30, exclusive: true}}, replaceRange(own, 4, 6, &exclusive), "Lock inside is split out")
+	assert.Equal(t, []heldRange{{start: 0, end: 25}, {start: 25, end: 30, exclusive: true}},
+		replaceRange(own, 8, 25, &shared), "Adjacent locks of the same kind merge")
+	assert.Equal(t, []heldRange{{start: 0, end: 5}, {start: 25, end: 30, exclusive: true}},
+		replaceRange(own, 5, 25, nil), "Unlock trims crossing locks")
+	assert.Empty(t, replaceRange(own, 0, 30, nil))
+}
+
+func (s *FsTestSuite) openRanges(path string) (*FoundationDbFile, *FoundationDbFile) {
+	first, err := s.fdbfs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	s.Require().NoError(err)
+	second, err := s.fdbfs.OpenFile(path, os.O_RDWR, 0644)
+	s.Require().NoError(err)
+	return first.(*FoundationDbFile), second.(*FoundationDbFile)
+}
+
+func (s *FsTestSuite) TestRangeLocks() {
+	first, second := s.openRanges("/ranges")
+	defer first.Close()
+	defer second.Close()
+
+	s.Require().NoError(first.LockRange(0, 100, false))
+	s.Require().NoError(second.LockRange(50, 100, false), "Shared locks overlap")
+	s.Require().NoError(first.LockRange(200, 0, true))
+
+	err := second.LockRange(90, 20, true)
+	s.True(errors.Is(err, syscall.EAGAIN), "Conflicting lock is refused, got %v", err)
+	var conflict *ConflictError
+	s.Require().True(errors.As(err, &conflict))
+	s.Equal(RangeLock{Start: 0, Length: 100, Owner: first.data.owner.ID()}, conflict.Lock)
+
+	held, err := second.TestRange(300, 1, false)
+	s.Require().NoError(err)
+	s.Require().NotNil(held)
+	s.Equal(RangeLock{Start: 200, Exclusive: true, Owner: first.data.owner.ID()}, *held, "Zero length is to any size")
+
+	s.Require().NoError(first.UnlockRange(40, 0))
+	s.NoError(second.LockRange(90, 20, true), "Unlocked part is free")
+	held, err = second.TestRange(0, 40, true)
+	s.Require().NoError(err)
+	s.NotNil(held, "Part before unlocked range is kept")
+
+	s.Require().NoError(first.Close())
+	locks, err := s.fdbfs.RangeLocks(second.Handle())
+	s.Require().NoError(err)
+	s.Equal([]RangeLock{{Start: 50, Length: 40, Owner: second.data.owner.ID()},
+		{Start: 90, Length: 20, Exclusive: true, Owner: second.data.owner.ID()},
+		{Start: 110, Length: 40, Owner: second.data.owner.ID()}}, locks, "Close releases locks of the file")
+
+	s.True(errors.Is(second.LockRange(-1, 1, true), syscall.EINVAL))
+}
+
+func (s *FsTestSuite) TestRangeLockWaits() {
+	first, second := s.openRanges("/rangewait")
+	defer first.Close()
+	defer second.Close()
+
+	s.Require().NoError(first.LockRange(0, 10, true))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := second.LockRangeContext(ctx, 5, 10, false)
+	s.True(errors.Is(err, context.DeadlineExceeded), "Waiting ends with context, got %v", err)
+
+	locked := make(chan error, 1)
+	go func() {
+		locked <- second.LockRangeContext(context.Background(), 5, 10, false)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.Require().NoError(first.UnlockRange(0, 10))
+	select {
+	case err := <-locked:
+		s.NoError(err)
+	case <-time.After(5 * time.Second):
+		s.FailNow("Range was not locked after unlock")
+	}
+}
+
+func (s *FsTestSuite) TestRangeLocksOfRemovedFile() {
+	file, err := s.fdbfs.Create("/rangeremoved")
+	s.Require().NoError(err)
+	defer file.Close()
+	id := file.(*FoundationDbFile).id
+
+	owner, err := s.fdbfs.NewLockOwner("")
+	s.Require().NoError(err)
+	s.Require().NoError(owner.LockRange(file.(*FoundationDbFile).Handle(), 0, 10, true))
+	s.Require().NoError(s.fdbfs.Remove("/rangeremoved"))
+	s.Equal(0, s.keys(s.fdbfs.ownerRanges(owner.ID())), "Locks of removed file are dropped from owner index")
+
+	s.Require().NoError(owner.Release())
+	s.Equal(0, s.keys(s.fdbfs.node(id)), "Releasing owner does not bring back the removed file")
+
+	file, err = s.fdbfs.Create("/rangeremovedtree/file")
+	s.Require().NoError(err)
+	defer file.Close()
+	owner, err = s.fdbfs.NewLockOwner("")
+	s.Require().NoError(err)
+	defer owner.Release()
+	s.Require().NoError(owner.LockRange(file.(*FoundationDbFile).Handle(), 0, 10, true))
+	s.Require().NoError(s.fdbfs.RemoveAll("/rangeremovedtree"))
+	s.Equal(0, s.keys(s.fdbfs.ownerRanges(owner.ID())), "Locks of files removed with their tree are dropped")
+}
+
+func (s *FsTestSuite) TestLocksInSnapshot() {
+	fs := s.subFs("locksnapshot", WithSnapshots())
+	file, err := fs.Create("/file")
+	s.Require().NoError(err)
+	defer file.Close()
+	s.Require().NoError(fs.Snapshot("unlocked"))
+
+	owner, err := fs.NewLockOwner("")
+	s.Require().NoError(err)
+	defer owner.Release()
+	s.Require().NoError(owner.LockRange(file.(*FoundationDbFile).Handle(), 0, 10, true))
+	s.Require().NoError(file.Lock())
+	defer file.Unlock()
+
+	view, err := fs.OpenSnapshot("unlocked")
+	s.Require().NoError(err)
+	locks, err := view.RangeLocks(file.(*FoundationDbFile).Handle())
+	s.Require().NoError(err)
+	s.Empty(locks, "Snapshot keeps range locks it was taken with")
+	lock, err := view.Store().ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return r.Get(view.node(file.(*FoundationDbFile).id).Pack(lockKey)).Get()
+	})
+	s.Require().NoError(err)
+	s.Nil(lock, "Snapshot keeps file lock it was taken with")
+
+	locks, err = fs.RangeLocks(file.(*FoundationDbFile).Handle())
+	s.Require().NoError(err)
+	s.Len(locks, 1)
+}
+
+func (s *FsTestSuite) TestStaleRangeLockReclaimed() {
+	fs := *s.fdbfs
+	fs.lockLease = 300 * time.Millisecond
+	file, err := fs.Create("/rangestale")
+	s.Require().NoError(err)
+	defer file.Close()
+
+	crashed, err := fs.NewLockOwner("crashed")
+	s.Require().NoError(err)
+	s.Require().NoError(crashed.LockRange(file.(*FoundationDbFile).Handle(), 0, 0, true))
+	// stopping heartbeat without releasing stands for a crash
+	close(crashed.stop)
+	<-crashed.done
+
+	err = file.(*FoundationDbFile).LockRange(0, 10, true)
+	s.True(errors.Is(err, syscall.EAGAIN), "Lock is held until its lease expires, got %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Require().NoError(file.(*FoundationDbFile).LockRangeContext(ctx, 0, 10, true))
+	locks, err := fs.RangeLocks(file.(*FoundationDbFile).Handle())
+	s.Require().NoError(err)
+	s.Len(locks, 1, "All locks of stale owner are reclaimed")
+
+	err = crashed.LockRange(file.(*FoundationDbFile).Handle(), 20, 1, false)
+	s.True(errors.Is(err, syscall.ENOLCK), "Owner that lost its lease can not lock, got %v", err)
+}
@@ -0,0 +1,78 @@
+package billyfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestRemoveNonEmptyDir() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("rmdir")
+	s.Require().NoError(util.WriteFile(fs, "/dir/file", nil, 0644))
+
+	err := fs.Remove("/dir")
+	s.True(errors.Is(err, syscall.ENOTEMPTY), "Directory with content is not removed, got %v", err)
+	s.Require().NoError(fs.Remove("/dir/file"))
+	s.Require().NoError(fs.Remove("/dir"))
+}
+
+func (s *FsTestSuite) TestRemoveAll() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("removeall")
+
+	// tree takes several batches
+	for i := 0; i < removeBatch+removeBatch/2; i++ {
+		s.Require().NoError(util.WriteFile(fs, fmt.Sprintf("/tree/%d/%03d", i%3, i), []byte("x"), 0644))
+	}
+	s.Require().NoError(fs.RemoveAll("/tree"))
+	s.Require().NoError(fs.RemoveAll("/tree"), "Missing path is not an error")
+	_, err := fs.Stat("/tree")
+	s.True(os.IsNotExist(err))
+	s.Equal(1, s.keys(fs.root.Sub("n")), "Nodes are removed, only the root is left")
+	s.Zero(s.keys(fs.root.Sub("e")))
+	s.Zero(s.keys(fs.removals()))
+
+	s.Require().NoError(util.WriteFile(fs, "/interrupted/a/b", nil, 0644))
+	_, err = fs.transact(func(tx KvTransaction) (interface{}, error) {
+		res, err := fs.resolve(tx, fs.split("/interrupted"), false)
+		if err != nil {
+			return nil, err
+		}
+		fs.unlink(tx, res.parent, res.name)
+		tx.Set(fs.removals().Pack(tuple.Tuple{res.id}), []byte{})
+		return nil, nil
+	})
+	s.Require().NoError(err)
+	infos, err := fs.ReadDir("/")
+	s.Require().NoError(err)
+	s.Empty(infos, "Tree is hidden before it is removed")
+	s.Require().NoError(fs.ResumeRemovals())
+	s.Equal(1, s.keys(fs.root.Sub("n")))
+	s.Zero(s.keys(fs.removals()))
+}
+
+func (s *FsTestSuite) TestRemoveAllIndexes() {
+	fs := s.subFs("removeindexes", WithFileVersions(0, 0))
+	s.Require().NoError(util.WriteFile(fs, "/d/app", []byte("v1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/d/app", []byte("v2"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/d/app.new", []byte("v3"), 0644))
+	s.Require().NoError(fs.Rename("/d/app.new", "/d/app"))
+
+	file, err := fs.Open("/d/app")
+	s.Require().NoError(err)
+	defer file.Close()
+	owner, err := fs.NewLockOwner("")
+	s.Require().NoError(err)
+	defer owner.Release()
+	s.Require().NoError(owner.LockRange(file.(*FoundationDbFile).Handle(), 0, 10, false))
+
+	s.Require().NoError(fs.RemoveAll("/d"))
+	s.Zero(s.keys(fs.ownerRanges(owner.ID())), "Range locks of removed files are dropped")
+	s.Zero(s.keys(fs.versionIndex()), "Versions of removed files are dropped")
+	s.Equal(1, s.keys(fs.Subspace().Sub("n")), "Nodes holding inherited versions are removed, only the root is left")
+}
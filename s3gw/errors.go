@@ -0,0 +1,55 @@
+package s3gw
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// s3Error is an error S3 clients understand
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *s3Error) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errNoSuchBucket     = &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey        = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload     = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errBucketNotEmpty   = &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errBucketExists     = &s3Error{http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists"}
+	errInvalidBucket    = &s3Error{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"}
+	errInvalidKey       = &s3Error{http.StatusBadRequest, "InvalidArgument", "Key has to map to a clean path"}
+	errInvalidArgument  = &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errInvalidPart      = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder = &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errMalformedXML     = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errBadDigest        = &s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received"}
+	errKeyConflict      = &s3Error{http.StatusConflict, "KeyConflict", "Key conflicts with a key that is a prefix of it"}
+	errNotImplemented   = &s3Error{http.StatusNotImplemented, "NotImplemented", "The operation is not supported"}
+)
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *s3Error
+	switch {
+	case errors.As(err, &e):
+	case os.IsNotExist(err):
+		e = errNoSuchKey
+	case errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR):
+		e = errKeyConflict
+	default:
+		e = &s3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, errorResponse{Code: e.code, Message: e.message, Resource: r.URL.Path})
+}
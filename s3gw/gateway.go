@@ -0,0 +1,436 @@
+// Package s3gw is an S3 compatible HTTP gateway over FoundationDbFs.
+//
+// Buckets are top level directories, object keys are paths in them. Keys ending with "/" are directory markers,
+// keys with empty, "." or ".." path elements can't be mapped and are rejected. Objects are written to a staging
+// directory first and renamed into place, so readers never see a partial object. Requests are not
+// authenticated, gateway is meant to run behind a proxy that does it.
+package s3gw
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// stagingDir keeps objects being written and parts of multipart uploads. It is hidden from listings of the
+// filesystem, and it can't clash with a bucket since bucket names can't start with a dot.
+const stagingDir = "/" + billyfs.HiddenPrefix + "s3-staging"
+
+// emptyETag is the ETag of an empty object
+const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Gateway serves S3 REST requests with path-style addressing
+type Gateway struct {
+	fs billyfs.FoundationDbFs
+	// sp keeps state of multipart uploads, ETags of objects are kept with their node, see etagKey
+	sp subspace.Subspace
+}
+
+var _ http.Handler = &Gateway{}
+
+// New creates gateway over fs
+func New(fs billyfs.FoundationDbFs) *Gateway {
+	return &Gateway{fs: fs, sp: fs.Subspace().Sub("s3")}
+}
+
+// request is a gateway bound to a single request
+type request struct {
+	*Gateway
+	w      http.ResponseWriter
+	r      *http.Request
+	bucket string
+	key    string
+}
+
+// ServeHTTP dispatches S3 operations
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+	req := &request{
+		Gateway: &Gateway{fs: g.fs.WithContext(r.Context()), sp: g.sp},
+		w:       w,
+		r:       r,
+		bucket:  bucket,
+		key:     key,
+	}
+
+	if err := req.serve(); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (req *request) serve() error {
+	q := req.r.URL.Query()
+	_, uploads := q["uploads"]
+	uploadID := q.Get("uploadId")
+
+	switch {
+	case req.bucket == "" && req.r.Method == http.MethodGet:
+		return req.listBuckets()
+	case req.bucket == "":
+		return errNotImplemented
+	case !bucketName.MatchString(req.bucket):
+		return errInvalidBucket
+	}
+
+	if req.key == "" {
+		switch req.r.Method {
+		case http.MethodPut:
+			return req.createBucket()
+		case http.MethodDelete:
+			return req.deleteBucket()
+		case http.MethodHead:
+			return req.headBucket()
+		case http.MethodGet:
+			if q.Get("list-type") == "2" {
+				return req.listObjects()
+			}
+		}
+		return errNotImplemented
+	}
+
+	switch {
+	case req.r.Method == http.MethodPost && uploads:
+		return req.initiateUpload()
+	case req.r.Method == http.MethodPost && uploadID != "":
+		return req.completeUpload(uploadID)
+	case req.r.Method == http.MethodPut && uploadID != "":
+		return req.uploadPart(uploadID, q.Get("partNumber"))
+	case req.r.Method == http.MethodDelete && uploadID != "":
+		return req.abortUpload(uploadID)
+	case uploadID != "" || req.r.Header.Get("X-Amz-Copy-Source") != "":
+		return errNotImplemented
+	case req.r.Method == http.MethodPut:
+		return req.putObject()
+	case req.r.Method == http.MethodGet || req.r.Method == http.MethodHead:
+		return req.getObject()
+	case req.r.Method == http.MethodDelete:
+		return req.deleteObject()
+	}
+
+	return errNotImplemented
+}
+
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	i := strings.Index(p, "/")
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+// objectPath maps key of bucket to filesystem path
+func objectPath(bucket string, key string) (string, error) {
+	elems := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	for _, e := range elems {
+		if e == "" || e == "." || e == ".." {
+			return "", errInvalidKey
+		}
+	}
+
+	return "/" + bucket + "/" + strings.Join(elems, "/"), nil
+}
+
+func (req *request) bucketPath() string {
+	return "/" + req.bucket
+}
+
+// checkBucket fails with NoSuchBucket when bucket directory is missing
+func (req *request) checkBucket() error {
+	info, err := req.fs.Stat(req.bucketPath())
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return errNoSuchBucket
+	}
+	return err
+}
+
+func (req *request) listBuckets() error {
+	infos, err := req.fs.ReadDir("/")
+	if err != nil {
+		return err
+	}
+
+	result := listAllMyBucketsResult{Xmlns: s3Namespace, Buckets: []bucket{}}
+	for _, info := range infos {
+		if info.IsDir() && bucketName.MatchString(info.Name()) {
+			result.Buckets = append(result.Buckets, bucket{info.Name(), formatTime(info.ModTime())})
+		}
+	}
+
+	writeXML(req.w, http.StatusOK, result)
+	return nil
+}
+
+func (req *request) createBucket() error {
+	if _, err := req.fs.Stat(req.bucketPath()); err == nil {
+		return errBucketExists
+	}
+	if err := req.fs.MkdirAll(req.bucketPath(), 0755); err != nil {
+		return err
+	}
+
+	req.w.Header().Set("Location", req.bucketPath())
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (req *request) deleteBucket() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	err := req.fs.Remove(req.bucketPath())
+	if errors.Is(err, syscall.ENOTEMPTY) {
+		return errBucketNotEmpty
+	}
+	if err != nil {
+		return err
+	}
+
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (req *request) headBucket() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (req *request) putObject() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	target, err := objectPath(req.bucket, req.key)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(req.key, "/") {
+		if err = req.fs.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		req.w.Header().Set("ETag", emptyETag)
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	staged := path.Join(stagingDir, id)
+	etag, err := req.stage(staged, req.r.Body, req.r.Header.Get("Content-MD5"))
+	if err != nil {
+		return err
+	}
+	if err = req.replace(staged, target, etag); err != nil {
+		return err
+	}
+
+	req.w.Header().Set("ETag", etag)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// stage writes body to a new file and returns its ETag, body is checked against base64 md5 when given
+func (req *request) stage(name string, body io.Reader, contentMD5 string) (string, error) {
+	f, err := req.fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	sum := hash.Sum(nil)
+	if err == nil && contentMD5 != "" && contentMD5 != base64.StdEncoding.EncodeToString(sum) {
+		err = errBadDigest
+	}
+	if err != nil {
+		req.fs.Remove(name)
+		return "", err
+	}
+
+	return quote(hex.EncodeToString(sum)), nil
+}
+
+// replace renames staged file over target and records its ETag
+func (req *request) replace(staged string, target string, etag string) error {
+	old, oldErr := req.fs.Stat(target)
+	if err := req.fs.Rename(staged, target); err != nil {
+		req.fs.Remove(staged)
+		return err
+	}
+	if oldErr == nil {
+		req.clearETag(old)
+	}
+
+	info, err := req.fs.Stat(target)
+	if err != nil {
+		return err
+	}
+	return req.setETag(info, etag)
+}
+
+func (req *request) getObject() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	target, err := objectPath(req.bucket, req.key)
+	if err != nil {
+		return err
+	}
+
+	info, err := req.fs.Stat(target)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() != strings.HasSuffix(req.key, "/") {
+		return errNoSuchKey
+	}
+
+	etag, err := req.etag(info)
+	if err != nil {
+		return err
+	}
+	req.w.Header().Set("ETag", etag)
+	req.w.Header().Set("Content-Type", "application/octet-stream")
+
+	if info.IsDir() {
+		http.ServeContent(req.w, req.r, "", info.ModTime(), strings.NewReader(""))
+		return nil
+	}
+
+	f, err := req.fs.Open(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	http.ServeContent(req.w, req.r, "", info.ModTime(), f)
+	return nil
+}
+
+// deleteObject removes object and directories left empty by it, missing objects are not an error
+func (req *request) deleteObject() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	target, err := objectPath(req.bucket, req.key)
+	if err != nil {
+		return err
+	}
+
+	info, err := req.fs.Stat(target)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case info.IsDir() != strings.HasSuffix(req.key, "/"):
+	default:
+		if err = req.removeEmpty(target); err != nil {
+			return err
+		}
+		req.clearETag(info)
+		for dir := path.Dir(target); dir != req.bucketPath(); dir = path.Dir(dir) {
+			if err = req.removeEmpty(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeEmpty removes a file or an empty directory, directories with content are left in place
+func (req *request) removeEmpty(name string) error {
+	err := req.fs.Remove(name)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTEMPTY) {
+		return nil
+	}
+	return err
+}
+
+// etagKey is the key ETag of object is recorded at, it is removed along with the node of object
+func (g *Gateway) etagKey(info os.FileInfo) (subspace.Subspace, bool) {
+	stat, ok := info.Sys().(*billyfs.NodeStat)
+	if !ok {
+		return nil, false
+	}
+	return g.fs.NodeSubspace(stat.Node, "s3").Sub("etag"), true
+}
+
+// setETag records ETag of object, it is valid while size and modification time of the node stay the same
+func (g *Gateway) setETag(info os.FileInfo, etag string) error {
+	key, ok := g.etagKey(info)
+	if !ok {
+		return nil
+	}
+
+	_, err := g.fs.TransactNodes(func(tx billyfs.KvTransaction) (interface{}, error) {
+		tx.Set(key, tuple.Tuple{etag, info.Size(), info.ModTime().UnixNano()}.Pack())
+		return nil, nil
+	})
+	return err
+}
+
+func (g *Gateway) clearETag(info os.FileInfo) {
+	if key, ok := g.etagKey(info); ok {
+		g.fs.TransactNodes(func(tx billyfs.KvTransaction) (interface{}, error) {
+			tx.Clear(key)
+			return nil, nil
+		})
+	}
+}
+
+// etag returns recorded ETag of object. Objects changed through other protocols get an ETag derived from node,
+// size and modification time.
+func (g *Gateway) etag(info os.FileInfo) (string, error) {
+	if info.IsDir() {
+		return emptyETag, nil
+	}
+	key, ok := g.etagKey(info)
+	if !ok {
+		return emptyETag, nil
+	}
+
+	value, err := g.fs.Store().ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		return r.Get(key).Get()
+	})
+	if err != nil {
+		return "", err
+	}
+	if t, err := tuple.Unpack(value.([]byte)); err == nil && len(t) == 3 &&
+		t[1] == info.Size() && t[2] == info.ModTime().UnixNano() {
+		if etag, ok := t[0].(string); ok {
+			return etag, nil
+		}
+	}
+
+	stat := info.Sys().(*billyfs.NodeStat)
+	return quote(fmt.Sprintf("%x-%x-%x", stat.Node, info.Size(), info.ModTime().UnixNano())), nil
+}
+
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
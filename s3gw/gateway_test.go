@@ -0,0 +1,301 @@
+package s3gw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T) (*s3.S3, billyfs.FoundationDbFs) {
+	fs := billyfs.NewFoundationDbFsFromStore(billyfs.NewMemoryStore())
+	server := httptest.NewServer(New(fs))
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+	require.NoError(t, err)
+	return s3.New(sess), fs
+}
+
+func errorCode(err error) string {
+	if e, ok := err.(awserr.Error); ok {
+		return e.Code()
+	}
+	return ""
+}
+
+func put(t *testing.T, client *s3.S3, bucket string, key string, body string) {
+	_, err := client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte(body)),
+	})
+	require.NoError(t, err, "put %s", key)
+}
+
+func TestBuckets(t *testing.T) {
+	client, _ := newClient(t)
+
+	_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "BucketAlreadyOwnedByYou", errorCode(err))
+	_, err = client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("Bad_Name")})
+	assert.Equal(t, "InvalidBucketName", errorCode(err))
+
+	list, err := client.ListBuckets(&s3.ListBucketsInput{})
+	require.NoError(t, err)
+	require.Len(t, list.Buckets, 1)
+	assert.Equal(t, "bucket", *list.Buckets[0].Name)
+
+	put(t, client, "bucket", "file", "content")
+	_, err = client.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "BucketNotEmpty", errorCode(err))
+
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("file")})
+	require.NoError(t, err)
+	_, err = client.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	_, err = client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("bucket")})
+	assert.Equal(t, "NotFound", errorCode(err), "HEAD has no body with error code")
+}
+
+func TestObjects(t *testing.T) {
+	client, fs := newClient(t)
+	_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	out, err := client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+		Body:   bytes.NewReader([]byte("0123456789")),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"781e5e245d69b566979b86e28d23f2c7"`, *out.ETag, "ETag is md5 of content")
+
+	f, err := fs.Open("/bucket/dir/file")
+	require.NoError(t, err, "Objects are files")
+	f.Close()
+
+	got, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+		Range:  aws.String("bytes=2-5"),
+	})
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(got.Body)
+	got.Body.Close()
+	assert.Equal(t, "2345", string(body))
+	assert.Equal(t, *out.ETag, *got.ETag)
+
+	_, err = client.GetObject(&s3.GetObjectInput{
+		Bucket:      aws.String("bucket"),
+		Key:         aws.String("dir/file"),
+		IfNoneMatch: out.ETag,
+	})
+	assert.Equal(t, "NotModified", errorCode(err))
+
+	head, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/file")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *head.ContentLength)
+
+	_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir")})
+	assert.Equal(t, "NoSuchKey", errorCode(err), "Directories are not objects")
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file/nested"),
+		Body:   bytes.NewReader(nil),
+	})
+	assert.Equal(t, "KeyConflict", errorCode(err))
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("digest"),
+		Body:       bytes.NewReader([]byte("content")),
+		ContentMD5: aws.String("AAAAAAAAAAAAAAAAAAAAAA=="),
+	})
+	assert.Equal(t, "BadDigest", errorCode(err))
+	_, err = fs.Stat("/bucket/digest")
+	assert.Error(t, err, "Rejected object is not written")
+
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/file")})
+	require.NoError(t, err)
+	_, err = fs.Stat("/bucket/dir")
+	assert.Error(t, err, "Directories left empty are removed")
+	_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("missing")})
+	assert.NoError(t, err, "Deleting missing key succeeds")
+}
+
+func TestETagRemovedWithNode(t *testing.T) {
+	client, fs := newClient(t)
+	_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	put(t, client, "bucket", "file", "content")
+
+	info, err := fs.Stat("/bucket/file")
+	require.NoError(t, err)
+	etags := fs.NodeSubspace(info.Sys().(*billyfs.NodeStat).Node, "s3")
+	count := func() int {
+		kvs, err := fs.Store().ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+			return r.GetRange(etags, fdb.RangeOptions{})
+		})
+		require.NoError(t, err)
+		return len(kvs.([]fdb.KeyValue))
+	}
+	require.Equal(t, 1, count(), "ETag is recorded")
+
+	require.NoError(t, fs.Remove("/bucket/file"), "Object is removed through another protocol")
+	assert.Zero(t, count(), "ETag goes with the node")
+}
+
+func TestListObjects(t *testing.T) {
+	client, _ := newClient(t)
+	_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	for _, key := range []string{"a/1", "a/2", "a/b/3", "c", "d/", "e/f/4"} {
+		put(t, client, "bucket", key, key)
+	}
+
+	keys := func(input *s3.ListObjectsV2Input) ([]string, []string) {
+		input.Bucket = aws.String("bucket")
+		out, err := client.ListObjectsV2(input)
+		require.NoError(t, err)
+		var keys, prefixes []string
+		for _, c := range out.Contents {
+			keys = append(keys, *c.Key)
+		}
+		for _, p := range out.CommonPrefixes {
+			prefixes = append(prefixes, *p.Prefix)
+		}
+		return keys, prefixes
+	}
+
+	all, _ := keys(&s3.ListObjectsV2Input{})
+	assert.Equal(t, []string{"a/1", "a/2", "a/b/3", "c", "d/", "e/f/4"}, all)
+
+	top, prefixes := keys(&s3.ListObjectsV2Input{Delimiter: aws.String("/")})
+	assert.Equal(t, []string{"c"}, top)
+	assert.Equal(t, []string{"a/", "d/", "e/"}, prefixes)
+
+	nested, prefixes := keys(&s3.ListObjectsV2Input{Prefix: aws.String("a/"), Delimiter: aws.String("/")})
+	assert.Equal(t, []string{"a/1", "a/2"}, nested)
+	assert.Equal(t, []string{"a/b/"}, prefixes)
+
+	partial, _ := keys(&s3.ListObjectsV2Input{Prefix: aws.String("a/b")})
+	assert.Equal(t, []string{"a/b/3"}, partial)
+
+	var paged []string
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String("bucket"), MaxKeys: aws.Int64(2)},
+		func(page *s3.ListObjectsV2Output, last bool) bool {
+			assert.LessOrEqual(t, len(page.Contents), 2)
+			for _, c := range page.Contents {
+				paged = append(paged, *c.Key)
+			}
+			return true
+		})
+	require.NoError(t, err)
+	assert.Equal(t, all, paged)
+
+	after, _ := keys(&s3.ListObjectsV2Input{StartAfter: aws.String("a/b/3")})
+	assert.Equal(t, []string{"c", "d/", "e/f/4"}, after)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	client, fs := newClient(t)
+	_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 6<<16)
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = s3manager.MinUploadPartSize
+	})
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("large"),
+		Body:   bytes.NewReader(content),
+	})
+	require.NoError(t, err)
+
+	got, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("large")})
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(got.Body)
+	got.Body.Close()
+	assert.Equal(t, content, body)
+	assert.Contains(t, *got.ETag, "-2\"", "Multipart ETag counts parts")
+
+	created, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("aborted"),
+	})
+	require.NoError(t, err)
+	_, err = client.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("aborted"),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       bytes.NewReader([]byte("part")),
+	})
+	require.NoError(t, err)
+	_, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("aborted"),
+		UploadId: created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{
+			{PartNumber: aws.Int64(2), ETag: aws.String(`"00"`)},
+		}},
+	})
+	assert.Equal(t, "InvalidPart", errorCode(err))
+
+	_, err = client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("aborted"),
+		UploadId: created.UploadId,
+	})
+	require.NoError(t, err)
+	_, err = client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("aborted"),
+		UploadId: created.UploadId,
+	})
+	assert.Equal(t, "NoSuchUpload", errorCode(err))
+
+	staged, err := fs.ReadDir(stagingDir)
+	require.NoError(t, err)
+	assert.Empty(t, staged, "Completed and aborted uploads leave nothing staged")
+	root, err := fs.ReadDir("/")
+	require.NoError(t, err)
+	for _, info := range root {
+		assert.NotEqual(t, path.Base(stagingDir), info.Name(), "Staging is hidden from listings")
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	p, err := objectPath("bucket", "a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "/bucket/a/b", p)
+	p, err = objectPath("bucket", "a/b/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/bucket/a/b", p, "Directory markers map to directories")
+
+	for _, key := range []string{"a//b", "./a", "a/../b", "/a"} {
+		_, err = objectPath("bucket", key)
+		assert.Equal(t, errInvalidKey, err, "Key %q is rejected", key)
+	}
+}
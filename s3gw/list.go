@@ -0,0 +1,155 @@
+package s3gw
+
+import (
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxKeys is the page size of listings when client does not ask for one
+const defaultMaxKeys = 1000
+
+// object is a listed key with its file
+type object struct {
+	key  string
+	info os.FileInfo
+}
+
+func (req *request) listObjects() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+
+	q := req.r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := defaultMaxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	// listing resumes after the later of start-after and continuation token
+	after := q.Get("start-after")
+	token := q.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return errInvalidArgument
+		}
+		if string(decoded) > after {
+			after = string(decoded)
+		}
+	}
+
+	var objects []object
+	if err := req.walk(req.bucketPath(), "", prefix, delimiter, &objects); err != nil {
+		return err
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].key < objects[j].key
+	})
+
+	result := listBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              req.bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: token,
+		MaxKeys:           maxKeys,
+	}
+
+	last := ""
+	for _, o := range objects {
+		key, common := o.key, false
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				key, common = key[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if key <= after || (common && key == last) {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		last = key
+		result.KeyCount++
+		if common {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{key})
+			continue
+		}
+		etag, err := req.etag(o.info)
+		if err != nil {
+			return err
+		}
+		size := o.info.Size()
+		if o.info.IsDir() {
+			size = 0
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: formatTime(o.info.ModTime()),
+			ETag:         etag,
+			Size:         size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	writeXML(req.w, http.StatusOK, result)
+	return nil
+}
+
+// walk collects objects under dir whose keys start with prefix. Empty directories are listed as directory markers.
+// Subtrees outside of prefix are skipped, as are subtrees that collapse into a single common prefix.
+func (req *request) walk(dir string, base string, prefix string, delimiter string, objects *[]object) error {
+	infos, err := req.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 && base != "" && strings.HasPrefix(base, prefix) {
+		info, err := req.fs.Stat(dir)
+		if err != nil {
+			return err
+		}
+		*objects = append(*objects, object{base, info})
+		return nil
+	}
+
+	for _, info := range infos {
+		key := base + info.Name()
+		if !info.IsDir() {
+			if info.Mode().IsRegular() && strings.HasPrefix(key, prefix) {
+				*objects = append(*objects, object{key, info})
+			}
+			continue
+		}
+
+		key += "/"
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			continue
+		}
+		if delimiter != "" && len(key) > len(prefix) && strings.Contains(key[len(prefix):], delimiter) {
+			// every key below shares the common prefix, one of them is enough to report it
+			*objects = append(*objects, object{key, info})
+			continue
+		}
+		if err = req.walk(path.Join(dir, info.Name()), key, prefix, delimiter, objects); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	billyfs "github.com/iggyzap/foundationdb-billyfs"
+)
+
+// maxPartNumber is the largest part number S3 accepts
+const maxPartNumber = 10000
+
+// newID returns a random id of an upload or a staged file
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// uploadDir keeps parts of an upload, named by zero padded part numbers
+func uploadDir(id string) string {
+	return path.Join(stagingDir, "upload-"+id)
+}
+
+func partPath(id string, part int) string {
+	return path.Join(uploadDir(id), fmt.Sprintf("%05d", part))
+}
+
+// upload loads bucket and key an upload was initiated for
+func (req *request) upload(id string) (string, string, error) {
+	value, err := req.fs.Store().ReadTransact(func(r billyfs.KvReadTransaction) (interface{}, error) {
+		return r.Get(req.sp.Sub("upload", id)).Get()
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if value.([]byte) == nil {
+		return "", "", errNoSuchUpload
+	}
+
+	t, err := tuple.Unpack(value.([]byte))
+	if err != nil || len(t) != 2 {
+		return "", "", fmt.Errorf("error_corrupt_upload %v", id)
+	}
+	bucket, _ := t[0].(string)
+	key, _ := t[1].(string)
+	if bucket != req.bucket || key != req.key {
+		return "", "", errNoSuchUpload
+	}
+
+	return bucket, key, nil
+}
+
+func (req *request) initiateUpload() error {
+	if err := req.checkBucket(); err != nil {
+		return err
+	}
+	if _, err := objectPath(req.bucket, req.key); err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if err = req.fs.MkdirAll(uploadDir(id), 0755); err != nil {
+		return err
+	}
+	_, err = req.fs.Store().Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		tx.Set(req.sp.Sub("upload", id), tuple.Tuple{req.bucket, req.key}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	writeXML(req.w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   req.bucket,
+		Key:      req.key,
+		UploadID: id,
+	})
+	return nil
+}
+
+func (req *request) uploadPart(id string, partNumber string) error {
+	part, err := strconv.Atoi(partNumber)
+	if err != nil || part < 1 || part > maxPartNumber {
+		return errInvalidArgument
+	}
+	if _, _, err = req.upload(id); err != nil {
+		return err
+	}
+
+	name, err := newID()
+	if err != nil {
+		return err
+	}
+	staged := path.Join(uploadDir(id), name)
+	etag, err := req.stage(staged, req.r.Body, req.r.Header.Get("Content-MD5"))
+	if err != nil {
+		return err
+	}
+	if err = req.replace(staged, partPath(id, part), etag); err != nil {
+		return err
+	}
+
+	req.w.Header().Set("ETag", etag)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeUpload joins listed parts into the object, ETag of the object is md5 of part digests suffixed with
+// number of parts
+func (req *request) completeUpload(id string) error {
+	if _, _, err := req.upload(id); err != nil {
+		return err
+	}
+	target, err := objectPath(req.bucket, req.key)
+	if err != nil {
+		return err
+	}
+
+	var body completeMultipartUpload
+	if err = xml.NewDecoder(req.r.Body).Decode(&body); err != nil || len(body.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	staged := path.Join(uploadDir(id), "object")
+	out, err := req.fs.OpenFile(staged, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	digests := md5.New()
+	err = req.join(out, id, body.Parts, digests)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		req.fs.Remove(staged)
+		return err
+	}
+
+	etag := quote(fmt.Sprintf("%x-%d", digests.Sum(nil), len(body.Parts)))
+	if err = req.replace(staged, target, etag); err != nil {
+		return err
+	}
+	if err = req.dropUpload(id); err != nil {
+		return err
+	}
+
+	writeXML(req.w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:  s3Namespace,
+		Bucket: req.bucket,
+		Key:    req.key,
+		ETag:   etag,
+	})
+	return nil
+}
+
+// join copies parts to out in order, adding binary digest of each part to digests
+func (req *request) join(out io.Writer, id string, parts []completedPart, digests io.Writer) error {
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+
+		name := partPath(id, p.PartNumber)
+		info, err := req.fs.Stat(name)
+		if os.IsNotExist(err) {
+			return errInvalidPart
+		}
+		if err != nil {
+			return err
+		}
+		etag, err := req.etag(info)
+		if err != nil {
+			return err
+		}
+		if etag != quote(unquote(p.ETag)) {
+			return errInvalidPart
+		}
+		digest, err := hex.DecodeString(unquote(etag))
+		if err != nil {
+			return errInvalidPart
+		}
+		digests.Write(digest)
+
+		in, err := req.fs.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (req *request) abortUpload(id string) error {
+	if _, _, err := req.upload(id); err != nil {
+		return err
+	}
+	if err := req.dropUpload(id); err != nil {
+		return err
+	}
+
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// dropUpload removes parts and state of an upload
+func (req *request) dropUpload(id string) error {
+	infos, err := req.fs.ReadDir(uploadDir(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, info := range infos {
+		req.clearETag(info)
+	}
+	if err = req.fs.RemoveAll(uploadDir(id)); err != nil {
+		return err
+	}
+
+	_, err = req.fs.Store().Transact(func(tx billyfs.KvTransaction) (interface{}, error) {
+		tx.Clear(req.sp.Sub("upload", id))
+		return nil, nil
+	})
+	return err
+}
+
+func unquote(etag string) string {
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		return etag[1 : len(etag)-1]
+	}
+	return etag
+}
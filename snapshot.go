@@ -0,0 +1,829 @@
+package billyfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// Snapshots are copy-on-write. Taking one only records a new epoch, values it sees are never overwritten in
+// place afterwards: the first transaction changing a node or entry key after the latest snapshot keeps its
+// previous value as an immutable copy tagged with the epoch of that snapshot. Snapshot of epoch e sees the copy
+// of the smallest epoch not below e, the live value when there is none. Nodes allocated after the latest
+// snapshot are not copied, no snapshot reaches them, and removed nodes that some snapshot reaches are kept
+// unlinked instead of copied. Such nodes carry the epoch they were allocated in, see bornKey. Keys:
+//
+//	("v", "g") epoch counter
+//	("v", "s", name) tuple of epoch and creation time of a snapshot
+//	("v", "e", epoch) name of a snapshot
+//	("v", "p", key, epoch) value of key relative to root seen by snapshots up to epoch, 0 when it was missing
+//	("v", "d", epoch, id) node removed while epoch was the latest snapshot
+//
+// Copies and removed nodes are dropped by DeleteSnapshot once no snapshot references them. Copy-on-write is only
+// done by filesystems created WithSnapshots, others neither copy nor read the latest epoch.
+
+// SnapshotInfo describes a snapshot
+type SnapshotInfo struct {
+	Name    string
+	Epoch   int64
+	Created time.Time
+}
+
+// gcBatch bounds copies collected in one transaction
+const gcBatch = 1000
+
+// snapshotPage bounds keys of a range read in one go by a snapshot view
+const snapshotPage = 1000
+
+func (fs FoundationDbFs) versions() subspace.Subspace {
+	return fs.root.Sub("v")
+}
+
+func (fs FoundationDbFs) preserved() subspace.Subspace {
+	return fs.versions().Sub("p")
+}
+
+// Snapshot captures the current state of the filesystem as snapshot name, it takes a single transaction
+// whatever the size of the filesystem is. It fails with syscall.ENOTSUP unless the filesystem was created
+// WithSnapshots.
+func (fs FoundationDbFs) Snapshot(name string) error {
+	if name == "" {
+		return &os.PathError{Op: "snapshot", Path: name, Err: syscall.EINVAL}
+	}
+	if !fs.snapshots {
+		return &os.PathError{Op: "snapshot", Path: name, Err: syscall.ENOTSUP}
+	}
+
+	_, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		key := fs.versions().Pack(tuple.Tuple{"s", name})
+		existing, err := tx.Get(key).Get()
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, os.ErrExist
+		}
+
+		counter := fs.versions().Pack(tuple.Tuple{"g"})
+		one := make([]byte, 8)
+		binary.LittleEndian.PutUint64(one, 1)
+		tx.Add(counter, one)
+		value, err := tx.Get(counter).Get()
+		if err != nil {
+			return nil, err
+		}
+		epoch := int64(binary.LittleEndian.Uint64(value))
+
+		tx.Set(key, tuple.Tuple{epoch, time.Now().UnixNano()}.Pack())
+		tx.Set(fs.versions().Pack(tuple.Tuple{"e", epoch}), tuple.Tuple{name}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return &os.PathError{Op: "snapshot", Path: name, Err: err}
+	}
+	return nil
+}
+
+// ListSnapshots lists snapshots from the oldest one
+func (fs FoundationDbFs) ListSnapshots() ([]SnapshotInfo, error) {
+	list, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		kvs, err := r.GetRange(fs.versions().Sub("s"), fdb.RangeOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make([]SnapshotInfo, len(kvs))
+		for i, kv := range kvs {
+			if infos[i], err = fs.unpackSnapshot(kv); err != nil {
+				return nil, err
+			}
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Epoch < infos[j].Epoch
+		})
+		return infos, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.([]SnapshotInfo), nil
+}
+
+// OpenSnapshot returns a read-only view of snapshot name, its writes fail with syscall.EROFS. The view must not
+// be used once the snapshot is deleted.
+func (fs FoundationDbFs) OpenSnapshot(name string) (FoundationDbFs, error) {
+	info, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return fs.snapshot(r, name)
+	})
+	if err != nil {
+		return FoundationDbFs{}, &os.PathError{Op: "snapshot", Path: name, Err: err}
+	}
+
+	fs.store = snapshotStore{store: fs.store, fs: fs, epoch: info.(SnapshotInfo).Epoch}
+	return fs, nil
+}
+
+// DeleteSnapshot deletes snapshot name and collects copies and removed nodes no other snapshot references
+func (fs FoundationDbFs) DeleteSnapshot(name string) error {
+	_, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+		info, err := fs.snapshot(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		tx.Clear(fs.versions().Pack(tuple.Tuple{"s", name}))
+		tx.Clear(fs.versions().Pack(tuple.Tuple{"e", info.Epoch}))
+		return nil, nil
+	})
+	if err == nil {
+		err = fs.collect()
+	}
+	if err != nil {
+		return &os.PathError{Op: "snapshot", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (fs FoundationDbFs) snapshot(r KvReadTransaction, name string) (SnapshotInfo, error) {
+	key := fs.versions().Pack(tuple.Tuple{"s", name})
+	value, err := r.Get(key).Get()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if value == nil {
+		return SnapshotInfo{}, os.ErrNotExist
+	}
+	return fs.unpackSnapshot(fdb.KeyValue{Key: key, Value: value})
+}
+
+func (fs FoundationDbFs) unpackSnapshot(kv fdb.KeyValue) (SnapshotInfo, error) {
+	key, err := fs.versions().Unpack(kv.Key)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	value, err := tuple.Unpack(kv.Value)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if len(key) != 2 || len(value) != 2 {
+		return SnapshotInfo{}, fmt.Errorf("malformed_snapshot %v %v", key, value)
+	}
+	name, ok := key[1].(string)
+	epoch, ok2 := value[0].(int64)
+	created, ok3 := value[1].(int64)
+	if !ok || !ok2 || !ok3 {
+		return SnapshotInfo{}, fmt.Errorf("malformed_snapshot %v %v", key, value)
+	}
+	return SnapshotInfo{Name: name, Epoch: epoch, Created: time.Unix(0, created)}, nil
+}
+
+// latestSnapshot reads epoch of the latest snapshot, zero when there is none
+func (fs FoundationDbFs) latestSnapshot(r KvReadTransaction) (int64, error) {
+	last, err := r.GetRange(fs.versions().Sub("e"), fdb.RangeOptions{Limit: 1, Reverse: true})
+	if err != nil || len(last) == 0 {
+		return 0, err
+	}
+
+	key, err := fs.versions().Unpack(last[0].Key)
+	if err != nil {
+		return 0, err
+	}
+	if len(key) != 2 {
+		return 0, fmt.Errorf("malformed_snapshot %v", key)
+	}
+	epoch, ok := key[1].(int64)
+	if !ok {
+		return 0, fmt.Errorf("malformed_snapshot %v", key)
+	}
+	return epoch, nil
+}
+
+// snapshotEpochs reads epochs of all snapshots in ascending order
+func (fs FoundationDbFs) snapshotEpochs(r KvReadTransaction) ([]int64, error) {
+	kvs, err := r.GetRange(fs.versions().Sub("e"), fdb.RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	epochs := make([]int64, len(kvs))
+	for i, kv := range kvs {
+		key, err := fs.versions().Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 2 {
+			return nil, fmt.Errorf("malformed_snapshot %v", key)
+		}
+		epoch, ok := key[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("malformed_snapshot %v", key)
+		}
+		epochs[i] = epoch
+	}
+	return epochs, nil
+}
+
+// versioned tells whether key is a node or entry key, snapshots see them as they were. It returns key relative
+// to root and the node key belongs to, the parent for entries.
+func (fs FoundationDbFs) versioned(key fdb.Key) ([]byte, int64, bool) {
+	for _, prefix := range [][]byte{fs.root.Sub("n").Bytes(), fs.root.Sub("e").Bytes()} {
+		if bytes.HasPrefix(key, prefix) {
+			id, ok := leadingInt(key[len(prefix):])
+			return key[len(fs.root.Bytes()):], id, ok
+		}
+	}
+	return nil, noNode, false
+}
+
+// versionedRange returns bounds of a range of node or entry keys relative to root
+func (fs FoundationDbFs) versionedRange(begin fdb.Key, end fdb.Key) ([]byte, []byte, bool) {
+	for _, prefix := range [][]byte{fs.root.Sub("n").Bytes(), fs.root.Sub("e").Bytes()} {
+		if bytes.HasPrefix(begin, prefix) && bytes.HasPrefix(end, prefix) {
+			return begin[len(fs.root.Bytes()):], end[len(fs.root.Bytes()):], true
+		}
+	}
+	return nil, nil, false
+}
+
+// leadingInt decodes the integer tuple element b starts with
+func leadingInt(b []byte) (int64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := int(b[0]) - 0x14
+	if n < 0 {
+		n = -n
+	}
+	if n > 8 || len(b) < n+1 {
+		return 0, false
+	}
+	t, err := tuple.Unpack(b[:n+1])
+	if err != nil || len(t) != 1 {
+		return 0, false
+	}
+	i, ok := t[0].(int64)
+	return i, ok
+}
+
+// preservedRange is the range of copies of keys between relative bounds
+func (fs FoundationDbFs) preservedRange(begin []byte, end []byte) fdb.KeyRange {
+	return fdb.KeyRange{Begin: fs.preserved().Pack(tuple.Tuple{begin}), End: fs.preserved().Pack(tuple.Tuple{end})}
+}
+
+func (fs FoundationDbFs) unpackPreserved(key fdb.Key) ([]byte, int64, error) {
+	t, err := fs.preserved().Unpack(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(t) != 2 {
+		return nil, 0, fmt.Errorf("malformed_copy %v", t)
+	}
+	rel, ok := t[0].([]byte)
+	epoch, ok2 := t[1].(int64)
+	if !ok || !ok2 {
+		return nil, 0, fmt.Errorf("malformed_copy %v", t)
+	}
+	return rel, epoch, nil
+}
+
+// packPreserved marks whether the copied key existed
+func packPreserved(value []byte) []byte {
+	if value == nil {
+		return []byte{0}
+	}
+	return append([]byte{1}, value...)
+}
+
+func unpackPreserved(value []byte) ([]byte, bool) {
+	if len(value) == 0 || value[0] == 0 {
+		return nil, false
+	}
+	return value[1:], true
+}
+
+// transact runs f in a transaction that copies values snapshots see before f overwrites them
+func (fs FoundationDbFs) transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	return fs.staging(func() (interface{}, error) {
+		return fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			return fs.cow(tx, f)
+		})
+	})
+}
+
+// cow runs f over tx wrapped in cowTransaction
+func (fs FoundationDbFs) cow(tx KvTransaction, f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	cow := &cowTransaction{KvTransaction: tx, fs: fs}
+	ret, err := f(cow)
+	if err == nil {
+		err = cow.err
+	}
+	return ret, err
+}
+
+// cowTransaction copies node and entry keys unchanged since the latest snapshot before their first write, and
+// numbers changes the transaction logs. Writes cannot fail, so the first error of a copy is kept for transact to
+// return.
+type cowTransaction struct {
+	KvTransaction
+	fs FoundationDbFs
+	// changes counts changes logged by the transaction, see changed
+	changes int
+	// latest is the epoch of the latest snapshot, read on the first write of a node or entry key
+	latest *int64
+	// reached caches whether snapshots reach a node, see reaches
+	reached map[int64]bool
+	err     error
+}
+
+func (tx *cowTransaction) Set(key fdb.KeyConvertible, value []byte) {
+	tx.preserve(key.FDBKey())
+	tx.KvTransaction.Set(key, value)
+}
+
+func (tx *cowTransaction) Clear(key fdb.KeyConvertible) {
+	tx.preserve(key.FDBKey())
+	tx.KvTransaction.Clear(key)
+}
+
+func (tx *cowTransaction) ClearRange(r fdb.ExactRange) {
+	tx.preserveRange(r)
+	tx.KvTransaction.ClearRange(r)
+}
+
+func (tx *cowTransaction) Add(key fdb.KeyConvertible, param []byte) {
+	tx.preserve(key.FDBKey())
+	tx.KvTransaction.Add(key, param)
+}
+
+func (tx *cowTransaction) Max(key fdb.KeyConvertible, param []byte) {
+	tx.preserve(key.FDBKey())
+	tx.KvTransaction.Max(key, param)
+}
+
+func (tx *cowTransaction) Min(key fdb.KeyConvertible, param []byte) {
+	tx.preserve(key.FDBKey())
+	tx.KvTransaction.Min(key, param)
+}
+
+// snapshot returns epoch of the latest snapshot, zero when there is none or snapshots are not enabled
+func (tx *cowTransaction) snapshot() (int64, error) {
+	if !tx.fs.snapshots {
+		return 0, nil
+	}
+	if tx.latest == nil {
+		latest, err := tx.fs.latestSnapshot(tx.KvTransaction)
+		if err != nil {
+			return 0, err
+		}
+		tx.latest = &latest
+	}
+	return *tx.latest, nil
+}
+
+// born marks node allocated by the transaction with the epoch of the latest snapshot, no snapshot reaches it
+func (tx *cowTransaction) born(id int64) error {
+	latest, err := tx.snapshot()
+	if err != nil || latest == 0 {
+		return err
+	}
+	tx.KvTransaction.Set(tx.fs.node(id).Pack(bornKey), tuple.Tuple{latest}.Pack())
+	if tx.reached == nil {
+		tx.reached = make(map[int64]bool)
+	}
+	tx.reached[id] = false
+	return nil
+}
+
+// reaches tells whether the latest snapshot, and so some snapshot, reaches node. Nodes without epoch of
+// allocation were allocated before any snapshot.
+func (tx *cowTransaction) reaches(id int64, latest int64) (bool, error) {
+	if reached, ok := tx.reached[id]; ok {
+		return reached, nil
+	}
+
+	value, err := tx.KvTransaction.Get(tx.fs.node(id).Pack(bornKey)).Get()
+	if err != nil {
+		return false, err
+	}
+	reached := true
+	if value != nil {
+		t, err := tuple.Unpack(value)
+		if err != nil {
+			return false, err
+		}
+		if len(t) != 1 {
+			return false, fmt.Errorf("malformed_node_epoch %v", t)
+		}
+		born, ok := t[0].(int64)
+		if !ok {
+			return false, fmt.Errorf("malformed_node_epoch %v", t)
+		}
+		reached = born < latest
+	}
+
+	if tx.reached == nil {
+		tx.reached = make(map[int64]bool)
+	}
+	tx.reached[id] = reached
+	return reached, nil
+}
+
+func (tx *cowTransaction) preserve(key fdb.Key) {
+	rel, id, ok := tx.fs.versioned(key)
+	if !ok || tx.err != nil {
+		return
+	}
+	latest, err := tx.snapshot()
+	if err != nil || latest == 0 {
+		tx.err = err
+		return
+	}
+	reached, err := tx.reaches(id, latest)
+	if err != nil || !reached {
+		tx.err = err
+		return
+	}
+
+	// a copy of the latest epoch or a later one means key was copied already
+	copies := tx.fs.preserved().Sub(rel)
+	_, end := copies.FDBRangeKeys()
+	existing, err := tx.GetRange(fdb.KeyRange{Begin: copies.Pack(tuple.Tuple{latest}), End: end}, fdb.RangeOptions{Limit: 1})
+	if err != nil || len(existing) > 0 {
+		tx.err = err
+		return
+	}
+
+	value, err := tx.Get(key).Get()
+	if err != nil {
+		tx.err = err
+		return
+	}
+	tx.KvTransaction.Set(copies.Pack(tuple.Tuple{latest}), packPreserved(value))
+}
+
+func (tx *cowTransaction) preserveRange(r fdb.ExactRange) {
+	b, e := r.FDBRangeKeys()
+	begin, end, ok := tx.fs.versionedRange(b.FDBKey(), e.FDBKey())
+	if !ok || tx.err != nil {
+		return
+	}
+	latest, err := tx.snapshot()
+	if err != nil || latest == 0 {
+		tx.err = err
+		return
+	}
+
+	live, err := tx.GetRange(r, fdb.RangeOptions{})
+	if err != nil {
+		tx.err = err
+		return
+	}
+	if len(live) == 0 {
+		return
+	}
+	copies, err := tx.GetRange(tx.fs.preservedRange(begin, end), fdb.RangeOptions{})
+	if err != nil {
+		tx.err = err
+		return
+	}
+
+	copied := make(map[string]bool, len(copies))
+	for _, kv := range copies {
+		rel, epoch, err := tx.fs.unpackPreserved(kv.Key)
+		if err != nil {
+			tx.err = err
+			return
+		}
+		if epoch >= latest {
+			copied[string(rel)] = true
+		}
+	}
+
+	for _, kv := range live {
+		rel, id, ok := tx.fs.versioned(kv.Key)
+		if !ok || copied[string(rel)] {
+			continue
+		}
+		reached, err := tx.reaches(id, latest)
+		if err != nil {
+			tx.err = err
+			return
+		}
+		if reached {
+			tx.KvTransaction.Set(tx.fs.preserved().Pack(tuple.Tuple{rel, latest}), packPreserved(kv.Value))
+		}
+	}
+}
+
+// retain keeps a node removed after the latest snapshot for the snapshots, it is left unlinked until collect
+// drops it. Nodes allocated after the latest snapshot are not retained.
+func (tx *cowTransaction) retain(id int64) (bool, error) {
+	latest, err := tx.snapshot()
+	if err != nil || latest == 0 {
+		return false, err
+	}
+	if reached, err := tx.reaches(id, latest); err != nil || !reached {
+		return false, err
+	}
+	tx.KvTransaction.Set(tx.fs.versions().Pack(tuple.Tuple{"d", latest, id}), []byte{})
+	return true, nil
+}
+
+// collect drops removed nodes and copies no snapshot references anymore, transaction by transaction
+func (fs FoundationDbFs) collect() error {
+	for {
+		done, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			return fs.collectRemoved(tx)
+		})
+		if err != nil {
+			return err
+		}
+		if done.(bool) {
+			break
+		}
+	}
+
+	b, e := fs.preserved().FDBRangeKeys()
+	begin, end := b.FDBKey(), e.FDBKey()
+	for begin != nil {
+		next, err := fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			return fs.collectPreserved(tx, begin, end)
+		})
+		if err != nil {
+			return err
+		}
+		begin = next.(fdb.Key)
+	}
+	return nil
+}
+
+// collectRemoved drops the first removed node that no snapshot reaches, snapshots taken before its removal do
+func (fs FoundationDbFs) collectRemoved(tx KvTransaction) (bool, error) {
+	epochs, err := fs.snapshotEpochs(tx)
+	if err != nil {
+		return false, err
+	}
+
+	removed := fs.versions().Sub("d")
+	begin, end := removed.FDBRangeKeys()
+	if len(epochs) > 0 {
+		end = removed.Pack(tuple.Tuple{epochs[0]})
+	}
+	first, err := tx.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: 1})
+	if err != nil || len(first) == 0 {
+		return true, err
+	}
+
+	key, err := removed.Unpack(first[0].Key)
+	if err != nil {
+		return false, err
+	}
+	if len(key) != 2 {
+		return false, fmt.Errorf("malformed_removed_node %v", key)
+	}
+	id, ok := key[1].(int64)
+	if !ok {
+		return false, fmt.Errorf("malformed_removed_node %v", key)
+	}
+	tx.Clear(first[0].Key)
+	return false, fs.removeTree(tx, id)
+}
+
+// collectPreserved drops copies of a batch no snapshot sees and returns where the next batch begins, nil after
+// the last one. Copy of epoch e is seen by snapshots above the epoch of the preceding copy of the key up to e.
+func (fs FoundationDbFs) collectPreserved(tx KvTransaction, begin fdb.Key, end fdb.Key) (fdb.Key, error) {
+	epochs, err := fs.snapshotEpochs(tx)
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := tx.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: gcBatch})
+	if err != nil {
+		return nil, err
+	}
+
+	type preservedCopy struct {
+		key   fdb.Key
+		rel   []byte
+		epoch int64
+	}
+	copies := make([]preservedCopy, len(kvs))
+	for i, kv := range kvs {
+		copies[i].key = kv.Key
+		if copies[i].rel, copies[i].epoch, err = fs.unpackPreserved(kv.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	// copies of the last key of a full batch may go on in the next one, they are left for it unless the
+	// batch has nothing else
+	var next fdb.Key
+	if len(copies) == gcBatch {
+		last := len(copies) - 1
+		cut := last
+		for cut > 0 && bytes.Equal(copies[cut-1].rel, copies[last].rel) {
+			cut--
+		}
+		if cut > 0 {
+			next, copies = copies[cut].key, copies[:cut]
+		} else {
+			next = append(append(fdb.Key{}, copies[last].key...), 0x00)
+		}
+	}
+
+	// epochs start at one, so zero precedes the first copy of a key
+	var prev int64
+	for i, c := range copies {
+		if i > 0 && !bytes.Equal(copies[i-1].rel, c.rel) {
+			prev = 0
+		}
+		seen := sort.Search(len(epochs), func(j int) bool { return epochs[j] > prev })
+		if seen == len(epochs) || epochs[seen] > c.epoch {
+			tx.Clear(c.key)
+		}
+		prev = c.epoch
+	}
+	return next, nil
+}
+
+// snapshotStore reads the filesystem as snapshot of epoch saw it, writes fail with syscall.EROFS
+type snapshotStore struct {
+	store KvStore
+	fs    FoundationDbFs
+	epoch int64
+}
+
+func (s snapshotStore) ReadTransact(f func(KvReadTransaction) (interface{}, error)) (interface{}, error) {
+	return s.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		return f(snapshotRead{r: r, fs: s.fs, epoch: s.epoch})
+	})
+}
+
+func (s snapshotStore) Transact(f func(KvTransaction) (interface{}, error)) (interface{}, error) {
+	return readOnlyStore{s}.Transact(f)
+}
+
+// snapshotRead merges live values with copies of a snapshot
+type snapshotRead struct {
+	r     KvReadTransaction
+	fs    FoundationDbFs
+	epoch int64
+}
+
+// readyFuture is a value that was read already
+type readyFuture struct {
+	value []byte
+	err   error
+}
+
+func (f readyFuture) Get() ([]byte, error) {
+	return f.value, f.err
+}
+
+func (s snapshotRead) Snapshot() KvReadTransaction {
+	return snapshotRead{r: s.r.Snapshot(), fs: s.fs, epoch: s.epoch}
+}
+
+func (s snapshotRead) Get(key fdb.KeyConvertible) FutureGetter {
+	rel, _, ok := s.fs.versioned(key.FDBKey())
+	if !ok {
+		return s.r.Get(key)
+	}
+
+	copies := s.fs.preserved().Sub(rel)
+	_, end := copies.FDBRangeKeys()
+	seen, err := s.r.GetRange(fdb.KeyRange{Begin: copies.Pack(tuple.Tuple{s.epoch}), End: end}, fdb.RangeOptions{Limit: 1})
+	if err != nil {
+		return readyFuture{err: err}
+	}
+	if len(seen) == 0 {
+		return s.r.Get(key)
+	}
+	value, _ := unpackPreserved(seen[0].Value)
+	return readyFuture{value: value}
+}
+
+func (s snapshotRead) GetRange(r fdb.ExactRange, options fdb.RangeOptions) ([]fdb.KeyValue, error) {
+	b, e := r.FDBRangeKeys()
+	begin, end := b.FDBKey(), e.FDBKey()
+	if _, _, ok := s.fs.versionedRange(begin, end); !ok {
+		return s.r.GetRange(r, options)
+	}
+
+	var result []fdb.KeyValue
+	for bytes.Compare(begin, end) < 0 && (options.Limit <= 0 || len(result) < options.Limit) {
+		kvs, lo, hi, err := s.page(begin, end, options.Reverse)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, kvs...)
+		if options.Reverse {
+			end = lo
+		} else {
+			begin = hi
+		}
+	}
+
+	if options.Limit > 0 && len(result) > options.Limit {
+		result = result[:options.Limit]
+	}
+	return result, nil
+}
+
+// page reads a window of [begin, end) from its start, or from its end when reverse is set. Window is bounded by
+// the last live key read and by the last copied key read, whose other copies may be past the page. It returns
+// values of the window in order together with its bounds.
+func (s snapshotRead) page(begin fdb.Key, end fdb.Key, reverse bool) ([]fdb.KeyValue, fdb.Key, fdb.Key, error) {
+	root := len(s.fs.root.Bytes())
+	options := fdb.RangeOptions{Limit: snapshotPage, Reverse: reverse}
+	live, err := s.r.GetRange(fdb.KeyRange{Begin: begin, End: end}, options)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	copies, err := s.r.GetRange(s.fs.preservedRange(begin[root:], end[root:]), options)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lo, hi := begin, end
+	if len(live) == snapshotPage {
+		last := live[len(live)-1].Key
+		if reverse {
+			lo = last
+		} else {
+			hi = append(append(fdb.Key{}, last...), 0x00)
+		}
+	}
+	if len(copies) == snapshotPage {
+		rel, _, err := s.fs.unpackPreserved(copies[len(copies)-1].Key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		last := fdb.Key(append(append([]byte{}, s.fs.root.Bytes()...), rel...))
+		switch {
+		case reverse && bytes.Compare(last, lo) >= 0:
+			lo = append(last, 0x00)
+		case !reverse && bytes.Compare(last, hi) < 0:
+			hi = last
+		}
+
+		// a page of copies of a single key, all of them are read
+		if bytes.Compare(lo, hi) >= 0 {
+			lo, hi = last, append(append(fdb.Key{}, last...), 0x00)
+			if copies, err = s.r.GetRange(s.fs.preservedRange(rel, append(rel, 0x00)), fdb.RangeOptions{}); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	type merged struct {
+		value []byte
+		epoch int64
+	}
+	values := make(map[string]merged)
+	for _, kv := range live {
+		if bytes.Compare(kv.Key, lo) >= 0 && bytes.Compare(kv.Key, hi) < 0 {
+			values[string(kv.Key)] = merged{value: kv.Value}
+		}
+	}
+	for _, kv := range copies {
+		rel, epoch, err := s.fs.unpackPreserved(kv.Key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		key := string(s.fs.root.Bytes()) + string(rel)
+		if epoch < s.epoch || key < string(lo) || key >= string(hi) {
+			continue
+		}
+		if current, ok := values[key]; ok && current.epoch > 0 && current.epoch < epoch {
+			continue
+		}
+		value, exists := unpackPreserved(kv.Value)
+		if !exists {
+			value = nil
+		}
+		values[key] = merged{value: value, epoch: epoch}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, m := range values {
+		if m.value != nil || m.epoch == 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	kvs := make([]fdb.KeyValue, len(keys))
+	for i, key := range keys {
+		if reverse {
+			i = len(keys) - 1 - i
+		}
+		kvs[i] = fdb.KeyValue{Key: fdb.Key(key), Value: values[key].value}
+	}
+	return kvs, lo, hi, nil
+}
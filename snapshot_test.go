@@ -0,0 +1,151 @@
+package billyfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestSnapshot() {
+	fs := s.subFs("snapshot", WithSnapshots())
+
+	s.Require().NoError(util.WriteFile(fs, "/a", []byte("one"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/dir/b", []byte("b"), 0644))
+	s.Require().NoError(fs.Snapshot("first"))
+	s.True(os.IsExist(fs.Snapshot("first")), "Snapshot names are unique")
+
+	s.Require().NoError(util.WriteFile(fs, "/a", []byte("two!"), 0644))
+	s.Require().NoError(fs.Remove("/dir/b"))
+	s.Require().NoError(fs.Remove("/dir"))
+	s.Require().NoError(util.WriteFile(fs, "/c", []byte("c"), 0644))
+	s.Require().NoError(fs.Rename("/a", "/renamed"))
+
+	view, err := fs.OpenSnapshot("first")
+	s.Require().NoError(err)
+	content, err := util.ReadFile(view, "/a")
+	s.Require().NoError(err)
+	s.Equal("one", string(content))
+	content, err = util.ReadFile(view, "/dir/b")
+	s.Require().NoError(err)
+	s.Equal("b", string(content), "Removed files are kept for snapshots")
+	_, err = view.Stat("/c")
+	s.True(os.IsNotExist(err), "Files created later are not in snapshot, got %v", err)
+	infos, err := view.ReadDir("/")
+	s.Require().NoError(err)
+	s.Equal([]string{"a", "dir"}, names(infos))
+
+	content, err = util.ReadFile(fs, "/renamed")
+	s.Require().NoError(err)
+	s.Equal("two!", string(content))
+
+	err = util.WriteFile(view, "/a", []byte("three"), 0644)
+	s.True(errors.Is(err, syscall.EROFS), "Snapshot is read-only, got %v", err)
+	s.True(errors.Is(view.Remove("/a"), syscall.EROFS))
+	content, err = util.ReadFile(view, "/a")
+	s.Require().NoError(err)
+	s.Equal("one", string(content))
+
+	_, err = fs.OpenSnapshot("missing")
+	s.True(os.IsNotExist(err))
+}
+
+func (s *FsTestSuite) TestSnapshotsNeedOption() {
+	err := s.subFs("nosnapshots").Snapshot("first")
+	s.True(errors.Is(err, syscall.ENOTSUP), "Snapshots are not enabled, got %v", err)
+}
+
+func (s *FsTestSuite) TestDeleteSnapshot() {
+	fs := s.subFs("snapshots", WithSnapshots())
+
+	s.Require().NoError(util.WriteFile(fs, "/f", []byte("1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/gone", []byte("gone"), 0644))
+	s.Require().NoError(fs.Snapshot("first"))
+	s.Require().NoError(util.WriteFile(fs, "/f", []byte("2"), 0644))
+	s.Require().NoError(fs.Remove("/gone"))
+	s.Require().NoError(fs.Snapshot("second"))
+	s.Require().NoError(util.WriteFile(fs, "/f", []byte("3"), 0644))
+
+	list, err := fs.ListSnapshots()
+	s.Require().NoError(err)
+	s.Require().Len(list, 2)
+	s.Equal("first", list[0].Name)
+	s.Equal("second", list[1].Name)
+	s.Less(list[0].Epoch, list[1].Epoch)
+
+	copies := s.keys(fs.preserved())
+	s.Require().NoError(fs.DeleteSnapshot("first"))
+	s.True(os.IsNotExist(fs.DeleteSnapshot("first")))
+	s.Less(s.keys(fs.preserved()), copies, "Copies only first saw are collected")
+	s.Zero(s.keys(fs.versions().Sub("d")), "Removed file only first saw is collected")
+
+	view, err := fs.OpenSnapshot("second")
+	s.Require().NoError(err)
+	content, err := util.ReadFile(view, "/f")
+	s.Require().NoError(err)
+	s.Equal("2", string(content))
+	_, err = view.Stat("/gone")
+	s.True(os.IsNotExist(err))
+
+	s.Require().NoError(fs.DeleteSnapshot("second"))
+	s.Zero(s.keys(fs.preserved()), "Copies are collected")
+	s.Zero(s.keys(fs.versions().Sub("d")), "Removed nodes are collected")
+	list, err = fs.ListSnapshots()
+	s.Require().NoError(err)
+	s.Empty(list)
+
+	content, err = util.ReadFile(fs, "/f")
+	s.Require().NoError(err)
+	s.Equal("3", string(content))
+}
+
+func (s *FsTestSuite) TestSnapshotPages() {
+	fs := s.subFs("snapshotpages", WithSnapshots())
+
+	// both live entries and copies of removed ones take more than a page
+	count := 2*snapshotPage + snapshotPage/2
+	for i := 0; i < count; i++ {
+		s.Require().NoError(util.WriteFile(fs, fmt.Sprintf("/dir/%04d", i), nil, 0644))
+	}
+	s.Require().NoError(fs.Snapshot("pages"))
+	for i := 0; i < count; i += 2 {
+		s.Require().NoError(fs.Remove(fmt.Sprintf("/dir/%04d", i)))
+	}
+	s.Require().NoError(util.WriteFile(fs, "/dir/new", nil, 0644))
+
+	view, err := fs.OpenSnapshot("pages")
+	s.Require().NoError(err)
+	infos, err := view.ReadDir("/dir")
+	s.Require().NoError(err)
+	s.Require().Len(infos, count)
+	for i := range infos {
+		s.Equal(fmt.Sprintf("%04d", i), infos[i].Name())
+	}
+
+	s.Require().NoError(fs.DeleteSnapshot("pages"))
+	s.Zero(s.keys(fs.preserved()), "Copies are collected")
+	s.Zero(s.keys(fs.versions().Sub("d")), "Removed files are collected")
+	infos, err = fs.ReadDir("/dir")
+	s.Require().NoError(err)
+	s.Len(infos, count/2+1)
+}
+
+// keys counts keys of a range
+func (s *FsTestSuite) keys(r fdb.ExactRange) int {
+	kvs, err := s.fdbfs.store.ReadTransact(func(r2 KvReadTransaction) (interface{}, error) {
+		return r2.GetRange(r, fdb.RangeOptions{})
+	})
+	s.Require().NoError(err)
+	return len(kvs.([]fdb.KeyValue))
+}
+
+func names(infos []os.FileInfo) []string {
+	result := make([]string, len(infos))
+	for i := range infos {
+		result[i] = infos[i].Name()
+	}
+	return result
+}
@@ -0,0 +1,220 @@
+package billyfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// With trash enabled, Remove and Rename replacing its target unlink the node and keep it with all its descendants
+// instead of removing it. Unlinked node is invisible to path lookups and listings, its trash entry is
+// ("t", id) holding tuple of the original path and deletion time in unix nanoseconds.
+
+// trashMode configures trash, see WithTrash
+type trashMode struct {
+	enabled bool
+	// retention is how long removed nodes are kept, zero keeps them until purged
+	retention time.Duration
+}
+
+// TrashEntry is a removed node kept in trash
+type TrashEntry struct {
+	Node    int64
+	Path    string
+	Deleted time.Time
+}
+
+func (fs FoundationDbFs) trashed() subspace.Subspace {
+	return fs.root.Sub("t")
+}
+
+// discard removes unlinked node, with trash enabled it is moved to trash instead
+func (fs FoundationDbFs) discard(tx KvTransaction, id int64, fsPath []string) error {
+	if !fs.trashing.enabled {
+		return fs.removeTree(tx, id)
+	}
+	tx.Set(fs.trashed().Pack(tuple.Tuple{id}), tuple.Tuple{joinPath(fsPath), time.Now().UnixNano()}.Pack())
+	return nil
+}
+
+// Trash lists removed nodes kept in trash from the earliest removed
+func (fs FoundationDbFs) Trash() ([]TrashEntry, error) {
+	entries, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+		kvs, err := r.GetRange(fs.trashed(), fdb.RangeOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return fs.unpackTrash(kvs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := entries.([]TrashEntry)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Deleted.Before(list[j].Deleted)
+	})
+	return list, nil
+}
+
+// RestoreTrash links node removed to trash back at path, empty path is the path it was removed from. Missing
+// parent directories are created, path that exists already fails with os.ErrExist.
+func (fs FoundationDbFs) RestoreTrash(node int64, path string) error {
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		entry, err := fs.trashEntry(tx, node)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			path = entry.Path
+		}
+		fsPath := fs.split(path)
+		if len(fsPath) == 0 {
+			return nil, os.ErrExist
+		}
+
+		if _, err = fs.mkdirAll(tx, fsPath[0:len(fsPath)-1], &fileModeApplicator{perm: defaultDirectoryMode}); err != nil {
+			return nil, err
+		}
+		res, err := fs.resolve(tx, fsPath, false)
+		if err != nil {
+			return nil, err
+		}
+		if res.id != noNode {
+			return nil, os.ErrExist
+		}
+
+		tx.Clear(fs.trashed().Pack(tuple.Tuple{node}))
+		fs.link(tx, res.parent, res.name, node)
+		fs.changed(tx, ChangeCreate, node, res.path, nil)
+		return nil, nil
+	})
+	if err != nil {
+		return &os.PathError{Op: "restore", Path: path, Err: err}
+	}
+	return nil
+}
+
+// PurgeTrash removes node kept in trash for good, large trees are removed in batches as by RemoveAll
+func (fs FoundationDbFs) PurgeTrash(node int64) error {
+	_, err := fs.transact(func(tx KvTransaction) (interface{}, error) {
+		if _, err := fs.trashEntry(tx, node); err != nil {
+			return nil, err
+		}
+		tx.Clear(fs.trashed().Pack(tuple.Tuple{node}))
+		tx.Set(fs.removals().Pack(tuple.Tuple{node}), []byte{})
+		return nil, nil
+	})
+	if err == nil {
+		err = fs.drain(node)
+	}
+	if err != nil {
+		return &os.PathError{Op: "purge", Path: fmt.Sprint(node), Err: err}
+	}
+	return nil
+}
+
+// ExpireTrash purges nodes kept in trash longer than the retention of WithTrash and returns how many were purged.
+// Every node is purged in a transaction of its own.
+func (fs FoundationDbFs) ExpireTrash() (int, error) {
+	if !fs.trashing.enabled || fs.trashing.retention <= 0 {
+		return 0, nil
+	}
+
+	purged := 0
+	expired := time.Now().Add(-fs.trashing.retention)
+	begin, end := fs.trashed().FDBRangeKeys()
+	for {
+		// cursor moves once the read succeeded, a retried read starts from the same one
+		batch, err := fs.store.ReadTransact(func(r KvReadTransaction) (interface{}, error) {
+			return r.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{Limit: gcBatch})
+		})
+		if err != nil {
+			return purged, err
+		}
+		kvs := batch.([]fdb.KeyValue)
+		if len(kvs) == 0 {
+			return purged, nil
+		}
+		begin = append(kvs[len(kvs)-1].Key, 0x00)
+		entries, err := fs.unpackTrash(kvs)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, entry := range entries {
+			if !entry.Deleted.Before(expired) {
+				continue
+			}
+			// purged or restored meanwhile
+			if err = fs.PurgeTrash(entry.Node); err != nil && !os.IsNotExist(err) {
+				return purged, err
+			}
+			if err == nil {
+				purged++
+			}
+		}
+		if len(kvs) < gcBatch {
+			return purged, nil
+		}
+	}
+}
+
+// TrashExpirer runs ExpireTrash in the background
+type TrashExpirer struct {
+	*job
+}
+
+// StartTrashExpirer purges expired trash every interval until the expirer is closed
+func (fs FoundationDbFs) StartTrashExpirer(interval time.Duration) *TrashExpirer {
+	return &TrashExpirer{startJob(interval, func() error {
+		_, err := fs.ExpireTrash()
+		return err
+	})}
+}
+
+func (fs FoundationDbFs) trashEntry(r KvReadTransaction, node int64) (TrashEntry, error) {
+	key := fs.trashed().Pack(tuple.Tuple{node})
+	value, err := r.Get(key).Get()
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if value == nil {
+		return TrashEntry{}, os.ErrNotExist
+	}
+	entries, err := fs.unpackTrash([]fdb.KeyValue{{Key: key, Value: value}})
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	return entries[0], nil
+}
+
+func (fs FoundationDbFs) unpackTrash(kvs []fdb.KeyValue) ([]TrashEntry, error) {
+	entries := make([]TrashEntry, len(kvs))
+	for i, kv := range kvs {
+		key, err := fs.trashed().Unpack(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := tuple.Unpack(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 1 || len(value) != 2 {
+			return nil, fmt.Errorf("malformed_trash %v %v", key, value)
+		}
+		node, ok := key[0].(int64)
+		p, ok2 := value[0].(string)
+		deleted, ok3 := value[1].(int64)
+		if !ok || !ok2 || !ok3 {
+			return nil, fmt.Errorf("malformed_trash %v %v", key, value)
+		}
+		entries[i] = TrashEntry{Node: node, Path: p, Deleted: time.Unix(0, deleted)}
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package billyfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestTrash() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("trash")
+	fs.trashing = trashMode{enabled: true}
+
+	s.Require().NoError(util.WriteFile(fs, "/docs/a", []byte("a"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/docs/b", []byte("b"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/c", []byte("c"), 0644))
+
+	s.Require().NoError(fs.Remove("/docs/a"))
+	s.Require().NoError(fs.Rename("/c", "/docs/b"))
+	_, err := fs.Stat("/docs/a")
+	s.True(os.IsNotExist(err), "Removed file is not visible")
+	infos, err := fs.ReadDir("/docs")
+	s.Require().NoError(err)
+	s.Equal([]string{"b"}, names(infos))
+
+	trash, err := fs.Trash()
+	s.Require().NoError(err)
+	s.Require().Len(trash, 2)
+	s.Equal("/docs/a", trash[0].Path)
+	s.Equal("/docs/b", trash[1].Path, "Replaced rename target goes to trash as well")
+
+	s.Require().NoError(fs.RestoreTrash(trash[0].Node, ""))
+	content, err := util.ReadFile(fs, "/docs/a")
+	s.Require().NoError(err)
+	s.Equal("a", string(content))
+
+	err = fs.RestoreTrash(trash[1].Node, "")
+	s.True(os.IsExist(err), "Restore does not replace existing file")
+	s.Require().NoError(fs.RestoreTrash(trash[1].Node, "/old/b"))
+	content, err = util.ReadFile(fs, "/old/b")
+	s.Require().NoError(err)
+	s.Equal("b", string(content))
+
+	s.Require().NoError(util.RemoveAll(fs, "/old"))
+	trash, err = fs.Trash()
+	s.Require().NoError(err)
+	s.Require().Len(trash, 1)
+	s.Require().NoError(fs.PurgeTrash(trash[0].Node))
+	s.True(os.IsNotExist(fs.PurgeTrash(trash[0].Node)))
+	s.True(os.IsNotExist(fs.RestoreTrash(trash[0].Node, "")))
+	trash, err = fs.Trash()
+	s.Require().NoError(err)
+	s.Empty(trash)
+}
+
+func (s *FsTestSuite) TestExpireTrash() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("expired")
+	fs.trashing = trashMode{enabled: true, retention: time.Hour}
+
+	s.Require().NoError(util.WriteFile(fs, "/dir/f", []byte("f"), 0644))
+	s.Require().NoError(fs.Remove("/dir/f"))
+	s.Require().NoError(fs.Remove("/dir"))
+	purged, err := fs.ExpireTrash()
+	s.Require().NoError(err)
+	s.Zero(purged, "Trash is retained")
+
+	fs.trashing.retention = time.Nanosecond
+	expirer := fs.StartTrashExpirer(10 * time.Millisecond)
+	defer expirer.Close()
+	s.Eventually(func() bool {
+		trash, err := fs.Trash()
+		return err == nil && len(trash) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	s.NoError(expirer.Err())
+	s.Equal(1, s.keys(fs.root.Sub("n")), "Purged nodes are removed, only the root is left")
+}
+
+func (s *FsTestSuite) TestPurgeTrashIndexes() {
+	fs := s.subFs("purgeindexes", WithTrash(0), WithFileVersions(0, 0))
+	s.Require().NoError(util.WriteFile(fs, "/dir/f", []byte("v1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/dir/f", []byte("v2"), 0644))
+
+	file, err := fs.Open("/dir/f")
+	s.Require().NoError(err)
+	defer file.Close()
+	owner, err := fs.NewLockOwner("")
+	s.Require().NoError(err)
+	defer owner.Release()
+	s.Require().NoError(owner.LockRange(file.(*FoundationDbFile).Handle(), 0, 10, false))
+
+	s.Require().NoError(fs.Remove("/dir/f"))
+	trash, err := fs.Trash()
+	s.Require().NoError(err)
+	s.Require().Len(trash, 1)
+	s.Require().NoError(fs.PurgeTrash(trash[0].Node))
+	s.Zero(s.keys(fs.versionIndex()), "Versions of purged nodes are dropped")
+	s.Zero(s.keys(fs.ownerRanges(owner.ID())), "Range locks of purged nodes are dropped")
+}
@@ -0,0 +1,180 @@
+package billyfs
+
+import (
+	"errors"
+	"os"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// ErrTransactionTooLarge is returned by Transact when the transaction writes more than fits a single commit
+var ErrTransactionTooLarge = errors.New("transaction exceeds size limit")
+
+// errCodeTransactionTooLarge is the fdb error of a commit over 10MB
+const errCodeTransactionTooLarge = 2101
+
+// defaultTransactLimit bounds bytes written by Transact below the 10MB fdb commit limit, leaving headroom for
+// conflict ranges and keys written besides the metered ones
+const defaultTransactLimit = 8 << 20
+
+// FsTx is a batch of filesystem operations committed together by Transact. Operations see writes of the
+// operations before them.
+type FsTx interface {
+	// Mkdir creates directory with missing parents, existing directory is not an error
+	Mkdir(path string, perm os.FileMode) error
+	// Create creates an empty file or truncates an existing one
+	Create(path string) error
+	// WriteFile replaces content of a file, missing file is created with perm
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// Remove removes a file or an empty directory
+	Remove(path string) error
+	// Rename renames path, target is replaced when it is a file or an empty directory
+	Rename(from string, to string) error
+	Stat(path string) (os.FileInfo, error)
+}
+
+// Transact runs f in a single transaction, readers see either all of its operations or none of them. Error of f
+// discards the operations. Conflicting transactions are retried, so f may run several times and should not have
+// effects outside of tx. Bytes of all keys and values written by f, file data as well as metadata, change log and
+// copies kept for snapshots or versions, are bounded by WithTransactLimit. Larger batches fail with
+// ErrTransactionTooLarge, as do batches fdb refuses to commit.
+func (fs FoundationDbFs) Transact(f func(tx FsTx) error) error {
+	_, err := fs.staging(func() (interface{}, error) {
+		return fs.store.Transact(func(tx KvTransaction) (interface{}, error) {
+			metered := &meteredTransaction{KvTransaction: tx}
+			return fs.cow(metered, func(t KvTransaction) (interface{}, error) {
+				return nil, f(&fsTx{fs: fs, tx: t, metered: metered, limit: fs.txLimit()})
+			})
+		})
+	})
+
+	var e fdb.Error
+	if errors.As(err, &e) && e.Code == errCodeTransactionTooLarge {
+		return ErrTransactionTooLarge
+	}
+	return err
+}
+
+// txLimit is the limit of WithTransactLimit
+func (fs FoundationDbFs) txLimit() int64 {
+	if fs.transactLimit <= 0 {
+		return defaultTransactLimit
+	}
+	return fs.transactLimit
+}
+
+// fsTx runs operations of FsTx in a single KvTransaction
+type fsTx struct {
+	fs FoundationDbFs
+	tx KvTransaction
+	// metered counts bytes written by the operations, they fail once it is over limit
+	metered *meteredTransaction
+	limit   int64
+}
+
+var _ FsTx = &fsTx{}
+
+// checked fails operation op of path when the transaction has written more than limit
+func (t *fsTx) checked(op string, path string, err error) error {
+	if err == nil && t.metered.written > t.limit {
+		err = ErrTransactionTooLarge
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return nil
+}
+
+func (t *fsTx) Mkdir(path string, perm os.FileMode) error {
+	_, err := t.fs.mkdirAll(t.tx, t.fs.split(path), &fileModeApplicator{perm: perm})
+	return t.checked("mkdir", path, err)
+}
+
+func (t *fsTx) Create(path string) error {
+	_, err := t.fs.open(t.tx, t.fs.split(path), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+	return t.checked("open", path, err)
+}
+
+func (t *fsTx) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if int64(len(data)) > t.limit-t.metered.written {
+		return &os.PathError{Op: "write", Path: path, Err: ErrTransactionTooLarge}
+	}
+
+	id, err := t.fs.open(t.tx, t.fs.split(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	if len(data) == 0 {
+		return t.checked("open", path, nil)
+	}
+
+	if err = t.fs.modified(t.tx, id); err == nil {
+		_, err = asWrite(t.fs.node(id), AsWriteOps(data, 0, int(rEADSIZE)))(t.tx)
+	}
+	return t.checked("write", path, err)
+}
+
+func (t *fsTx) Remove(path string) error {
+	return t.checked("remove", path, t.fs.remove(t.tx, t.fs.split(path)))
+}
+
+func (t *fsTx) Rename(from string, to string) error {
+	err := t.fs.rename(t.tx, t.fs.split(from), t.fs.split(to))
+	if err == nil && t.metered.written > t.limit {
+		err = ErrTransactionTooLarge
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	return nil
+}
+
+func (t *fsTx) Stat(path string) (os.FileInfo, error) {
+	info, err := t.fs.statNode(t.tx, t.fs.split(path), true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	return info, nil
+}
+
+// meteredTransaction counts bytes of keys and values written through it
+type meteredTransaction struct {
+	KvTransaction
+	written int64
+}
+
+func (tx *meteredTransaction) Set(key fdb.KeyConvertible, value []byte) {
+	tx.written += int64(len(key.FDBKey()) + len(value))
+	tx.KvTransaction.Set(key, value)
+}
+
+func (tx *meteredTransaction) Clear(key fdb.KeyConvertible) {
+	tx.written += int64(len(key.FDBKey()))
+	tx.KvTransaction.Clear(key)
+}
+
+func (tx *meteredTransaction) ClearRange(r fdb.ExactRange) {
+	begin, end := r.FDBRangeKeys()
+	tx.written += int64(len(begin.FDBKey()) + len(end.FDBKey()))
+	tx.KvTransaction.ClearRange(r)
+}
+
+func (tx *meteredTransaction) Add(key fdb.KeyConvertible, param []byte) {
+	tx.written += int64(len(key.FDBKey()) + len(param))
+	tx.KvTransaction.Add(key, param)
+}
+
+func (tx *meteredTransaction) Max(key fdb.KeyConvertible, param []byte) {
+	tx.written += int64(len(key.FDBKey()) + len(param))
+	tx.KvTransaction.Max(key, param)
+}
+
+func (tx *meteredTransaction) Min(key fdb.KeyConvertible, param []byte) {
+	tx.written += int64(len(key.FDBKey()) + len(param))
+	tx.KvTransaction.Min(key, param)
+}
+
+func (tx *meteredTransaction) SetVersionstampedKey(key fdb.KeyConvertible, value []byte) {
+	tx.written += int64(len(key.FDBKey()) + len(value))
+	tx.KvTransaction.SetVersionstampedKey(key, value)
+}
@@ -0,0 +1,90 @@
+package billyfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestTransact() {
+	fs := s.subFs("transact")
+	s.Require().NoError(util.WriteFile(fs, "/release/app", []byte("v1"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/release/old", []byte("old"), 0644))
+
+	s.Require().NoError(fs.Transact(func(tx FsTx) error {
+		if err := tx.Mkdir("/release/conf", 0755); err != nil {
+			return err
+		}
+		if err := tx.WriteFile("/release/app.new", []byte("v2"), 0644); err != nil {
+			return err
+		}
+		if err := tx.Rename("/release/app.new", "/release/app"); err != nil {
+			return err
+		}
+		if err := tx.Remove("/release/old"); err != nil {
+			return err
+		}
+		if err := tx.Create("/release/conf/empty"); err != nil {
+			return err
+		}
+		info, err := tx.Stat("/release/app")
+		if err != nil {
+			return err
+		}
+		s.EqualValues(2, info.Size(), "Operations see writes of the transaction")
+		return tx.WriteFile("/release/manifest", []byte("app v2"), 0644)
+	}))
+
+	infos, err := fs.ReadDir("/release")
+	s.Require().NoError(err)
+	s.Equal([]string{"app", "conf", "manifest"}, names(infos))
+	content, err := util.ReadFile(fs, "/release/app")
+	s.Require().NoError(err)
+	s.Equal("v2", string(content))
+
+	failed := errors.New("failed")
+	err = fs.Transact(func(tx FsTx) error {
+		if err := tx.WriteFile("/release/app", []byte("v3"), 0644); err != nil {
+			return err
+		}
+		return failed
+	})
+	s.Equal(failed, err)
+	content, err = util.ReadFile(fs, "/release/app")
+	s.Require().NoError(err)
+	s.Equal("v2", string(content), "Failed transaction writes nothing")
+
+	err = fs.Transact(func(tx FsTx) error {
+		return tx.Remove("/release")
+	})
+	s.True(errors.Is(err, syscall.ENOTEMPTY), "Operations fail the way filesystem ones do, got %v", err)
+
+	small := s.subFs("transact", WithTransactLimit(2*int(rEADSIZE)))
+	err = small.Transact(func(tx FsTx) error {
+		if err := tx.WriteFile("/release/a", make([]byte, rEADSIZE), 0644); err != nil {
+			return err
+		}
+		return tx.WriteFile("/release/b", make([]byte, rEADSIZE), 0644)
+	})
+	s.True(errors.Is(err, ErrTransactionTooLarge), "Data and metadata over the write limit fail, got %v", err)
+	_, err = fs.Stat("/release/a")
+	s.True(os.IsNotExist(err))
+
+	err = small.Transact(func(tx FsTx) error {
+		for i := 0; i < 100; i++ {
+			if err := tx.Mkdir(fmt.Sprintf("/release/dir%v", i), 0755); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.True(errors.Is(err, ErrTransactionTooLarge), "Metadata counts against the write limit, got %v", err)
+
+	batched := s.subFs("transact", WithWriteBatch(int(rEADSIZE)))
+	s.NoError(batched.Transact(func(tx FsTx) error {
+		return tx.WriteFile("/release/large", make([]byte, 4*rEADSIZE), 0644)
+	}), "Write batch does not bound Transact")
+}
@@ -0,0 +1,37 @@
+package billyfs
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func (s *FsTestSuite) TestAtVersion() {
+	fs := *s.fdbfs
+	fs.root = fs.root.Sub("version")
+
+	s.Require().NoError(util.WriteFile(fs, "/a", []byte("one"), 0644))
+	v, err := fs.ReadVersion()
+	s.Require().NoError(err)
+
+	s.Require().NoError(util.WriteFile(fs, "/a", []byte("two"), 0644))
+	s.Require().NoError(util.WriteFile(fs, "/b", []byte("b"), 0644))
+
+	view := fs.AtVersion(v)
+	content, err := util.ReadFile(view, "/a")
+	s.Require().NoError(err)
+	s.Equal("one", string(content))
+	infos, err := view.ReadDir("/")
+	s.Require().NoError(err)
+	s.Equal([]string{"a"}, names(infos))
+	info, err := view.Stat("/a")
+	s.Require().NoError(err)
+	s.EqualValues(3, info.Size())
+
+	err = util.WriteFile(view, "/a", []byte("three"), 0644)
+	s.True(errors.Is(err, syscall.EROFS), "View is read-only, got %v", err)
+	content, err = util.ReadFile(fs, "/a")
+	s.Require().NoError(err)
+	s.Equal("two", string(content))
+}